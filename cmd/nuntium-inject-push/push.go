@@ -1,8 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"strings"
 
+	"github.com/ubuntu-phonedations/nuntium/mms"
+	"github.com/ubuntu-phonedations/nuntium/ofono"
 	"launchpad.net/go-dbus/v1"
 )
 
@@ -19,29 +23,39 @@ func push(endPoint, sender string) error {
 
 	obj := conn.Object(endPoint, "/nuntium")
 
-	data := []byte{
-		0x01, 0x06, 0x26, 0x61, 0x70, 0x70, 0x6C, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f,
-		0x6e, 0x2f, 0x76, 0x6e, 0x64, 0x2e, 0x77, 0x61, 0x70, 0x2e, 0x6d, 0x6d, 0x73,
-		0x2d, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x00, 0xaf, 0x84, 0xb4, 0x86,
-		0xc3, 0x95, 0x8c, 0x82, 0x98, 0x6d, 0x30, 0x34, 0x42, 0x4b, 0x6b, 0x73, 0x69,
-		0x6d, 0x30, 0x35, 0x40, 0x6d, 0x6d, 0x73, 0x2e, 0x70, 0x65, 0x72, 0x73, 0x6f,
-		0x6e, 0x61, 0x6c, 0x2e, 0x63, 0x6f, 0x6d, 0x2e, 0x61, 0x72, 0x00, 0x8d, 0x90,
-		0x89, 0x19, 0x80, 0x2b, 0x35, 0x34, 0x33, 0x35, 0x31, 0x35, 0x39, 0x32, 0x34,
-		0x39, 0x30, 0x36, 0x2f, 0x54, 0x59, 0x50, 0x45, 0x3d, 0x50, 0x4c, 0x4d, 0x4e,
-		0x00, 0x8a, 0x80, 0x8e, 0x02, 0x74, 0x00, 0x88, 0x05, 0x81, 0x03, 0x02, 0xa2,
-		0xff, 0x83,
-		// h     t     t     p     :     /     /
-		0x68, 0x74, 0x74, 0x70, 0x3a, 0x2f, 0x2f,
-		// l     o     c     a     l     h     o     s     t
-		0x6c, 0x6f, 0x63, 0x61, 0x6c, 0x68, 0x6f, 0x73, 0x74,
-		// :     9     1     9     1     /     m     m     s
-		0x3a, 0x39, 0x31, 0x39, 0x31, 0x2f, 0x6d, 0x6d, 0x73, 0x00,
+	// m-notification.ind served from localhost:9191/mms
+	mNotificationInd := &mms.MNotificationInd{
+		Type:            mms.TYPE_NOTIFICATION_IND,
+		TransactionId:   "m04BKksim05@mms.personal.com.ar",
+		Version:         mms.MMS_MESSAGE_VERSION_1_0,
+		From:            strings.Replace(sender, " ", "", -1) + "/TYPE=PLMN",
+		Class:           mms.ClassPersonal,
+		Size:            29696,
+		Expiry:          mms.ExpiryValue{Seconds: 172799},
+		ContentLocation: "http://localhost:9191/mms",
+	}
+	var body bytes.Buffer
+	if err := mms.NewEncoder(&body).Encode(mNotificationInd); err != nil {
+		return err
+	}
+
+	pdu := ofono.PushPDU{
+		TransactionId:   0x01,
+		ContentType:     mms.VND_WAP_MMS_MESSAGE,
+		ApplicationId:   mms.PUSH_APPLICATION_ID,
+		PushFlag:        0x06,
+		EncodingVersion: 0x15,
+		Data:            body.Bytes(),
+	}
+	var data bytes.Buffer
+	if err := ofono.NewEncoder(&data).Encode(&pdu); err != nil {
+		return err
 	}
 
 	info := map[string]*dbus.Variant{"LocalSentTime": &dbus.Variant{"2014-02-05T08:29:55-0300"},
 		"Sender": &dbus.Variant{sender}}
 
-	reply, err := obj.Call(pushInterface, pushMethod, data, info)
+	reply, err := obj.Call(pushInterface, pushMethod, data.Bytes(), info)
 	if err != nil || reply.Type == dbus.TypeError {
 		return fmt.Errorf("notification error: %s", err)
 	}
@@ -27,11 +27,14 @@ import (
 	"log"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/ubuntu-phonedations/nuntium/mms"
 	"github.com/ubuntu-phonedations/nuntium/ofono"
+	"github.com/ubuntu-phonedations/nuntium/provisioning"
 	"github.com/ubuntu-phonedations/nuntium/storage"
 	"github.com/ubuntu-phonedations/nuntium/telepathy"
+	"github.com/ubuntu-phonedations/nuntium/wappush"
 )
 
 type Mediator struct {
@@ -41,8 +44,18 @@ type Mediator struct {
 	NewMSendReq         chan *mms.MSendReq
 	NewMSendReqFile     chan struct{ filePath, uuid string }
 	outMessage          chan *telepathy.OutgoingMessage
+	cancelMessage       chan string
 	terminate           chan bool
+	clientProvisioning  chan *ofono.PushPDU
+	serviceIndication   chan *ofono.PushPDU
 	contextLock         sync.Mutex
+	pendingLock         sync.Mutex
+	pending             map[string]*mms.MNotificationInd
+	sendingLock         sync.Mutex
+	sending             map[string]*outgoingTransfer
+	recovered           map[string]bool
+	transport           mms.Transport
+	retries             *retryScheduler
 }
 
 //TODO these vars need a configuration location managed by system settings or
@@ -53,16 +66,45 @@ var (
 	useDeliveryReports bool
 )
 
+const progressInterval = 2 * time.Second
+
+//outgoingTransfer is a message being sent, cancel is closed when the user
+//cancels it.
+type outgoingTransfer struct {
+	file   string
+	cancel chan struct{}
+}
+
 func NewMediator(modem *ofono.Modem) *Mediator {
 	mediator := &Mediator{modem: modem}
+	modem.Provisioned = provisionedSettings
 	mediator.NewMNotificationInd = make(chan *mms.MNotificationInd)
 	mediator.NewMSendReq = make(chan *mms.MSendReq)
 	mediator.NewMSendReqFile = make(chan struct{ filePath, uuid string })
 	mediator.outMessage = make(chan *telepathy.OutgoingMessage)
+	mediator.cancelMessage = make(chan string)
 	mediator.terminate = make(chan bool)
+	mediator.pending = make(map[string]*mms.MNotificationInd)
+	mediator.sending = make(map[string]*outgoingTransfer)
+	mediator.recovered = make(map[string]bool)
+	mediator.clientProvisioning = modem.PushAgent.Subscribe(ofono.ClientProvisioningPushApplication)
+	mediator.serviceIndication = modem.PushAgent.Subscribe(ofono.ServiceIndicationPushApplication)
+	transferDir, err := storage.TransferDir()
+	if err != nil {
+		log.Print("Cannot create the transfer directory, using the default one: ", err)
+	}
+	mediator.transport = mms.NewTransport(transferDir)
+	mediator.retries = newRetryScheduler(mms.DefaultRetryPolicy)
 	return mediator
 }
 
+//provisionedSettings looks up the settings stored from client provisioning
+//pushes for the modem contexts.
+func provisionedSettings(identity string) (ofono.ProvisionedSettings, error) {
+	settings, err := storage.GetProvisionedSettings(identity)
+	return ofono.ProvisionedSettings(settings), err
+}
+
 func (mediator *Mediator) Delete() {
 	mediator.terminate <- mediator.telepathyService == nil
 }
@@ -76,7 +118,11 @@ mediatorLoop:
 				log.Print("PushChannel is closed")
 				continue
 			}
-			go mediator.handleMNotificationInd(push)
+			go mediator.handlePush(push)
+		case push := <-mediator.clientProvisioning:
+			go mediator.handleClientProvisioning(push)
+		case push := <-mediator.serviceIndication:
+			mediator.handleServiceIndication(push)
 		case mNotificationInd := <-mediator.NewMNotificationInd:
 			if deferredDownload {
 				go mediator.handleDeferredDownload(mNotificationInd)
@@ -85,16 +131,22 @@ mediatorLoop:
 			}
 		case msg := <-mediator.outMessage:
 			go mediator.handleOutgoingMessage(msg)
+		case uuid := <-mediator.cancelMessage:
+			go mediator.cancelMSendReq(uuid)
 		case mSendReq := <-mediator.NewMSendReq:
 			go mediator.handleMSendReq(mSendReq)
 		case mSendReqFile := <-mediator.NewMSendReqFile:
 			go mediator.sendMSendReq(mSendReqFile.filePath, mSendReqFile.uuid)
 		case id := <-mediator.modem.IdentityAdded:
 			var err error
-			mediator.telepathyService, err = mmsManager.AddService(id, mediator.modem.Modem, mediator.outMessage, useDeliveryReports)
+			mediator.telepathyService, err = mmsManager.AddService(id, mediator.modem.Modem, mediator.outMessage, mediator.cancelMessage, useDeliveryReports)
 			if err != nil {
 				log.Fatal(err)
 			}
+			if !mediator.recovered[id] {
+				mediator.recovered[id] = true
+				go mediator.recoverMessages(id)
+			}
 		case id := <-mediator.modem.IdentityRemoved:
 			err := mmsManager.RemoveService(id)
 			if err != nil {
@@ -129,29 +181,311 @@ mediatorLoop:
 	log.Print("Ending mediator instance loop for modem")
 }
 
-func (mediator *Mediator) handleMNotificationInd(pushMsg *ofono.PushPDU) {
+func (mediator *Mediator) handlePush(pushMsg *ofono.PushPDU) {
 	if pushMsg == nil {
 		log.Print("Received nil push")
 		return
 	}
+	msgType, err := mms.GetMessageType(pushMsg.Data)
+	if err != nil {
+		log.Print("Unable to determine pushed message type: ", err)
+		return
+	}
+	switch msgType {
+	case mms.TYPE_CANCEL_REQ:
+		mediator.handleMCancelReq(pushMsg)
+	default:
+		mediator.handleMNotificationInd(pushMsg)
+	}
+}
+
+//handleClientProvisioning stores the MMS settings from an OMA client
+//provisioning document for the modem identity. Only documents authenticated
+//with the network shared secret are accepted as there is no way to request
+//a user PIN.
+func (mediator *Mediator) handleClientProvisioning(pushMsg *ofono.PushPDU) {
+	identity := mediator.modem.Identity()
+	if identity == "" {
+		log.Print("Dropping client provisioning document received without an identity")
+		return
+	}
+	sec, mac := pushMsg.ContentTypeParams["sec"], pushMsg.ContentTypeParams["mac"]
+	if err := provisioning.Validate(pushMsg.Data, sec, mac, identity, ""); err != nil {
+		log.Print("Rejecting client provisioning document: ", err)
+		return
+	}
+	doc, err := provisioning.Parse(pushMsg.Data)
+	if err != nil {
+		log.Print("Unable to parse client provisioning document: ", err)
+		return
+	}
+	settings, err := doc.MMSSettings()
+	if err != nil {
+		log.Print("Ignoring client provisioning document: ", err)
+		return
+	}
+	provisioned := storage.ProvisionedSettings{
+		MessageCenter: settings.MessageCenter,
+		Proxy:         settings.Proxy,
+		ProxyPort:     settings.ProxyPort,
+		ProxyUsername: settings.ProxyUsername,
+		ProxyPassword: settings.ProxyPassword,
+		APN:           settings.APN,
+	}
+	if err := storage.SetProvisionedSettings(identity, provisioned); err != nil {
+		log.Print("Unable to store provisioned MMS settings: ", err)
+		return
+	}
+	log.Printf("Provisioned MMS settings for %s: message center %s, proxy %s port %d, APN %s",
+		identity, provisioned.MessageCenter, provisioned.Proxy, provisioned.ProxyPort, provisioned.APN)
+}
+
+//handleServiceIndication forwards Service Indication and Service Loading
+//pushes to the telepathy service; only the WBXML encodings are supported.
+//It runs in the mediator loop as telepathyService is only safe to use there.
+func (mediator *Mediator) handleServiceIndication(pushMsg *ofono.PushPDU) {
+	if mediator.telepathyService == nil {
+		log.Print("Dropping ", pushMsg.ContentType, " push received without a telepathy service")
+		return
+	}
+	switch pushMsg.ContentType {
+	case ofono.CONTENT_TYPE_SIC:
+		si, err := wappush.DecodeSI(pushMsg.Data)
+		if err != nil {
+			log.Print("Unable to decode service indication: ", err)
+			return
+		}
+		if err := mediator.telepathyService.ServiceIndicationReceived(si); err != nil {
+			log.Print("Cannot signal service indication: ", err)
+		}
+	case ofono.CONTENT_TYPE_SLC:
+		sl, err := wappush.DecodeSL(pushMsg.Data)
+		if err != nil {
+			log.Print("Unable to decode service loading: ", err)
+			return
+		}
+		if err := mediator.telepathyService.ServiceLoadingReceived(sl); err != nil {
+			log.Print("Cannot signal service loading: ", err)
+		}
+	default:
+		log.Print("Unsupported service indication content type ", pushMsg.ContentType)
+	}
+}
+
+func (mediator *Mediator) handleMNotificationInd(pushMsg *ofono.PushPDU) {
 	dec := mms.NewDecoder(pushMsg.Data)
 	mNotificationInd := mms.NewMNotificationInd()
 	if err := dec.Decode(mNotificationInd); err != nil {
 		log.Println("Unable to decode m-notification.ind: ", err, "with log", dec.GetLog())
 		return
 	}
-	storage.Create(mNotificationInd.UUID, mNotificationInd.ContentLocation)
+	storage.Create(mediator.modem.Identity(), mNotificationInd.UUID, mNotificationInd.ContentLocation, mNotificationInd.Expires(time.Now()))
+	mediator.addPending(mNotificationInd)
 	mediator.NewMNotificationInd <- mNotificationInd
 }
 
+func (mediator *Mediator) addPending(mNotificationInd *mms.MNotificationInd) {
+	mediator.pendingLock.Lock()
+	defer mediator.pendingLock.Unlock()
+	mediator.pending[mNotificationInd.ContentLocation] = mNotificationInd
+}
+
+//removePending stops tracking mNotificationInd and returns false if it was
+//already removed by a cancelation.
+func (mediator *Mediator) removePending(mNotificationInd *mms.MNotificationInd) bool {
+	mediator.pendingLock.Lock()
+	defer mediator.pendingLock.Unlock()
+	if p, ok := mediator.pending[mNotificationInd.ContentLocation]; !ok || p != mNotificationInd {
+		return false
+	}
+	delete(mediator.pending, mNotificationInd.ContentLocation)
+	return true
+}
+
+//cancelPending aborts any download in progress or queued for contentLocation
+//and returns the canceled notification.
+func (mediator *Mediator) cancelPending(contentLocation string) (*mms.MNotificationInd, bool) {
+	mediator.pendingLock.Lock()
+	defer mediator.pendingLock.Unlock()
+	mNotificationInd, ok := mediator.pending[contentLocation]
+	if !ok {
+		return nil, false
+	}
+	delete(mediator.pending, contentLocation)
+	mNotificationInd.Cancel()
+	return mNotificationInd, true
+}
+
+func (mediator *Mediator) handleMCancelReq(pushMsg *ofono.PushPDU) {
+	dec := mms.NewDecoder(pushMsg.Data)
+	mCancelReq := mms.NewMCancelReq()
+	if err := dec.Decode(mCancelReq); err != nil {
+		log.Println("Unable to decode m-cancel.req: ", err, "with log", dec.GetLog())
+		return
+	}
+
+	if mNotificationInd, ok := mediator.cancelPending(mCancelReq.CancelId); ok {
+		log.Print("Canceled retrieval of ", mCancelReq.CancelId)
+		mediator.retries.cancel(mNotificationInd.UUID)
+		if mediator.telepathyService != nil {
+			if err := mediator.telepathyService.MessageCanceled(mNotificationInd.UUID); err != nil {
+				log.Println("Cannot notify telepathy-ofono about canceled message:", err)
+			}
+		} else if err := storage.Destroy(mNotificationInd.UUID); err != nil {
+			log.Println("Cannot remove canceled message from storage:", err)
+		}
+	} else {
+		log.Print("No pending retrieval to cancel for ", mCancelReq.CancelId)
+	}
+
+	if mCancelReq.IsLocal() {
+		log.Print("This is a local test, skipping m-cancel.conf")
+		return
+	}
+	filePath := mediator.handleMCancelConf(mCancelReq.NewMCancelConf(mms.CancelStatusReceived))
+	if filePath == "" {
+		return
+	}
+	defer os.Remove(filePath)
+	responseFile, err := mediator.uploadFile(filePath, nil, nil)
+	if err != nil {
+		log.Printf("Cannot upload m-cancel.conf encoded file %s to message center: %s", filePath, err)
+		return
+	}
+	os.Remove(responseFile)
+}
+
+func (mediator *Mediator) handleMCancelConf(mCancelConf *mms.MCancelConf) string {
+	f, err := storage.CreateCancelConfFile(mCancelConf.UUID)
+	if err != nil {
+		log.Print("Unable to create m-cancel.conf file for ", mCancelConf.UUID)
+		return ""
+	}
+	enc := mms.NewEncoder(f)
+	if err := enc.Encode(mCancelConf); err != nil {
+		log.Print("Unable to encode m-cancel.conf for ", mCancelConf.UUID)
+		f.Close()
+		return ""
+	}
+	filePath := f.Name()
+	if err := f.Sync(); err != nil {
+		log.Print("Error while syncing", f.Name(), ": ", err)
+		return ""
+	}
+	if err := f.Close(); err != nil {
+		log.Print("Error while closing", f.Name(), ": ", err)
+		return ""
+	}
+	log.Printf("Created %s to handle m-cancel.conf for %s", filePath, mCancelConf.UUID)
+	return filePath
+}
+
 func (mediator *Mediator) handleDeferredDownload(mNotificationInd *mms.MNotificationInd) {
 	//TODO send MessageAdded with status="deferred" and mNotificationInd relevant headers
 }
 
+//settingsError is returned for transfers the settings of the modem identity
+//do not allow, retrying them does not help until the settings are fixed.
+type settingsError struct {
+	err error
+}
+
+func (e *settingsError) Error() string {
+	return fmt.Sprintf("invalid settings: %s", e.err)
+}
+
+//isPermanent returns true if retrying a transfer that failed with err does
+//not help.
+func isPermanent(err error) bool {
+	switch err.(type) {
+	case *mms.CertificateError, *settingsError:
+		return true
+	}
+	return false
+}
+
+//transferOptions returns the options to transfer through proxy over the
+//network interface of mmsContext with, using the headers and timeouts
+//configured for the modem identity or the defaults, with timeouts scaled to
+//the access technology of the modem. A *settingsError is returned if the
+//settings are invalid.
+func (mediator *Mediator) transferOptions(mmsContext ofono.OfonoContext, proxy ofono.ProxyInfo, upload bool) (mms.TransferOptions, error) {
+	timeouts := mms.DefaultDownloadTimeouts
+	if upload {
+		timeouts = mms.DefaultUploadTimeouts
+	}
+	if technology, err := mediator.modem.Technology(); err == nil {
+		timeouts = timeouts.Scale(technology)
+	} else {
+		log.Print("Using default transfer timeouts: ", err)
+	}
+	options := mms.TransferOptions{
+		Proxy:       mms.Proxy(proxy),
+		Interface:   mmsContext.GetInterface(),
+		Nameservers: mmsContext.GetNameservers(),
+	}
+	if settings, err := storage.GetSettings(mediator.modem.Identity()); err == nil {
+		options.UserAgent = settings.UserAgent
+		options.UAProf = settings.UAProf
+		options.Headers = settings.Headers
+		if settings.ProxyUsername != "" {
+			options.Proxy.Username = settings.ProxyUsername
+			options.Proxy.Password = settings.ProxyPassword
+		}
+		options.TLS.CAFile = settings.TLSCAFile
+		options.TLS.Pins = settings.TLSPins
+		if settings.TLSMinVersion != "" {
+			v, err := mms.ParseTLSVersion(settings.TLSMinVersion)
+			if err != nil {
+				return options, &settingsError{err}
+			}
+			options.TLS.MinVersion = v
+		}
+		deadline := settings.DownloadTimeout
+		if upload {
+			deadline = settings.UploadTimeout
+		}
+		if deadline != 0 {
+			timeouts.Deadline = time.Duration(deadline) * time.Second
+		}
+		if settings.InactivityTimeout != 0 {
+			timeouts.Inactivity = time.Duration(settings.InactivityTimeout) * time.Second
+		}
+	}
+	options.Timeouts = timeouts
+	return options, nil
+}
+
+//progressRecorder returns a mms.Progress that records the transfer progress
+//of uuid in the store, at most once every progressInterval. The progress of
+//messages being sent is signaled on their message object as well.
+func (mediator *Mediator) progressRecorder(uuid string, sending bool) mms.Progress {
+	var last time.Time
+	return func(transferred, size int64) {
+		if transferred != size && time.Since(last) < progressInterval {
+			return
+		}
+		last = time.Now()
+		if err := storage.UpdateProgress(uuid, transferred, size); err != nil {
+			log.Print("Cannot record transfer progress: ", err)
+		}
+		if sending && mediator.telepathyService != nil {
+			if err := mediator.telepathyService.MessageProgressChanged(uuid, transferred, size); err != nil {
+				log.Print("Cannot signal transfer progress: ", err)
+			}
+		}
+	}
+}
+
 func (mediator *Mediator) getMRetrieveConf(mNotificationInd *mms.MNotificationInd) {
 	mediator.contextLock.Lock()
 	defer mediator.contextLock.Unlock()
 
+	if mNotificationInd.IsCanceled() {
+		log.Print("Retrieval of ", mNotificationInd.ContentLocation, " was canceled")
+		return
+	}
+
 	var proxy ofono.ProxyInfo
 	var mmsContext ofono.OfonoContext
 
@@ -163,6 +497,7 @@ func (mediator *Mediator) getMRetrieveConf(mNotificationInd *mms.MNotificationIn
 		mmsContext, err = mediator.modem.ActivateMMSContext(preferredContext)
 		if err != nil {
 			log.Print("Cannot activate ofono context: ", err)
+			mediator.retryMRetrieveConf(mNotificationInd, err)
 			return
 		}
 		defer func() {
@@ -177,19 +512,43 @@ func (mediator *Mediator) getMRetrieveConf(mNotificationInd *mms.MNotificationIn
 		proxy, err = mmsContext.GetProxy()
 		if err != nil {
 			log.Print("Error retrieving proxy: ", err)
+			mediator.retryMRetrieveConf(mNotificationInd, err)
 			return
 		}
 	}
 
-	if filePath, err := mNotificationInd.DownloadContent(proxy.Host, int32(proxy.Port)); err != nil {
-		//TODO telepathy service signal the download error
+	options, err := mediator.transferOptions(mmsContext, proxy, false)
+	if err != nil {
+		log.Print("Cannot download ", mNotificationInd.ContentLocation, ": ", err)
+		mediator.retryMRetrieveConf(mNotificationInd, err)
+		return
+	}
+	filePath, err := storage.DownloadFile(mNotificationInd.UUID)
+	if err != nil {
+		log.Print("Cannot create the download file: ", err)
+		return
+	}
+	progress := mediator.progressRecorder(mNotificationInd.UUID, false)
+	if err := mNotificationInd.DownloadContent(mediator.transport, filePath, options, progress); err == mms.ErrCanceled {
+		log.Print("Retrieval of ", mNotificationInd.ContentLocation, " was canceled")
+		os.Remove(filePath)
+		return
+	} else if err != nil {
+		//the partial download is kept to be resumed on retry
 		log.Print("Download issues: ", err)
+		mediator.retryMRetrieveConf(mNotificationInd, err)
 		return
 	} else {
+		if !mediator.removePending(mNotificationInd) {
+			log.Print("Retrieval of ", mNotificationInd.ContentLocation, " was canceled")
+			os.Remove(filePath)
+			return
+		}
 		if err := storage.UpdateDownloaded(mNotificationInd.UUID, filePath); err != nil {
 			log.Println("When calling UpdateDownloaded: ", err)
 			return
 		}
+		mediator.retries.finished(mNotificationInd.UUID, nil)
 	}
 
 	mRetrieveConf, err := mediator.handleMRetrieveConf(mNotificationInd.UUID)
@@ -210,29 +569,46 @@ func (mediator *Mediator) getMRetrieveConf(mNotificationInd *mms.MNotificationIn
 		if filePath == "" {
 			return
 		}
-		mediator.sendMNotifyRespInd(filePath, &mmsContext)
+		mediator.sendMNotifyRespInd(mNotifyRespInd.UUID, filePath, &mmsContext)
 	} else {
 		log.Print("This is a local test, skipping m-notifyresp.ind")
+		if err := storage.UpdateReceived(mNotifyRespInd.UUID); err != nil {
+			log.Print("Can't update mms status: ", err)
+		}
 	}
 }
 
-func (mediator *Mediator) handleMRetrieveConf(uuid string) (*mms.MRetrieveConf, error) {
-	var filePath string
-	if f, err := storage.GetMMS(uuid); err == nil {
-		filePath = f
-	} else {
-		return nil, fmt.Errorf("unable to retrieve MMS: %s", err)
+//retryMRetrieveConf schedules another retrieval of mNotificationInd after
+//it failed with err, the notification is dropped and reported as failed if
+//it is not retried.
+func (mediator *Mediator) retryMRetrieveConf(mNotificationInd *mms.MNotificationInd, err error) {
+	retry := func() { mediator.getMRetrieveConf(mNotificationInd) }
+	status := telepathy.TRANSIENT_ERROR
+	if isPermanent(err) {
+		log.Print("Not retrying download of ", mNotificationInd.UUID, ": ", err)
+		mediator.retries.finished(mNotificationInd.UUID, err)
+		status = telepathy.PERMANENT_ERROR
+	} else if mediator.retries.failed(mNotificationInd.UUID, err, retry) {
+		return
 	}
-
-	mmsData, err := ioutil.ReadFile(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("issues while reading from downloaded file: %s", err)
+	if !mediator.removePending(mNotificationInd) {
+		//canceled meanwhile, the cancelation removed it
+		return
 	}
+	if err := storage.Destroy(mNotificationInd.UUID); err != nil {
+		log.Print("Cannot remove failed notification ", mNotificationInd.UUID, ": ", err)
+	}
+	if mediator.telepathyService != nil {
+		if err := mediator.telepathyService.IncomingMessageFailed(mNotificationInd, status, err); err != nil {
+			log.Println("Cannot notify telepathy-ofono about failed message:", err)
+		}
+	}
+}
 
-	mRetrieveConf := mms.NewMRetrieveConf(uuid)
-	dec := mms.NewDecoder(mmsData)
-	if err := dec.Decode(mRetrieveConf); err != nil {
-		return nil, fmt.Errorf("unable to decode m-retrieve.conf: %s with log %s", err, dec.GetLog())
+func (mediator *Mediator) handleMRetrieveConf(uuid string) (*mms.MRetrieveConf, error) {
+	mRetrieveConf, err := storage.LoadMRetrieveConf(uuid)
+	if err != nil {
+		return nil, err
 	}
 
 	if mediator.telepathyService != nil {
@@ -271,7 +647,7 @@ func (mediator *Mediator) handleMNotifyRespInd(mNotifyRespInd *mms.MNotifyRespIn
 	return filePath
 }
 
-func (mediator *Mediator) sendMNotifyRespInd(filePath string, mmsContext *ofono.OfonoContext) {
+func (mediator *Mediator) sendMNotifyRespInd(uuid, filePath string, mmsContext *ofono.OfonoContext) {
 	defer os.Remove(filePath)
 
 	proxy, err := mmsContext.GetProxy()
@@ -285,8 +661,18 @@ func (mediator *Mediator) sendMNotifyRespInd(filePath string, mmsContext *ofono.
 		return
 	}
 
-	if _, err := mms.Upload(filePath, msc, proxy.Host, int32(proxy.Port)); err != nil {
+	options, err := mediator.transferOptions(*mmsContext, proxy, true)
+	if err != nil {
+		log.Print("Cannot send m-notifyresp.ind: ", err)
+		return
+	}
+	if responseFile, err := mediator.transport.Upload(filePath, msc, options, nil, nil); err != nil {
 		log.Printf("Cannot upload m-notifyresp.ind encoded file %s to message center: %s", filePath, err)
+	} else {
+		os.Remove(responseFile)
+		if err := storage.UpdateReceived(uuid); err != nil {
+			log.Print("Can't update mms status: ", err)
+		}
 	}
 }
 
@@ -296,22 +682,43 @@ func (mediator *Mediator) handleOutgoingMessage(msg *telepathy.OutgoingMessage)
 		ct, err := mms.NewAttachment(att.Id, att.ContentType, att.FilePath)
 		if err != nil {
 			log.Print(err)
+			closeAttachments(cts)
 			//TODO reply to telepathy ofono with an error
 			return
 		}
 		cts = append(cts, ct)
 	}
 	mSendReq := mms.NewMSendReq(msg.Recipients, cts, useDeliveryReports)
+	if err := mediator.validateMSendReq(mSendReq); err != nil {
+		log.Print("Rejecting m-send.req for ", mSendReq.UUID, ": ", err)
+		if err := mediator.telepathyService.RejectSendMessage(msg, err); err != nil {
+			log.Print(err)
+		}
+		closeAttachments(cts)
+		return
+	}
 	if _, err := mediator.telepathyService.ReplySendMessage(msg.Reply, mSendReq.UUID); err != nil {
 		log.Print(err)
+		closeAttachments(cts)
 		return
 	}
 	mediator.NewMSendReq <- mSendReq
 }
 
+//closeAttachments releases the files backing the attachments once they
+//are no longer needed.
+func closeAttachments(attachments []*mms.Attachment) {
+	for i := range attachments {
+		if err := attachments[i].Close(); err != nil {
+			log.Print("Cannot close attachment ", attachments[i].ContentId, ": ", err)
+		}
+	}
+}
+
 func (mediator *Mediator) handleMSendReq(mSendReq *mms.MSendReq) {
 	log.Print("Encoding M-Send.Req")
-	f, err := storage.CreateSendFile(mSendReq.UUID)
+	defer closeAttachments(mSendReq.Attachments)
+	f, err := storage.CreateSendFile(mediator.modem.Identity(), mSendReq.UUID)
 	if err != nil {
 		log.Print("Unable to create m-send.req file for ", mSendReq.UUID)
 		return
@@ -339,15 +746,46 @@ func (mediator *Mediator) handleMSendReq(mSendReq *mms.MSendReq) {
 	mediator.sendMSendReq(filePath, mSendReq.UUID)
 }
 
-func (mediator *Mediator) sendMSendReq(mSendReqFile, uuid string) {
-	defer os.Remove(mSendReqFile)
-	defer mediator.telepathyService.MessageDestroy(uuid)
-	mSendConfFile, err := mediator.uploadFile(mSendReqFile)
+//validateMSendReq downscales the image attachments of mSendReq to fit the
+//maximum message size configured for the modem identity, if any, and checks
+//it against the configured content class and limits.
+func (mediator *Mediator) validateMSendReq(mSendReq *mms.MSendReq) error {
+	settings, err := storage.GetSettings(mediator.modem.Identity())
 	if err != nil {
-		if err := mediator.telepathyService.MessageStatusChanged(uuid, telepathy.TRANSIENT_ERROR); err != nil {
-			log.Println(err)
+		return nil
+	}
+	validator, err := mms.NewValidator(settings.ContentClass, settings.MaxRecipients, settings.MaxMessageSize)
+	if err != nil {
+		return fmt.Errorf("invalid settings for %s: %s", mediator.modem.Identity(), err)
+	}
+	if limit := validator.Limit(); limit > 0 {
+		size, err := mSendReq.Adapt(limit)
+		if err != nil && err != mms.ErrTooLarge {
+			return err
 		}
+		log.Printf("m-send.req for %s is %d bytes with a maximum of %d", mSendReq.UUID, size, limit)
+	}
+	return validator.Validate(mSendReq)
+}
+
+func (mediator *Mediator) sendMSendReq(mSendReqFile, uuid string) {
+	cancel := mediator.startSending(mSendReqFile, uuid)
+	select {
+	case <-cancel:
+		//canceled while the retry was about to start
+		mediator.finishCanceledMSendReq(mSendReqFile, uuid)
+		return
+	default:
+	}
+	mSendConfFile, err := mediator.uploadFile(mSendReqFile, cancel, mediator.progressRecorder(uuid, true))
+	if err == mms.ErrCanceled {
+		log.Print("Canceled sending ", uuid)
+		mediator.retries.finished(uuid, err)
+		mediator.finishCanceledMSendReq(mSendReqFile, uuid)
+		return
+	} else if err != nil {
 		log.Printf("Cannot upload m-send.req encoded file %s to message center: %s", mSendReqFile, err)
+		mediator.retryMSendReq(mSendReqFile, uuid, err)
 		return
 	}
 
@@ -355,25 +793,112 @@ func (mediator *Mediator) sendMSendReq(mSendReqFile, uuid string) {
 	mSendConf, err := parseMSendConfFile(mSendConfFile)
 	if err != nil {
 		log.Println("Error while decoding m-send.conf:", err)
-		if err := mediator.telepathyService.MessageStatusChanged(uuid, telepathy.TRANSIENT_ERROR); err != nil {
-			log.Println(err)
-		}
+		mediator.retryMSendReq(mSendReqFile, uuid, err)
 		return
 	}
 
 	log.Println("m-send.conf ResponseStatus for", uuid, "is", mSendConf.ResponseStatus)
 	var status string
-	switch mSendConf.Status() {
+	statusErr := mSendConf.Status()
+	switch statusErr {
 	case nil:
 		status = telepathy.SENT
 	case mms.ErrPermanent:
 		status = telepathy.PERMANENT_ERROR
 	case mms.ErrTransient:
-		status = telepathy.TRANSIENT_ERROR
+		mediator.retryMSendReq(mSendReqFile, uuid, fmt.Errorf("m-send.conf response status %#x", mSendConf.ResponseStatus))
+		return
 	}
+	mediator.retries.finished(uuid, statusErr)
+	mediator.finishMSendReq(mSendReqFile, uuid, status)
+}
+
+//retryMSendReq schedules sending mSendReqFile again after it failed with
+//err, the message fails with a transient error once it is not retried.
+func (mediator *Mediator) retryMSendReq(mSendReqFile, uuid string, err error) {
+	retry := func() {
+		mediator.NewMSendReqFile <- struct{ filePath, uuid string }{mSendReqFile, uuid}
+	}
+	if isPermanent(err) {
+		//retrying does not help against an untrusted MMSC or invalid
+		//settings
+		mediator.retries.finished(uuid, err)
+		mediator.stopSending(uuid)
+		os.Remove(mSendReqFile)
+		if err := mediator.telepathyService.MessageFailed(uuid, err); err != nil {
+			log.Println(err)
+		}
+		mediator.telepathyService.MessageDestroy(uuid)
+		return
+	}
+	if mediator.retries.failed(uuid, err, retry) {
+		return
+	}
+	mediator.finishMSendReq(mSendReqFile, uuid, telepathy.TRANSIENT_ERROR)
+}
+
+//finishMSendReq reports the final status of the message being sent and
+//releases it.
+func (mediator *Mediator) finishMSendReq(mSendReqFile, uuid, status string) {
+	mediator.stopSending(uuid)
+	os.Remove(mSendReqFile)
 	if err := mediator.telepathyService.MessageStatusChanged(uuid, status); err != nil {
 		log.Println(err)
 	}
+	mediator.telepathyService.MessageDestroy(uuid)
+}
+
+//finishCanceledMSendReq records that sending the message for uuid was
+//canceled and releases it.
+func (mediator *Mediator) finishCanceledMSendReq(mSendReqFile, uuid string) {
+	if err := storage.UpdateCanceled(uuid); err != nil {
+		log.Print("Cannot mark ", uuid, " as canceled: ", err)
+	}
+	mediator.finishMSendReq(mSendReqFile, uuid, telepathy.CANCELED)
+}
+
+//startSending registers the message for uuid as being sent and returns the
+//channel closed when it is canceled, retries share the same channel.
+func (mediator *Mediator) startSending(mSendReqFile, uuid string) chan struct{} {
+	mediator.sendingLock.Lock()
+	defer mediator.sendingLock.Unlock()
+	if transfer, ok := mediator.sending[uuid]; ok {
+		return transfer.cancel
+	}
+	transfer := &outgoingTransfer{file: mSendReqFile, cancel: make(chan struct{})}
+	mediator.sending[uuid] = transfer
+	return transfer.cancel
+}
+
+func (mediator *Mediator) stopSending(uuid string) {
+	mediator.sendingLock.Lock()
+	defer mediator.sendingLock.Unlock()
+	delete(mediator.sending, uuid)
+}
+
+//cancelMSendReq aborts sending the message for uuid. The upload in flight
+//fails with mms.ErrCanceled and is finished by sendMSendReq, a message
+//waiting to be retried is finished right away.
+func (mediator *Mediator) cancelMSendReq(uuid string) {
+	mediator.sendingLock.Lock()
+	transfer, ok := mediator.sending[uuid]
+	if ok {
+		select {
+		case <-transfer.cancel:
+			ok = false
+		default:
+			close(transfer.cancel)
+		}
+	}
+	mediator.sendingLock.Unlock()
+	if !ok {
+		log.Print("No message being sent to cancel for ", uuid)
+		return
+	}
+	log.Print("Canceling send of ", uuid)
+	if mediator.retries.cancel(uuid) {
+		mediator.finishCanceledMSendReq(transfer.file, uuid)
+	}
 }
 
 func parseMSendConfFile(mSendConfFile string) (*mms.MSendConf, error) {
@@ -391,7 +916,7 @@ func parseMSendConfFile(mSendConfFile string) (*mms.MSendConf, error) {
 	return mSendConf, nil
 }
 
-func (mediator *Mediator) uploadFile(filePath string) (string, error) {
+func (mediator *Mediator) uploadFile(filePath string, cancel <-chan struct{}, progress mms.Progress) (string, error) {
 	mediator.contextLock.Lock()
 	defer mediator.contextLock.Unlock()
 
@@ -417,7 +942,11 @@ func (mediator *Mediator) uploadFile(filePath string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	mSendRespFile, uploadErr := mms.Upload(filePath, msc, proxy.Host, int32(proxy.Port))
+	options, err := mediator.transferOptions(mmsContext, proxy, true)
+	if err != nil {
+		return "", err
+	}
+	mSendRespFile, uploadErr := mediator.transport.Upload(filePath, msc, options, cancel, progress)
 
 	return mSendRespFile, uploadErr
 }
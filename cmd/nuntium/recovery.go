@@ -0,0 +1,148 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of nuntium.
+ *
+ * nuntium is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * nuntium is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/ubuntu-phonedations/nuntium/mms"
+	"github.com/ubuntu-phonedations/nuntium/storage"
+)
+
+//recoverMessages picks up the messages of identity that were left in flight
+//in the store when the daemon stopped: notifications are retrieved,
+//downloaded and retrieved messages are announced again and acknowledged
+//and drafts are sent. Received messages are left to GetMessages.
+func (mediator *Mediator) recoverMessages(identity string) {
+	states, err := storage.List(identity)
+	if err != nil {
+		log.Print("Cannot recover stored messages: ", err)
+		return
+	}
+	uuids := make([]string, 0, len(states))
+	for uuid := range states {
+		uuids = append(uuids, uuid)
+	}
+	sort.Strings(uuids)
+	for _, uuid := range uuids {
+		state := states[uuid]
+		switch state.State {
+		case storage.NOTIFICATION:
+			mediator.recoverNotification(uuid, state)
+		case storage.DOWNLOADED, storage.RETRIEVED:
+			mediator.recoverMRetrieveConf(uuid, state)
+		case storage.DRAFT:
+			mediator.recoverDraft(uuid, state)
+		}
+	}
+}
+
+//recoverNotification resumes the retrieval of a notification, at the time
+//of the retry scheduled for it if it is still ahead.
+func (mediator *Mediator) recoverNotification(uuid string, state storage.MMSState) {
+	if state.Expires != nil && time.Now().After(*state.Expires) {
+		log.Print("Dropping expired notification ", uuid)
+		if err := storage.Destroy(uuid); err != nil {
+			log.Print("Cannot remove expired notification: ", err)
+		}
+		return
+	}
+	log.Print("Resuming retrieval of ", uuid)
+	mNotificationInd := mms.RestoreMNotificationInd(uuid, state.ContentLocation)
+	mediator.addPending(mNotificationInd)
+	if state.NextAttempt != nil && state.NextAttempt.After(time.Now()) {
+		mediator.retries.schedule(uuid, *state.NextAttempt, func() {
+			mediator.getMRetrieveConf(mNotificationInd)
+		})
+		return
+	}
+	mediator.NewMNotificationInd <- mNotificationInd
+}
+
+//recoverMRetrieveConf announces a message that was not acknowledged to the
+//MMSC again and acknowledges it.
+func (mediator *Mediator) recoverMRetrieveConf(uuid string, state storage.MMSState) {
+	log.Print("Announcing ", state.State, " message ", uuid)
+	if state.State == storage.DOWNLOADED {
+		if err := storage.RecoverDownload(uuid); err != nil {
+			log.Print("Cannot recover the download of ", uuid, ": ", err)
+			return
+		}
+	}
+	mRetrieveConf, err := mediator.handleMRetrieveConf(uuid)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	if state.State == storage.DOWNLOADED {
+		if err := storage.UpdateRetrieved(uuid); err != nil {
+			log.Print("Can't update mms status: ", err)
+			return
+		}
+	}
+	if mms.RestoreMNotificationInd(uuid, state.ContentLocation).IsLocal() {
+		log.Print("This is a local test, skipping m-notifyresp.ind")
+		if err := storage.UpdateReceived(uuid); err != nil {
+			log.Print("Can't update mms status: ", err)
+		}
+		return
+	}
+	filePath := mediator.handleMNotifyRespInd(mRetrieveConf.NewMNotifyRespInd(useDeliveryReports))
+	if filePath == "" {
+		return
+	}
+	defer os.Remove(filePath)
+	responseFile, err := mediator.uploadFile(filePath, nil, nil)
+	if err != nil {
+		log.Printf("Cannot upload m-notifyresp.ind encoded file %s to message center: %s", filePath, err)
+		return
+	}
+	os.Remove(responseFile)
+	if err := storage.UpdateReceived(uuid); err != nil {
+		log.Print("Can't update mms status: ", err)
+	}
+}
+
+//recoverDraft announces a message that was being sent again and queues it,
+//drafts without a m-send.req were done being sent.
+func (mediator *Mediator) recoverDraft(uuid string, state storage.MMSState) {
+	mSendReqFile, err := storage.GetSendFile(uuid)
+	if err != nil {
+		return
+	}
+	log.Print("Resuming send of ", uuid)
+	if err := mediator.telepathyService.OutgoingMessageAdded(uuid); err != nil {
+		log.Print("Cannot announce outgoing message ", uuid, ": ", err)
+	}
+	retry := func() {
+		mediator.NewMSendReqFile <- struct{ filePath, uuid string }{mSendReqFile, uuid}
+	}
+	if state.NextAttempt != nil && state.NextAttempt.After(time.Now()) {
+		mediator.startSending(mSendReqFile, uuid)
+		mediator.retries.schedule(uuid, *state.NextAttempt, retry)
+		return
+	}
+	retry()
+}
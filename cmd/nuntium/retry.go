@@ -0,0 +1,112 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of nuntium.
+ *
+ * nuntium is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * nuntium is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ubuntu-phonedations/nuntium/mms"
+	"github.com/ubuntu-phonedations/nuntium/storage"
+)
+
+//retryScheduler retries failed transfers following a mms.RetryPolicy, the
+//outcome of every attempt and the time of the next one are kept in storage.
+type retryScheduler struct {
+	policy mms.RetryPolicy
+	lock   sync.Mutex
+	timers map[string]*time.Timer
+}
+
+func newRetryScheduler(policy mms.RetryPolicy) *retryScheduler {
+	return &retryScheduler{
+		policy: policy,
+		timers: make(map[string]*time.Timer),
+	}
+}
+
+//finished records the last transfer attempt for uuid, outcome is nil if it
+//succeeded.
+func (s *retryScheduler) finished(uuid string, outcome error) {
+	if _, err := storage.RecordAttempt(uuid, outcome); err != nil {
+		log.Print("Cannot record transfer attempt for ", uuid, ": ", err)
+	}
+}
+
+//failed records the failed transfer for uuid and schedules retry to run
+//when it should be attempted again. It returns false if the transfer is not
+//retried anymore.
+func (s *retryScheduler) failed(uuid string, outcome error, retry func()) bool {
+	state, err := storage.RecordAttempt(uuid, outcome)
+	if err != nil {
+		log.Print("Cannot record transfer attempt for ", uuid, ": ", err)
+		return false
+	}
+	var expires time.Time
+	if state.Expires != nil {
+		expires = *state.Expires
+	}
+	next, ok := s.policy.Next(len(state.Attempts), time.Now(), expires)
+	if !ok {
+		log.Printf("Giving up on %s after %d attempts", uuid, len(state.Attempts))
+		return false
+	}
+	if err := storage.ScheduleRetry(uuid, next); err != nil {
+		log.Print("Cannot record next transfer attempt for ", uuid, ": ", err)
+	}
+	log.Printf("Retrying %s at %s after %d failed attempts", uuid, next.Format(time.RFC3339), len(state.Attempts))
+	s.schedule(uuid, next, retry)
+	return true
+}
+
+//schedule runs retry for uuid at next, replacing any retry scheduled for it.
+func (s *retryScheduler) schedule(uuid string, next time.Time, retry func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if timer, ok := s.timers[uuid]; ok {
+		timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(next.Sub(time.Now()), func() {
+		s.lock.Lock()
+		if s.timers[uuid] == timer {
+			delete(s.timers, uuid)
+		}
+		s.lock.Unlock()
+		retry()
+	})
+	s.timers[uuid] = timer
+}
+
+//cancel drops the retry scheduled for uuid, if any. It returns true if the
+//retry was dropped before it started.
+func (s *retryScheduler) cancel(uuid string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	timer, ok := s.timers[uuid]
+	if !ok {
+		return false
+	}
+	delete(s.timers, uuid)
+	return timer.Stop()
+}
@@ -0,0 +1,295 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of mms.
+ *
+ * mms is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * mms is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mms
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"log"
+	"sort"
+)
+
+var ErrTooLarge = errors.New("message does not fit the maximum message size")
+
+const (
+	//adaptScaleStep is the factor applied to the image sides on each pass
+	adaptScaleStep = 0.75
+	//adaptMinSide is the smallest side an image is downscaled to
+	adaptMinSide = 64
+	//adaptMaxQuality and adaptMinQuality bound the JPEG quality used
+	adaptMaxQuality = 85
+	adaptMinQuality = 40
+)
+
+//countingWriter discards what is written to it keeping only its length.
+type countingWriter struct {
+	n uint64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += uint64(len(p))
+	return len(p), nil
+}
+
+//EncodedSize returns the size of the encoded m-send.req.
+func (pdu *MSendReq) EncodedSize() (uint64, error) {
+	var w countingWriter
+	if err := NewEncoder(&w).Encode(pdu); err != nil {
+		return 0, err
+	}
+	return w.n, nil
+}
+
+//adaptedImage holds the decoded form of an image attachment so it can be
+//re-encoded at smaller resolutions and qualities.
+type adaptedImage struct {
+	attachment *Attachment
+	mediaType  string
+	img        *image.RGBA
+	anim       *gif.GIF
+	frames     []*image.RGBA
+	width      int
+	height     int
+	scale      float64
+	quality    int
+}
+
+//Adapt re-encodes the JPEG, PNG and GIF attachments to smaller resolutions
+//and JPEG qualities until the encoded m-send.req is no larger than
+//maxSize. It returns the final encoded size, with ErrTooLarge if the
+//message cannot be made to fit. Attachments are left untouched when the
+//message already fits.
+//
+//Images are adapted one at a time, largest first, so only one of them is
+//held decoded in memory.
+func (pdu *MSendReq) Adapt(maxSize uint64) (uint64, error) {
+	size, err := pdu.EncodedSize()
+	if err != nil || size <= maxSize {
+		return size, err
+	}
+
+	var attachments []*Attachment
+	for _, a := range pdu.Attachments {
+		if isAdaptable(a) {
+			attachments = append(attachments, a)
+		}
+	}
+	sort.SliceStable(attachments, func(i, j int) bool {
+		return attachments[i].dataSize() > attachments[j].dataSize()
+	})
+
+	for _, a := range attachments {
+		img, err := decodeImage(a)
+		if err != nil {
+			log.Printf("Cannot adapt attachment %s: %s", a.ContentId, err)
+			continue
+		}
+		for img.next() {
+			if err := img.encode(); err != nil {
+				return size, err
+			}
+			if size, err = pdu.EncodedSize(); err != nil {
+				return size, err
+			}
+			log.Printf("Adapted %s to scale %.2f and quality %d, message size is %d", a.ContentId, img.scale, img.quality, size)
+			if size <= maxSize {
+				return size, nil
+			}
+		}
+	}
+	return size, ErrTooLarge
+}
+
+//isAdaptable tells if the attachment is an image Adapt can re-encode.
+func isAdaptable(a *Attachment) bool {
+	mediaType, _ := splitCharset(a.MediaType)
+	switch mediaType {
+	case "image/jpeg", "image/jpg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+//decodeImage decodes an adaptable image attachment, converting it to RGBA
+//once for all the passes.
+func decodeImage(a *Attachment) (*adaptedImage, error) {
+	mediaType, _ := splitCharset(a.MediaType)
+	data, err := ioutil.ReadAll(a.dataReader())
+	if err != nil {
+		return nil, err
+	}
+	img := &adaptedImage{attachment: a, mediaType: mediaType, scale: 1.0, quality: adaptMaxQuality}
+	if mediaType == "image/gif" {
+		if img.anim, err = gif.DecodeAll(bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+		img.width, img.height = img.anim.Config.Width, img.anim.Config.Height
+		if img.width == 0 || img.height == 0 {
+			bounds := img.anim.Image[0].Bounds()
+			img.width, img.height = bounds.Max.X, bounds.Max.Y
+		}
+		img.frames = make([]*image.RGBA, len(img.anim.Image))
+		for i, frame := range img.anim.Image {
+			img.frames[i] = toRGBA(frame)
+		}
+		return img, nil
+	}
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	img.img = toRGBA(decoded)
+	img.width, img.height = img.img.Bounds().Dx(), img.img.Bounds().Dy()
+	return img, nil
+}
+
+//isJPEG tells if the image is written as a JPEG, the only format where
+//the quality can be lowered.
+func (img *adaptedImage) isJPEG() bool {
+	return img.anim == nil && img.mediaType != "image/png"
+}
+
+//next moves to the scale and quality of the following pass, JPEG images
+//first lower their quality while the other formats are scaled right away.
+//It returns false once the image cannot be made any smaller.
+func (img *adaptedImage) next() bool {
+	if img.isJPEG() && img.quality > adaptMinQuality {
+		img.quality -= 15
+		return true
+	}
+	img.scale *= adaptScaleStep
+	return int(float64(img.width)*img.scale) >= adaptMinSide && int(float64(img.height)*img.scale) >= adaptMinSide
+}
+
+//encode replaces the attachment data with the image at the current scale
+//and quality.
+func (img *adaptedImage) encode() error {
+	width, height := int(float64(img.width)*img.scale), int(float64(img.height)*img.scale)
+	var buf bytes.Buffer
+	var err error
+	switch {
+	case img.anim != nil:
+		err = gif.EncodeAll(&buf, img.scaleGIF())
+	case img.mediaType == "image/png":
+		err = png.Encode(&buf, resize(img.img, width, height))
+	default:
+		err = jpeg.Encode(&buf, resize(img.img, width, height), &jpeg.Options{Quality: img.quality})
+	}
+	if err != nil {
+		return fmt.Errorf("cannot encode adapted image %s: %s", img.attachment.ContentId, err)
+	}
+
+	if err := img.attachment.Close(); err != nil {
+		log.Print("Cannot close attachment ", img.attachment.ContentId, ": ", err)
+	}
+	img.attachment.reader, img.attachment.readerSize = nil, 0
+	img.attachment.Data = buf.Bytes()
+	return nil
+}
+
+//dataReader returns a reader for the attachment data wherever it is held.
+func (a *Attachment) dataReader() io.Reader {
+	if a.reader != nil {
+		return io.NewSectionReader(a.reader, 0, a.readerSize)
+	}
+	return bytes.NewReader(a.Data)
+}
+
+//dataSize returns the size of the attachment data wherever it is held.
+func (a *Attachment) dataSize() int64 {
+	if a.reader != nil {
+		return a.readerSize
+	}
+	return int64(len(a.Data))
+}
+
+//scaleGIF scales every frame of the animation keeping their palettes.
+func (img *adaptedImage) scaleGIF() *gif.GIF {
+	scale := img.scale
+	scaled := *img.anim
+	scaled.Image = make([]*image.Paletted, len(img.anim.Image))
+	for i, frame := range img.anim.Image {
+		b := frame.Bounds()
+		rect := image.Rect(int(float64(b.Min.X)*scale), int(float64(b.Min.Y)*scale),
+			int(float64(b.Max.X)*scale), int(float64(b.Max.Y)*scale))
+		if rect.Dx() == 0 || rect.Dy() == 0 {
+			rect.Max = rect.Min.Add(image.Pt(1, 1))
+		}
+		dst := image.NewPaletted(rect, frame.Palette)
+		draw.Draw(dst, rect, resize(img.frames[i], rect.Dx(), rect.Dy()), image.Point{}, draw.Src)
+		scaled.Image[i] = dst
+	}
+	scaled.Config.Width = int(float64(img.anim.Config.Width) * scale)
+	scaled.Config.Height = int(float64(img.anim.Config.Height) * scale)
+	return &scaled
+}
+
+//toRGBA converts src to an RGBA image with its origin at 0,0.
+func toRGBA(src image.Image) *image.RGBA {
+	b := src.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+	return rgba
+}
+
+//resize downscales rgba to width x height averaging the source pixels
+//covered by each destination pixel.
+func resize(rgba *image.RGBA, width, height int) *image.RGBA {
+	b := rgba.Bounds()
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := y*b.Dy()/height, (y+1)*b.Dy()/height
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0, x1 := x*b.Dx()/width, (x+1)*b.Dx()/width
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(row[sx*4+c])
+					}
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			i := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[i+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
@@ -0,0 +1,168 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of mms.
+ *
+ * mms is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * mms is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mms
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+
+	. "launchpad.net/gocheck"
+)
+
+type AdaptTestSuite struct{}
+
+var _ = Suite(&AdaptTestSuite{})
+
+//noise creates an image that does not compress well
+func noise(width, height int) *image.RGBA {
+	r := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		if i%4 == 3 {
+			img.Pix[i] = 0xff
+		} else {
+			img.Pix[i] = uint8(r.Intn(256))
+		}
+	}
+	return img
+}
+
+func imageAttachment(c *C, id, mediaType string, data []byte) *Attachment {
+	att, err := NewAttachmentReader(id, mediaType, bytes.NewReader(data), int64(len(data)))
+	c.Assert(err, IsNil)
+	return att
+}
+
+func (s *AdaptTestSuite) jpegAttachment(c *C, width, height int) *Attachment {
+	var buf bytes.Buffer
+	c.Assert(jpeg.Encode(&buf, noise(width, height), &jpeg.Options{Quality: 95}), IsNil)
+	return imageAttachment(c, "image0", "image/jpeg", buf.Bytes())
+}
+
+func (s *AdaptTestSuite) TestAdaptFits(c *C) {
+	att := s.jpegAttachment(c, 64, 64)
+	mSendReq := NewMSendReq([]string{"+12345"}, []*Attachment{att}, false)
+	before, err := mSendReq.EncodedSize()
+	c.Assert(err, IsNil)
+
+	size, err := mSendReq.Adapt(before)
+	c.Assert(err, IsNil)
+	c.Check(size, Equals, before)
+	c.Check(att.reader, NotNil)
+}
+
+func (s *AdaptTestSuite) TestAdaptJPEG(c *C) {
+	att := s.jpegAttachment(c, 640, 480)
+	mSendReq := NewMSendReq([]string{"+12345"}, []*Attachment{att}, false)
+	before, err := mSendReq.EncodedSize()
+	c.Assert(err, IsNil)
+
+	size, err := mSendReq.Adapt(before / 4)
+	c.Assert(err, IsNil)
+	c.Check(size <= before/4, Equals, true)
+	encoded, err := mSendReq.EncodedSize()
+	c.Assert(err, IsNil)
+	c.Check(size, Equals, encoded)
+
+	img, err := jpeg.Decode(bytes.NewReader(att.Data))
+	c.Assert(err, IsNil)
+	c.Check(img.Bounds().Dx() < 640, Equals, true)
+	c.Check(img.Bounds().Dx()*480, Equals, img.Bounds().Dy()*640)
+}
+
+func (s *AdaptTestSuite) TestAdaptPNG(c *C) {
+	var buf bytes.Buffer
+	c.Assert(png.Encode(&buf, noise(300, 200)), IsNil)
+	att := imageAttachment(c, "image0", "image/png", buf.Bytes())
+	mSendReq := NewMSendReq([]string{"+12345"}, []*Attachment{att}, false)
+
+	size, err := mSendReq.Adapt(uint64(buf.Len() / 2))
+	c.Assert(err, IsNil)
+	c.Check(size <= uint64(buf.Len()/2), Equals, true)
+
+	img, err := png.Decode(bytes.NewReader(att.Data))
+	c.Assert(err, IsNil)
+	c.Check(img.Bounds().Dx() < 300, Equals, true)
+}
+
+func (s *AdaptTestSuite) TestAdaptAnimatedGIF(c *C) {
+	anim := &gif.GIF{Config: image.Config{Width: 200, Height: 200, ColorModel: color.Palette(palette.Plan9)}}
+	for i := 0; i < 2; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 200, 200), palette.Plan9)
+		for j := range frame.Pix {
+			frame.Pix[j] = uint8(rand.Intn(len(palette.Plan9)))
+		}
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10)
+	}
+	var buf bytes.Buffer
+	c.Assert(gif.EncodeAll(&buf, anim), IsNil)
+	att := imageAttachment(c, "image0", "image/gif", buf.Bytes())
+	mSendReq := NewMSendReq([]string{"+12345"}, []*Attachment{att}, false)
+
+	_, err := mSendReq.Adapt(uint64(buf.Len() / 2))
+	c.Assert(err, IsNil)
+
+	adapted, err := gif.DecodeAll(bytes.NewReader(att.Data))
+	c.Assert(err, IsNil)
+	c.Check(adapted.Image, HasLen, 2)
+	c.Check(adapted.Config.Width < 200, Equals, true)
+}
+
+func (s *AdaptTestSuite) TestAdaptLargestFirst(c *C) {
+	small := s.jpegAttachment(c, 160, 120)
+	large := s.jpegAttachment(c, 640, 480)
+	mSendReq := NewMSendReq([]string{"+12345"}, []*Attachment{small, large}, false)
+	before, err := mSendReq.EncodedSize()
+	c.Assert(err, IsNil)
+
+	limit := before - uint64(large.readerSize)/2
+	size, err := mSendReq.Adapt(limit)
+	c.Assert(err, IsNil)
+	c.Check(size <= limit, Equals, true)
+	c.Check(small.reader, NotNil)
+	c.Check(large.reader, IsNil)
+}
+
+func (s *AdaptTestSuite) TestAdaptTooLarge(c *C) {
+	att := s.jpegAttachment(c, 320, 240)
+	mSendReq := NewMSendReq([]string{"+12345"}, []*Attachment{att}, false)
+
+	size, err := mSendReq.Adapt(100)
+	c.Check(err, Equals, ErrTooLarge)
+	c.Check(size > 100, Equals, true)
+}
+
+func (s *AdaptTestSuite) TestAdaptNoImages(c *C) {
+	att, err := NewAttachmentReader("text0", "text/plain", bytes.NewReader(make([]byte, 1000)), 1000)
+	c.Assert(err, IsNil)
+	mSendReq := NewMSendReq([]string{"+12345"}, []*Attachment{att}, false)
+
+	_, err = mSendReq.Adapt(500)
+	c.Check(err, Equals, ErrTooLarge)
+}
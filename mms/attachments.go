@@ -24,10 +24,14 @@ package mms
 import (
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"os"
 	"reflect"
 	"strings"
+
+	"github.com/ubuntu-phonedations/nuntium/wsp"
 )
 
 type Attachment struct {
@@ -53,19 +57,41 @@ type Attachment struct {
 	Secure           bool    `encode:"no"`
 	Q                float64 `encode:"no"`
 	Data             []byte  `encode:"no"`
+	reader           io.ReaderAt
+	readerSize       int64
 }
 
+//NewAttachment creates an attachment backed by the file at filePath, the
+//file is kept open and its data streamed when encoding until Close is
+//called.
 func NewAttachment(id, contentType, filePath string) (*Attachment, error) {
-	data, err := ioutil.ReadFile(filePath)
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create new ContentType for %s of content type %s on %s: %s", id, contentType, filePath, err)
+	}
+	fi, err := f.Stat()
 	if err != nil {
+		f.Close()
 		return nil, fmt.Errorf("cannot create new ContentType for %s of content type %s on %s: %s", id, contentType, filePath, err)
 	}
+	ct, err := NewAttachmentReader(id, contentType, f, fi.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return ct, nil
+}
 
+//NewAttachmentReader creates an attachment which data is read from r when
+//encoding instead of being held in memory. The SMIL part is the exception
+//as it is needed to find the message start.
+func NewAttachmentReader(id, contentType string, r io.ReaderAt, size int64) (*Attachment, error) {
 	ct := &Attachment{
 		ContentId:       id,
 		ContentLocation: id,
 		Name:            id,
-		Data:            data,
+		reader:          r,
+		readerSize:      size,
 	}
 
 	parts := strings.Split(contentType, ";")
@@ -81,8 +107,18 @@ func NewAttachment(id, contentType, filePath string) (*Attachment, error) {
 		}
 	}
 
+	if strings.HasPrefix(ct.MediaType, "application/smil") {
+		data, err := ioutil.ReadAll(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return nil, fmt.Errorf("cannot read SMIL for %s: %s", id, err)
+		}
+		if err := ct.Close(); err != nil {
+			log.Print("Cannot close SMIL for ", id, ": ", err)
+		}
+		ct.Data, ct.reader, ct.readerSize = data, nil, 0
+	}
 	if contentType == "application/smil" {
-		start, err := getSmilStart(data)
+		start, err := getSmilStart(ct.Data)
 		if err != nil {
 			return nil, err
 		}
@@ -91,6 +127,23 @@ func NewAttachment(id, contentType, filePath string) (*Attachment, error) {
 	return ct, nil
 }
 
+//DataLength returns the length of the attachment data, whether it is held
+//in Data or streamed from a reader.
+func (a *Attachment) DataLength() int64 {
+	if a.reader != nil {
+		return a.readerSize
+	}
+	return int64(len(a.Data))
+}
+
+//Close releases the reader backing the attachment data if it needs to be.
+func (a *Attachment) Close() error {
+	if c, ok := a.reader.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
 func getSmilStart(smilData []byte) (string, error) {
 	smilStart := string(smilData)
 
@@ -132,7 +185,7 @@ func (dec *MMSDecoder) ReadAttachmentParts(reflectedPdu *reflect.Value) error {
 		return err
 	}
 	var dataParts []Attachment
-	dec.log = dec.log + fmt.Sprintf("Number of parts: %d\n", parts)
+	dec.Logf("Number of parts: %d\n", parts)
 	for i := uint64(0); i < parts; i++ {
 		headerLen, err := dec.ReadUintVar(nil, "")
 		if err != nil {
@@ -143,7 +196,7 @@ func (dec *MMSDecoder) ReadAttachmentParts(reflectedPdu *reflect.Value) error {
 			return err
 		}
 		headerEnd := dec.Offset + int(headerLen)
-		dec.log = dec.log + fmt.Sprintf("Attachament len(header): %d - len(data) %d\n", headerLen, dataLen)
+		dec.Logf("Attachament len(header): %d - len(data) %d\n", headerLen, dataLen)
 		var ct Attachment
 		ct.Offset = headerEnd + 1
 		ctReflected := reflect.ValueOf(&ct).Elem()
@@ -159,7 +212,7 @@ func (dec *MMSDecoder) ReadAttachmentParts(reflectedPdu *reflect.Value) error {
 			return err
 		}
 		if ct.MediaType == "application/smil" || strings.HasPrefix(ct.MediaType, "text/plain") || ct.MediaType == "" {
-			dec.log = dec.log + fmt.Sprintf("%s\n", ct.Data)
+			dec.Logf("%s\n", ct.Data)
 		}
 		if ct.Charset != "" {
 			ct.MediaType = ct.MediaType + ";charset=" + ct.Charset
@@ -176,13 +229,8 @@ func (dec *MMSDecoder) ReadMMSHeaders(ctMember *reflect.Value, headerEnd int) er
 	for dec.Offset < headerEnd {
 		var err error
 		param, _ := dec.ReadInteger(nil, "")
-		switch param {
-		case MMS_PART_CONTENT_LOCATION:
-			_, err = dec.ReadString(ctMember, "ContentLocation")
-		case MMS_PART_CONTENT_ID:
-			_, err = dec.ReadString(ctMember, "ContentId")
-		default:
-			break
+		if hdr, ok := wsp.Headers.Lookup(byte(param)); ok && ctMember.FieldByName(hdr.Field).IsValid() {
+			err = dec.ReadHeader(ctMember, hdr)
 		}
 		if err != nil {
 			return err
@@ -196,9 +244,9 @@ func (dec *MMSDecoder) ReadAttachment(ctMember *reflect.Value) error {
 		return fmt.Errorf("message ended prematurely, offset: %d and payload length is %d", dec.Offset, len(dec.Data))
 	}
 	// These call the same function
-	if next := dec.Data[dec.Offset+1]; next&SHORT_FILTER != 0 {
+	if next := dec.Data[dec.Offset+1]; next&wsp.SHORT_FILTER != 0 {
 		return dec.ReadMediaType(ctMember, "MediaType")
-	} else if next >= TEXT_MIN && next <= TEXT_MAX {
+	} else if next >= wsp.TEXT_MIN && next <= wsp.TEXT_MAX {
 		return dec.ReadMediaType(ctMember, "MediaType")
 	}
 
@@ -207,7 +255,7 @@ func (dec *MMSDecoder) ReadAttachment(ctMember *reflect.Value) error {
 	if length, err = dec.ReadLength(ctMember); err != nil {
 		return err
 	}
-	dec.log = dec.log + fmt.Sprintf("Content Type Length: %d\n", length)
+	dec.Logf("Content Type Length: %d\n", length)
 	endOffset := int(length) + dec.Offset
 
 	if err := dec.ReadMediaType(ctMember, "MediaType"); err != nil {
@@ -217,71 +265,71 @@ func (dec *MMSDecoder) ReadAttachment(ctMember *reflect.Value) error {
 	for dec.Offset < len(dec.Data) && dec.Offset < endOffset {
 		param, _ := dec.ReadInteger(nil, "")
 		switch param {
-		case WSP_PARAMETER_TYPE_Q:
+		case wsp.PARAMETER_TYPE_Q:
 			err = dec.ReadQ(ctMember)
-		case WSP_PARAMETER_TYPE_CHARSET:
+		case wsp.PARAMETER_TYPE_CHARSET:
 			_, err = dec.ReadCharset(ctMember, "Charset")
-		case WSP_PARAMETER_TYPE_LEVEL:
+		case wsp.PARAMETER_TYPE_LEVEL:
 			_, err = dec.ReadShortInteger(ctMember, "Level")
-		case WSP_PARAMETER_TYPE_TYPE:
+		case wsp.PARAMETER_TYPE_TYPE:
 			_, err = dec.ReadInteger(ctMember, "Type")
-		case WSP_PARAMETER_TYPE_NAME_DEFUNCT:
+		case wsp.PARAMETER_TYPE_NAME_DEFUNCT:
 			log.Println("Using deprecated Name header")
 			_, err = dec.ReadString(ctMember, "Name")
-		case WSP_PARAMETER_TYPE_FILENAME_DEFUNCT:
+		case wsp.PARAMETER_TYPE_FILENAME_DEFUNCT:
 			log.Println("Using deprecated FileName header")
 			_, err = dec.ReadString(ctMember, "FileName")
-		case WSP_PARAMETER_TYPE_DIFFERENCES:
+		case wsp.PARAMETER_TYPE_DIFFERENCES:
 			err = errors.New("Unhandled Differences")
-		case WSP_PARAMETER_TYPE_PADDING:
+		case wsp.PARAMETER_TYPE_PADDING:
 			dec.ReadShortInteger(nil, "")
-		case WSP_PARAMETER_TYPE_CONTENT_TYPE:
+		case wsp.PARAMETER_TYPE_CONTENT_TYPE:
 			_, err = dec.ReadString(ctMember, "Type")
-		case WSP_PARAMETER_TYPE_START_DEFUNCT:
+		case wsp.PARAMETER_TYPE_START_DEFUNCT:
 			log.Println("Using deprecated Start header")
 			_, err = dec.ReadString(ctMember, "Start")
-		case WSP_PARAMETER_TYPE_START_INFO_DEFUNCT:
+		case wsp.PARAMETER_TYPE_START_INFO_DEFUNCT:
 			log.Println("Using deprecated StartInfo header")
 			_, err = dec.ReadString(ctMember, "StartInfo")
-		case WSP_PARAMETER_TYPE_COMMENT_DEFUNCT:
+		case wsp.PARAMETER_TYPE_COMMENT_DEFUNCT:
 			log.Println("Using deprecated Comment header")
 			_, err = dec.ReadString(ctMember, "Comment")
-		case WSP_PARAMETER_TYPE_DOMAIN_DEFUNCT:
+		case wsp.PARAMETER_TYPE_DOMAIN_DEFUNCT:
 			log.Println("Using deprecated Domain header")
 			_, err = dec.ReadString(ctMember, "Domain")
-		case WSP_PARAMETER_TYPE_MAX_AGE:
+		case wsp.PARAMETER_TYPE_MAX_AGE:
 			err = errors.New("Unhandled Max Age")
-		case WSP_PARAMETER_TYPE_PATH_DEFUNCT:
+		case wsp.PARAMETER_TYPE_PATH_DEFUNCT:
 			log.Println("Using deprecated Path header")
 			_, err = dec.ReadString(ctMember, "Path")
-		case WSP_PARAMETER_TYPE_SECURE:
+		case wsp.PARAMETER_TYPE_SECURE:
 			log.Println("Unhandled Secure header detected")
-		case WSP_PARAMETER_TYPE_SEC:
+		case wsp.PARAMETER_TYPE_SEC:
 			v, _ := dec.ReadShortInteger(nil, "")
 			log.Println("Using deprecated and unhandled Sec header with value", v)
-		case WSP_PARAMETER_TYPE_MAC:
+		case wsp.PARAMETER_TYPE_MAC:
 			err = errors.New("Unhandled MAC")
-		case WSP_PARAMETER_TYPE_CREATION_DATE:
-		case WSP_PARAMETER_TYPE_MODIFICATION_DATE:
-		case WSP_PARAMETER_TYPE_READ_DATE:
+		case wsp.PARAMETER_TYPE_CREATION_DATE:
+		case wsp.PARAMETER_TYPE_MODIFICATION_DATE:
+		case wsp.PARAMETER_TYPE_READ_DATE:
 			err = errors.New("Unhandled Date parameters")
-		case WSP_PARAMETER_TYPE_SIZE:
+		case wsp.PARAMETER_TYPE_SIZE:
 			_, err = dec.ReadInteger(ctMember, "Size")
-		case WSP_PARAMETER_TYPE_NAME:
+		case wsp.PARAMETER_TYPE_NAME:
 			_, err = dec.ReadString(ctMember, "Name")
-		case WSP_PARAMETER_TYPE_FILENAME:
+		case wsp.PARAMETER_TYPE_FILENAME:
 			_, err = dec.ReadString(ctMember, "FileName")
-		case WSP_PARAMETER_TYPE_START:
+		case wsp.PARAMETER_TYPE_START:
 			_, err = dec.ReadString(ctMember, "Start")
-		case WSP_PARAMETER_TYPE_START_INFO:
+		case wsp.PARAMETER_TYPE_START_INFO:
 			_, err = dec.ReadString(ctMember, "StartInfo")
-		case WSP_PARAMETER_TYPE_COMMENT:
+		case wsp.PARAMETER_TYPE_COMMENT:
 			_, err = dec.ReadString(ctMember, "Comment")
-		case WSP_PARAMETER_TYPE_DOMAIN:
+		case wsp.PARAMETER_TYPE_DOMAIN:
 			_, err = dec.ReadString(ctMember, "Domain")
-		case WSP_PARAMETER_TYPE_PATH:
+		case wsp.PARAMETER_TYPE_PATH:
 			_, err = dec.ReadString(ctMember, "Path")
-		case WSP_PARAMETER_TYPE_UNTYPED:
+		case wsp.PARAMETER_TYPE_UNTYPED:
 			v, _ := dec.ReadString(nil, "")
 			log.Println("Unhandled Secure header detected with value", v)
 		default:
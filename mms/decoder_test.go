@@ -57,3 +57,32 @@ func (s *DecoderTestSuite) TestDecodeStringWithNullByteTerminator(c *C) {
 	c.Check(str, Equals, "<smil>")
 	c.Check(err, IsNil)
 }
+
+func (s *DecoderTestSuite) TestDecodeMCancelReq(c *C) {
+	inputBytes := []byte{
+		//Message Type m-cancel.req
+		0x8C, 0x96,
+		// Transaction Id "0123456"
+		0x98, 0x30, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x00,
+		// MMS Version 1.3
+		0x8D, 0x93,
+		// Cancel Id "http://mmsc/1"
+		0xBE, 0x68, 0x74, 0x74, 0x70, 0x3a, 0x2f, 0x2f, 0x6d, 0x6d, 0x73, 0x63, 0x2f, 0x31, 0x00,
+	}
+	msgType, err := GetMessageType(inputBytes)
+	c.Assert(err, IsNil)
+	c.Check(msgType, Equals, byte(TYPE_CANCEL_REQ))
+
+	mCancelReq := NewMCancelReq()
+	dec := NewDecoder(inputBytes)
+	c.Assert(dec.Decode(mCancelReq), IsNil)
+	c.Check(mCancelReq.TransactionId, Equals, "0123456")
+	c.Check(mCancelReq.Version, Equals, byte(MMS_MESSAGE_VERSION_1_3))
+	c.Check(mCancelReq.CancelId, Equals, "http://mmsc/1")
+}
+
+func (s *DecoderTestSuite) TestDecodeMCancelReqAsMNotificationInd(c *C) {
+	inputBytes := []byte{0x8C, 0x96, 0x98, 0x30, 0x00}
+	dec := NewDecoder(inputBytes)
+	c.Check(dec.Decode(NewMNotificationInd()), NotNil)
+}
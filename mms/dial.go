@@ -0,0 +1,63 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of mms.
+ *
+ * mms is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * mms is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mms
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+)
+
+//newDialer returns the dialer for transfers made with options, bound to
+//the network interface and resolving names with the nameservers of the MMS
+//context when they are set.
+func newDialer(options TransferOptions) *net.Dialer {
+	dialer := &net.Dialer{}
+	if options.Interface != "" {
+		dialer.Control = bindToDevice(options.Interface)
+	}
+	if len(options.Nameservers) > 0 {
+		dialer.Resolver = newResolver(options.Nameservers, &net.Dialer{Control: dialer.Control})
+	}
+	return dialer
+}
+
+//newResolver returns a resolver querying nameservers in turn through
+//dialer instead of the system ones.
+func newResolver(nameservers []string, dialer *net.Dialer) *net.Resolver {
+	var next uint32
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			i := atomic.AddUint32(&next, 1) - 1
+			return dialer.DialContext(ctx, network, nameserverAddress(nameservers[int(i)%len(nameservers)]))
+		},
+	}
+}
+
+//nameserverAddress adds the DNS port to nameserver unless it has one.
+func nameserverAddress(nameserver string) string {
+	if _, _, err := net.SplitHostPort(nameserver); err == nil {
+		return nameserver
+	}
+	return net.JoinHostPort(nameserver, "53")
+}
@@ -0,0 +1,57 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of mms.
+ *
+ * mms is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * mms is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mms
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"syscall"
+)
+
+//bindNotPermitted logs once that sockets are not bound to the interface.
+var bindNotPermitted sync.Once
+
+//bindToDevice returns a dialer Control function binding sockets to the
+//network interface iface.
+//Kernels older than 5.7 only allow it with CAP_NET_RAW, sockets are left
+//unbound and routed by the system when it is not permitted.
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		if controlErr := c.Control(func(fd uintptr) {
+			err = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+		}); controlErr != nil {
+			return controlErr
+		}
+		if err == syscall.EPERM {
+			bindNotPermitted.Do(func() {
+				log.Print("Not permitted to bind to interface ", iface, ", using the default route")
+			})
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("cannot bind to interface %s: %s", iface, err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of mms.
+ *
+ * mms is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * mms is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mms
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	. "launchpad.net/gocheck"
+)
+
+type DialTestSuite struct {
+	dir       string
+	transport *HTTPTransport
+	dns       *net.UDPConn
+}
+
+var _ = Suite(&DialTestSuite{})
+
+func (s *DialTestSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+	s.transport = &HTTPTransport{Dir: s.dir}
+	var err error
+	s.dns, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	c.Assert(err, IsNil)
+	go serveDNS(s.dns, map[string]net.IP{"mmsc.operator.test.": net.IPv4(127, 0, 0, 1)})
+}
+
+func (s *DialTestSuite) TearDownTest(c *C) {
+	s.dns.Close()
+}
+
+//serveDNS answers the A queries for the names in hosts, any other name
+//does not exist and other query types have no answer.
+func serveDNS(conn *net.UDPConn, hosts map[string]net.IP) {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		query := buf[:n]
+		if len(query) < 12 {
+			continue
+		}
+		//the question name is a sequence of labels ended by an empty one
+		var labels []string
+		i := 12
+		for i < n && query[i] != 0 {
+			end := i + 1 + int(query[i])
+			if end > n {
+				break
+			}
+			labels = append(labels, string(query[i+1:end]))
+			i = end
+		}
+		if i+5 > n {
+			continue
+		}
+		qtype := int(query[i+1])<<8 | int(query[i+2])
+		question := query[12 : i+5]
+		ip, known := hosts[strings.ToLower(strings.Join(labels, "."))+"."]
+
+		resp := []byte{query[0], query[1], 0x81, 0x80, 0, 1, 0, 0, 0, 0, 0, 0}
+		if !known {
+			resp[3] |= 3 // NXDOMAIN
+		}
+		resp = append(resp, question...)
+		if known && qtype == 1 {
+			resp[7] = 1
+			resp = append(resp, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4)
+			resp = append(resp, ip.To4()...)
+		}
+		conn.WriteToUDP(resp, addr)
+	}
+}
+
+//mmscServer serves a PDU and returns the address of the MMSC on the stub
+//DNS name.
+func mmscServer(c *C) (*httptest.Server, string) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte{0x8c, 0x84})
+	}))
+	u, err := url.Parse(server.URL)
+	c.Assert(err, IsNil)
+	_, port, _ := net.SplitHostPort(u.Host)
+	return server, "http://mmsc.operator.test:" + port + "/mms"
+}
+
+func (s *DialTestSuite) TestResolveWithContextNameservers(c *C) {
+	server, mmsc := mmscServer(c)
+	defer server.Close()
+
+	file := filepath.Join(s.dir, "download")
+	options := TransferOptions{Nameservers: []string{s.dns.LocalAddr().String()}}
+	c.Assert(s.transport.Download(mmsc, file, options, nil, nil), IsNil)
+	data, err := ioutil.ReadFile(file)
+	c.Assert(err, IsNil)
+	c.Check(data, DeepEquals, []byte{0x8c, 0x84})
+}
+
+func (s *DialTestSuite) TestResolveUnknownHost(c *C) {
+	options := TransferOptions{Nameservers: []string{s.dns.LocalAddr().String()}}
+	err := s.transport.Download("http://unknown.operator.test/mms", filepath.Join(s.dir, "download"), options, nil, nil)
+	c.Check(err, ErrorMatches, ".*no such host.*")
+}
+
+func (s *DialTestSuite) TestBindToInterface(c *C) {
+	server, mmsc := mmscServer(c)
+	defer server.Close()
+
+	file := filepath.Join(s.dir, "download")
+	options := TransferOptions{
+		Interface:   "lo",
+		Nameservers: []string{s.dns.LocalAddr().String()},
+	}
+	c.Assert(s.transport.Download(mmsc, file, options, nil, nil), IsNil)
+	data, err := ioutil.ReadFile(file)
+	c.Assert(err, IsNil)
+	c.Check(data, DeepEquals, []byte{0x8c, 0x84})
+}
+
+func (s *DialTestSuite) TestBindToMissingInterface(c *C) {
+	if runtime.GOOS != "linux" {
+		c.Skip("binding to an interface is only supported on Linux")
+	}
+	server, _ := mmscServer(c)
+	defer server.Close()
+
+	options := TransferOptions{Interface: "nuntium-none0"}
+	err := s.transport.Download(server.URL, filepath.Join(s.dir, "download"), options, nil, nil)
+	if err == nil {
+		c.Skip("binding to an interface is not permitted, the dial is not bound")
+	}
+	c.Check(err, ErrorMatches, ".*cannot bind to interface nuntium-none0.*")
+}
+
+func (s *DialTestSuite) TestNameserverAddress(c *C) {
+	c.Check(nameserverAddress("10.0.0.1"), Equals, "10.0.0.1:53")
+	c.Check(nameserverAddress("10.0.0.1:5353"), Equals, "10.0.0.1:5353")
+	c.Check(nameserverAddress("fd00::1"), Equals, "[fd00::1]:53")
+}
@@ -23,70 +23,21 @@ package mms
 
 import (
 	"errors"
-	"fmt"
-	"log"
-	"time"
-
-	"launchpad.net/udm"
 )
 
-func (pdu *MNotificationInd) DownloadContent(proxyHost string, proxyPort int32) (string, error) {
-	downloadManager, err := udm.NewDownloadManager()
-	if err != nil {
-		return "", err
-	}
-	download, err := downloadManager.CreateMmsDownload(pdu.ContentLocation, proxyHost, proxyPort)
-	if err != nil {
-		return "", err
-	}
-	f := download.Finished()
-	p := download.DownloadProgress()
-	e := download.Error()
-	log.Print("Starting download of ", pdu.ContentLocation, " with proxy ", proxyHost, ":", proxyPort)
-	download.Start()
-	for {
-		select {
-		case progress := <-p:
-			log.Print("Progress:", progress.Total, progress.Received)
-		case downloadFilePath := <-f:
-			log.Print("File downloaded to ", downloadFilePath)
-			return downloadFilePath, nil
-		case <-time.After(3 * time.Minute):
-			return "", fmt.Errorf("Download timeout exceeded while fetching %s", pdu.ContentLocation)
-		case err := <-e:
-			return "", err
-		}
-	}
-}
-
-func Upload(file, msc, proxyHost string, proxyPort int32) (string, error) {
-	udm, err := udm.NewUploadManager()
-	if err != nil {
-		return "", err
-	}
-	upload, err := udm.CreateMmsUpload(msc, file, proxyHost, proxyPort)
-	if err != nil {
-		return "", err
-	}
-	f := upload.Finished()
-	p := upload.UploadProgress()
-	e := upload.Error()
-	log.Print("Starting upload of ", file, " to ", msc, " with proxy ", proxyHost, ":", proxyPort)
-	if err := upload.Start(); err != nil {
-		return "", err
-	}
+var (
+	ErrCanceled = errors.New("transfer canceled")
+	//ErrDeadlineExceeded is returned when a transfer takes longer than its
+	//Timeouts.Deadline
+	ErrDeadlineExceeded = errors.New("transfer deadline exceeded")
+	//ErrInactive is returned when a transfer goes without any data for
+	//longer than its Timeouts.Inactivity
+	ErrInactive = errors.New("transfer inactive for too long")
+)
 
-	for {
-		select {
-		case progress := <-p:
-			log.Print("Progress:", progress.Total, progress.Received)
-		case responseFile := <-f:
-			log.Print("File ", responseFile, " returned in upload")
-			return responseFile, nil
-		case <-time.After(10 * time.Minute):
-			return "", errors.New("upload timeout")
-		case err := <-e:
-			return "", err
-		}
-	}
+//DownloadContent retrieves the message the notification refers to into
+//file with transport, resuming a previous partial download. It is aborted
+//by Cancel.
+func (pdu *MNotificationInd) DownloadContent(transport Transport, file string, options TransferOptions, progress Progress) error {
+	return transport.Download(pdu.ContentLocation, file, options, pdu.cancel, progress)
 }
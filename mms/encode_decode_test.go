@@ -38,12 +38,12 @@ var _ = Suite(&EncodeDecodeTestSuite{})
 func (s *EncodeDecodeTestSuite) SetUpTest(c *C) {
 	s.bytes = new(bytes.Buffer)
 	s.enc = NewEncoder(s.bytes)
-	c.Assert(s.enc.writeByte(0), IsNil)
+	c.Assert(s.enc.WriteByte(0), IsNil)
 }
 
 func (s *EncodeDecodeTestSuite) TestString(c *C) {
 	testStr := "'Hello World!"
-	c.Assert(s.enc.writeString(testStr), IsNil)
+	c.Assert(s.enc.WriteString(testStr), IsNil)
 	s.dec = NewDecoder(s.bytes.Bytes())
 
 	str, err := s.dec.ReadString(nil, "")
@@ -54,7 +54,7 @@ func (s *EncodeDecodeTestSuite) TestString(c *C) {
 func (s *EncodeDecodeTestSuite) TestByte(c *C) {
 	testBytes := []byte{0, 0x79, 0x80, 0x81}
 	for i := range testBytes {
-		c.Assert(s.enc.writeByte(testBytes[i]), IsNil)
+		c.Assert(s.enc.WriteByte(testBytes[i]), IsNil)
 	}
 	bytes := s.bytes.Bytes()
 	s.dec = NewDecoder(bytes)
@@ -69,7 +69,7 @@ func (s *EncodeDecodeTestSuite) TestInteger(c *C) {
 	// 128 bounds short and long integers
 	testInts := []uint64{512, 100, 127, 128, 129, 255, 256, 511, 3000}
 	for i := range testInts {
-		c.Assert(s.enc.writeInteger(testInts[i]), IsNil)
+		c.Assert(s.enc.WriteInteger(testInts[i]), IsNil)
 	}
 	bytes := s.bytes.Bytes()
 	s.dec = NewDecoder(bytes)
@@ -83,7 +83,7 @@ func (s *EncodeDecodeTestSuite) TestInteger(c *C) {
 func (s *EncodeDecodeTestSuite) TestUintVar(c *C) {
 	testInts := []uint64{127, 512, 255, 256, 3000}
 	for i := range testInts {
-		c.Assert(s.enc.writeUintVar(testInts[i]), IsNil)
+		c.Assert(s.enc.WriteUintVar(testInts[i]), IsNil)
 	}
 	bytes := s.bytes.Bytes()
 	s.dec = NewDecoder(bytes)
@@ -98,7 +98,7 @@ func (s *EncodeDecodeTestSuite) TestLength(c *C) {
 	// > 30 requires encoding with length quote
 	testLengths := []uint64{10, 1, 29, 30, 31, 500}
 	for i := range testLengths {
-		c.Assert(s.enc.writeLength(testLengths[i]), IsNil)
+		c.Assert(s.enc.WriteLength(testLengths[i]), IsNil)
 	}
 	bytes := s.bytes.Bytes()
 	s.dec = NewDecoder(bytes)
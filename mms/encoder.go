@@ -28,15 +28,18 @@ import (
 	"io"
 	"log"
 	"reflect"
+	"strings"
+
+	"github.com/ubuntu-phonedations/nuntium/wsp"
 )
 
 type MMSEncoder struct {
-	w   io.Writer
+	*wsp.Encoder
 	log string
 }
 
 func NewEncoder(w io.Writer) *MMSEncoder {
-	return &MMSEncoder{w: w}
+	return &MMSEncoder{Encoder: wsp.NewEncoder(w)}
 }
 
 func (enc *MMSEncoder) Encode(pdu MMSWriter) error {
@@ -50,7 +53,7 @@ func (enc *MMSEncoder) Encode(pdu MMSWriter) error {
 		encodeTag := typeOfPdu.Field(i).Tag.Get("encode")
 		f := rPdu.Field(i)
 
-		if encodeTag == "no" {
+		if encodeTag == "no" || typeOfPdu.Field(i).PkgPath != "" || typeOfPdu.Field(i).Anonymous {
 			continue
 		}
 		switch f.Kind() {
@@ -64,47 +67,42 @@ func (enc *MMSEncoder) Encode(pdu MMSWriter) error {
 		}
 
 		switch fieldName {
-		case "Type":
-			err = enc.writeByteParam(X_MMS_MESSAGE_TYPE, byte(f.Uint()))
-		case "Version":
-			err = enc.writeByteParam(X_MMS_MMS_VERSION, byte(f.Uint()))
-		case "TransactionId":
-			err = enc.writeStringParam(X_MMS_TRANSACTION_ID, f.String())
-		case "Status":
-			err = enc.writeByteParam(X_MMS_STATUS, byte(f.Uint()))
 		case "From":
-			err = enc.writeFrom()
+			if encodeTag == "optional" && f.String() == "" {
+				break
+			}
+			err = enc.writeHeader(FROM, f.String())
 		case "Name":
-			err = enc.writeStringParam(WSP_PARAMETER_TYPE_NAME_DEFUNCT, f.String())
+			err = enc.writeStringParam(wsp.PARAMETER_TYPE_NAME_DEFUNCT, f.String())
 		case "Start":
-			err = enc.writeStringParam(WSP_PARAMETER_TYPE_START_DEFUNCT, f.String())
-		case "To":
-			for i := 0; i < f.Len(); i++ {
-				err = enc.writeStringParam(TO, f.Index(i).String())
-				if err != nil {
-					break
-				}
-			}
+			err = enc.writeStringParam(wsp.PARAMETER_TYPE_START_DEFUNCT, f.String())
 		case "ContentType":
 			// if there is a ContentType there has to be content
 			if mSendReq, ok := pdu.(*MSendReq); ok {
-				if err := enc.setParam(CONTENT_TYPE); err != nil {
+				if err := enc.SetParam(CONTENT_TYPE); err != nil {
 					return err
 				}
-				if err = enc.writeContentType(mSendReq.ContentType, mSendReq.ContentTypeStart, mSendReq.ContentTypeType, ""); err != nil {
+				if err = enc.writeContentType(mSendReq.ContentType, mSendReq.ContentTypeStart, mSendReq.ContentTypeType, "", ""); err != nil {
 					return err
 				}
 				err = enc.writeAttachments(mSendReq.Attachments)
 			} else {
 				err = errors.New("unhandled content type")
 			}
+		case "Content":
+			if mRetrieveConf, ok := pdu.(*MRetrieveConf); ok {
+				err = enc.writeContent(mRetrieveConf)
+			} else {
+				err = errors.New("unhandled content")
+			}
 		case "MediaType":
 			if a, ok := pdu.(*Attachment); ok {
-				if err = enc.writeContentType(a.MediaType, "", "", a.Name); err != nil {
+				media, charset := splitCharset(a.MediaType)
+				if err = enc.writeContentType(media, "", "", a.Name, charset); err != nil {
 					return err
 				}
 			} else {
-				if err = enc.writeMediaType(f.String()); err != nil {
+				if err = enc.WriteMediaType(f.String()); err != nil {
 					return err
 				}
 			}
@@ -112,33 +110,30 @@ func (enc *MMSEncoder) Encode(pdu MMSWriter) error {
 			//TODO
 			err = enc.writeCharset(f.String())
 		case "ContentLocation":
-			err = enc.writeStringParam(MMS_PART_CONTENT_LOCATION, f.String())
-		case "ContentId":
-			err = enc.writeQuotedStringParam(MMS_PART_CONTENT_ID, f.String())
-		case "Date":
-			date := f.Uint()
-			if date > 0 {
-				err = enc.writeLongIntegerParam(DATE, date)
-			}
-		case "Class":
-			err = enc.writeByteParam(X_MMS_MESSAGE_CLASS, byte(f.Uint()))
-		case "ReportAllowed":
-			err = enc.writeByteParam(X_MMS_REPORT_ALLOWED, byte(f.Uint()))
-		case "DeliveryReport":
-			err = enc.writeByteParam(X_MMS_DELIVERY_REPORT, byte(f.Uint()))
-		case "ReadReport":
-			err = enc.writeByteParam(X_MMS_READ_REPORT, byte(f.Uint()))
-		case "Expiry":
-			expiry := f.Uint()
-			if expiry > 0 {
-				err = enc.writeRelativeExpiry(expiry)
+			// a part header for attachments, the last header of a
+			// m-notification.ind otherwise
+			if _, ok := pdu.(*Attachment); ok {
+				err = enc.writeStringParam(wsp.CONTENT_LOCATION, f.String())
+			} else {
+				err = enc.writeHeader(X_MMS_CONTENT_LOCATION, f.String())
 			}
+		case "ContentId":
+			err = enc.writeQuotedStringParam(wsp.CONTENT_ID, f.String())
 		default:
-			if encodeTag == "optional" {
-				log.Printf("Unhandled optional field %s", fieldName)
-			} else {
-				panic(fmt.Sprintf("missing encoding for mandatory field %s", fieldName))
+			hdr, ok := Headers.LookupField(fieldName)
+			if !ok || hdr.Codec.Encode == nil {
+				if encodeTag == "optional" {
+					log.Printf("Unhandled optional field %s", fieldName)
+				} else {
+					panic(fmt.Sprintf("missing encoding for mandatory field %s", fieldName))
+				}
+				break
+			}
+			if isZero(f) && (encodeTag == "optional" || f.Kind() == reflect.String) {
+				enc.log = enc.log + fmt.Sprintf("Skipping empty %s\n", fieldName)
+				break
 			}
+			err = enc.writeField(hdr, f)
 		}
 		if err != nil {
 			return fmt.Errorf("cannot encode field %s with value %s: %s ... encoded so far: %s", fieldName, f, err, enc.log)
@@ -147,8 +142,48 @@ func (enc *MMSEncoder) Encode(pdu MMSWriter) error {
 	return nil
 }
 
-func (enc *MMSEncoder) setParam(param byte) error {
-	return enc.writeByte(param | 0x80)
+func isZero(f reflect.Value) bool {
+	switch f.Kind() {
+	case reflect.String, reflect.Slice:
+		return f.Len() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return f.Uint() == 0
+	}
+	return false
+}
+
+//writeField writes the header registered for a PDU member, slices are
+//written as one header per element.
+func (enc *MMSEncoder) writeField(hdr *wsp.Header, f reflect.Value) error {
+	switch f.Kind() {
+	case reflect.String:
+		return enc.writeHeader(hdr.Code, f.String())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return enc.writeHeader(hdr.Code, f.Uint())
+	case reflect.Slice:
+		for i := 0; i < f.Len(); i++ {
+			if err := enc.writeField(hdr, f.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Struct:
+		return enc.writeHeader(hdr.Code, f.Interface())
+	}
+	return fmt.Errorf("cannot encode %s of kind %s", hdr.Name, f.Kind())
+}
+
+//writeHeader writes the field name for code followed by v encoded with the
+//codec registered for it in Headers.
+func (enc *MMSEncoder) writeHeader(code byte, v interface{}) error {
+	hdr, ok := Headers.Lookup(code)
+	if !ok {
+		return fmt.Errorf("no header registered for %#x", code)
+	}
+	if err := enc.SetParam(hdr.Code); err != nil {
+		return err
+	}
+	return hdr.Codec.Encode(enc.Encoder, v)
 }
 
 func encodeAttachment(attachment *Attachment) ([]byte, error) {
@@ -160,9 +195,42 @@ func encodeAttachment(attachment *Attachment) ([]byte, error) {
 	return outBytes.Bytes(), nil
 }
 
+//writeContent writes the Content-Type header of a m-retrieve.conf followed
+//by its parts, or by its Data for a text/plain message as the decoder
+//expects. Nothing is written for a message without content.
+func (enc *MMSEncoder) writeContent(mRetrieveConf *MRetrieveConf) error {
+	content := mRetrieveConf.Content
+	if content.MediaType == "" {
+		return nil
+	}
+	if err := enc.SetParam(CONTENT_TYPE); err != nil {
+		return err
+	}
+	if err := enc.writeContentType(content.MediaType, content.Start, content.Type, content.Name, content.Charset); err != nil {
+		return err
+	}
+	if content.MediaType == "text/plain" {
+		return enc.WriteBytes(mRetrieveConf.Data, len(mRetrieveConf.Data))
+	}
+	attachments := make([]*Attachment, len(mRetrieveConf.Attachments))
+	for i := range mRetrieveConf.Attachments {
+		attachments[i] = &mRetrieveConf.Attachments[i]
+	}
+	return enc.writeAttachments(attachments)
+}
+
+//splitCharset splits the charset the decoder appends to the media type of
+//the parts it reads.
+func splitCharset(media string) (string, string) {
+	if i := strings.Index(media, ";charset="); i != -1 {
+		return media[:i], media[i+len(";charset="):]
+	}
+	return media, ""
+}
+
 func (enc *MMSEncoder) writeAttachments(attachments []*Attachment) error {
 	// Write the number of parts
-	if err := enc.writeUintVar(uint64(len(attachments))); err != nil {
+	if err := enc.WriteUintVar(uint64(len(attachments))); err != nil {
 		return err
 	}
 
@@ -176,18 +244,23 @@ func (enc *MMSEncoder) writeAttachments(attachments []*Attachment) error {
 
 		// headers length
 		headerLength := uint64(len(attachmentHeader))
-		if err := enc.writeUintVar(headerLength); err != nil {
+		if err := enc.WriteUintVar(headerLength); err != nil {
 			return err
 		}
 		// data length
-		dataLength := uint64(len(attachments[i].Data))
-		if err := enc.writeUintVar(dataLength); err != nil {
+		dataLength := attachments[i].DataLength()
+		if err := enc.WriteUintVar(uint64(dataLength)); err != nil {
 			return err
 		}
-		if err := enc.writeBytes(attachmentHeader, int(headerLength)); err != nil {
+		if err := enc.WriteBytes(attachmentHeader, int(headerLength)); err != nil {
 			return err
 		}
-		if err := enc.writeBytes(attachments[i].Data, int(dataLength)); err != nil {
+		// stream the data straight from its backing file when there is one
+		if r := attachments[i].reader; r != nil {
+			if err := enc.WriteReader(io.NewSectionReader(r, 0, dataLength), dataLength); err != nil {
+				return err
+			}
+		} else if err := enc.WriteBytes(attachments[i].Data, int(dataLength)); err != nil {
 			return err
 		}
 	}
@@ -198,65 +271,46 @@ func (enc *MMSEncoder) writeCharset(charset string) error {
 	if charset == "" {
 		return nil
 	}
-	charsetCode := uint64(ANY_CHARSET)
-	for k, v := range CHARSETS {
-		if v == charset {
-			charsetCode = k
-		}
-	}
-	return enc.writeIntegerParam(WSP_PARAMETER_TYPE_CHARSET, charsetCode)
+	return enc.writeIntegerParam(wsp.PARAMETER_TYPE_CHARSET, wsp.EncodeCharset(charset))
 }
 
-func (enc *MMSEncoder) writeLength(length uint64) error {
-	if length <= SHORT_LENGTH_MAX {
-		return enc.writeByte(byte(length))
-	} else {
-		if err := enc.writeByte(LENGTH_QUOTE); err != nil {
-			return err
-		}
-		return enc.writeUintVar(length)
-	}
-}
-
-func encodeContentType(media string) (uint64, error) {
-	var mt int
-	for mt = range CONTENT_TYPES {
-		if CONTENT_TYPES[mt] == media {
-			return uint64(mt), nil
-		}
-	}
-	return 0, errors.New("cannot binary encode media")
-}
-
-func (enc *MMSEncoder) writeContentType(media, start, ctype, name string) error {
-	if start == "" && ctype == "" && name == "" {
-		return enc.writeMediaType(media)
+func (enc *MMSEncoder) writeContentType(media, start, ctype, name, charset string) error {
+	if start == "" && ctype == "" && name == "" && charset == "" {
+		return enc.WriteMediaType(media)
 	}
 
 	var contentType []byte
 	if start != "" {
-		contentType = append(contentType, WSP_PARAMETER_TYPE_START_DEFUNCT|SHORT_FILTER)
+		contentType = append(contentType, wsp.PARAMETER_TYPE_START_DEFUNCT|wsp.SHORT_FILTER)
 		contentType = append(contentType, []byte(start)...)
 		contentType = append(contentType, 0)
 	}
 	if ctype != "" {
-		contentType = append(contentType, WSP_PARAMETER_TYPE_CONTENT_TYPE|SHORT_FILTER)
+		contentType = append(contentType, wsp.PARAMETER_TYPE_CONTENT_TYPE|wsp.SHORT_FILTER)
 		contentType = append(contentType, []byte(ctype)...)
 		contentType = append(contentType, 0)
 	}
 	if name != "" {
-		contentType = append(contentType, WSP_PARAMETER_TYPE_NAME_DEFUNCT|SHORT_FILTER)
+		contentType = append(contentType, wsp.PARAMETER_TYPE_NAME_DEFUNCT|wsp.SHORT_FILTER)
 		contentType = append(contentType, []byte(name)...)
 		contentType = append(contentType, 0)
 	}
+	if charset != "" {
+		var b bytes.Buffer
+		if err := wsp.NewEncoder(&b).WriteInteger(wsp.EncodeCharset(charset)); err != nil {
+			return err
+		}
+		contentType = append(contentType, wsp.PARAMETER_TYPE_CHARSET|wsp.SHORT_FILTER)
+		contentType = append(contentType, b.Bytes()...)
+	}
 
-	if mt, err := encodeContentType(media); err == nil {
+	if mt, err := wsp.EncodeContentType(media); err == nil {
 		// +1 for mt
 		length := uint64(len(contentType) + 1)
-		if err := enc.writeLength(length); err != nil {
+		if err := enc.WriteLength(length); err != nil {
 			return err
 		}
-		if err := enc.writeInteger(mt); err != nil {
+		if err := enc.WriteInteger(mt); err != nil {
 			return err
 		}
 	} else {
@@ -264,66 +318,28 @@ func (enc *MMSEncoder) writeContentType(media, start, ctype, name string) error
 		mediaB = append(mediaB, 0)
 		contentType = append(mediaB, contentType...)
 		length := uint64(len(contentType))
-		if err := enc.writeLength(length); err != nil {
+		if err := enc.WriteLength(length); err != nil {
 			return err
 		}
 	}
-	return enc.writeBytes(contentType, len(contentType))
-}
-
-func (enc *MMSEncoder) writeMediaType(media string) error {
-	if mt, err := encodeContentType(media); err == nil {
-		return enc.writeInteger(mt)
-	}
-
-	// +1 is the byte{0}
-	if err := enc.writeByte(byte(len(media) + 1)); err != nil {
-		return err
-	}
-	return enc.writeString(media)
-}
-
-func (enc *MMSEncoder) writeRelativeExpiry(expiry uint64) error {
-	if err := enc.setParam(X_MMS_EXPIRY); err != nil {
-		return err
-	}
-	encodedLong := encodeLong(expiry)
-
-	var b []byte
-	// +1 for the token, +1 for the len of long
-	b = append(b, byte(len(encodedLong)+2))
-	b = append(b, ExpiryTokenRelative)
-	b = append(b, byte(len(encodedLong)))
-	b = append(b, encodedLong...)
-
-	return enc.writeBytes(b, len(b))
-}
-
-func (enc *MMSEncoder) writeLongIntegerParam(param byte, i uint64) error {
-	if err := enc.setParam(param); err != nil {
-		return err
-	}
-	return enc.writeLongInteger(i)
+	return enc.WriteBytes(contentType, len(contentType))
 }
 
 func (enc *MMSEncoder) writeIntegerParam(param byte, i uint64) error {
-	if err := enc.setParam(param); err != nil {
+	if err := enc.SetParam(param); err != nil {
 		return err
 	}
-	return enc.writeInteger(i)
+	return enc.WriteInteger(i)
 }
 
 func (enc *MMSEncoder) writeQuotedStringParam(param byte, s string) error {
 	if s == "" {
 		enc.log = enc.log + "Skipping empty string\n"
 	}
-	if err := enc.setParam(param); err != nil {
-		return err
-	}
-	if err := enc.writeByte(STRING_QUOTE); err != nil {
+	if err := enc.SetParam(param); err != nil {
 		return err
 	}
-	return enc.writeString(s)
+	return enc.WriteQuotedString(s)
 }
 
 func (enc *MMSEncoder) writeStringParam(param byte, s string) error {
@@ -331,123 +347,8 @@ func (enc *MMSEncoder) writeStringParam(param byte, s string) error {
 		enc.log = enc.log + "Skipping empty string\n"
 		return nil
 	}
-	if err := enc.setParam(param); err != nil {
-		return err
-	}
-	return enc.writeString(s)
-}
-
-func (enc *MMSEncoder) writeByteParam(param byte, b byte) error {
-	if err := enc.setParam(param); err != nil {
-		return err
-	}
-	return enc.writeByte(b)
-}
-
-func (enc *MMSEncoder) writeFrom() error {
-	if err := enc.setParam(FROM); err != nil {
+	if err := enc.SetParam(param); err != nil {
 		return err
 	}
-	if err := enc.writeByte(1); err != nil {
-		return err
-	}
-	return enc.writeByte(TOKEN_INSERT_ADDRESS)
-}
-
-func (enc *MMSEncoder) writeString(s string) error {
-	bytes := []byte(s)
-	bytes = append(bytes, 0)
-	_, err := enc.w.Write(bytes)
-	return err
-}
-
-func (enc *MMSEncoder) writeBytes(b []byte, count int) error {
-	if n, err := enc.w.Write(b); n != count {
-		return fmt.Errorf("expected to write %d byte[s] but wrote %d", count, n)
-	} else if err != nil {
-		return err
-	}
-	return nil
-}
-
-func (enc *MMSEncoder) writeByte(b byte) error {
-	return enc.writeBytes([]byte{b}, 1)
-}
-
-// writeShort encodes i according to the Basic Rules described in section
-// 8.4.2.2 of WAP-230-WSP-20010705-a.
-//
-// Integers in range 0-127 (< 0x80) shall be encoded as a one octet value
-// with the most significant bit set to one (1xxx xxxx == |0x80) and with
-// the value in the remaining least significant bits.
-func (enc *MMSEncoder) writeShortInteger(i uint64) error {
-	return enc.writeByte(byte(i | 0x80))
-}
-
-// writeLongInteger encodes i according to the Basic Rules described in section
-// 8.4.2.2 of WAP-230-WSP-20010705-a.
-//
-// Long-integer = Short-length Multi-octet-integer
-// The Short-length indicates the length of the Multi-octet-integer
-//
-// Multi-octet-integer = 1*30 OCTET
-// The content octets shall be an unsigned integer value
-// with the most significant octet encoded first (big-endian representation).
-// The minimum number of octets must be used to encode the value.
-func (enc *MMSEncoder) writeLongInteger(i uint64) error {
-	encodedLong := encodeLong(i)
-	encLength := uint64(len(encodedLong))
-	if encLength > SHORT_LENGTH_MAX {
-		return fmt.Errorf("cannot encode long integer, lenght was %d but expected %d", encLength, SHORT_LENGTH_MAX)
-	}
-	if err := enc.writeByte(byte(encLength)); err != nil {
-		return err
-	}
-
-	return enc.writeBytes(encodedLong, len(encodedLong))
-}
-
-func encodeLong(i uint64) (encodedLong []byte) {
-	for i > 0 {
-		b := byte(0xff & i)
-		encodedLong = append([]byte{b}, encodedLong...)
-		i = i >> 8
-	}
-	return encodedLong
-}
-
-// writeInteger encodes i according to the Basic Rules described in section
-// 8.4.2.2 of WAP-230-WSP-20010705-a.
-//
-// It encodes as a Short-integer when i < 128 (=0x80) or as a Long-Integer
-// otherwise
-func (enc *MMSEncoder) writeInteger(i uint64) error {
-	if i < 0x80 {
-		return enc.writeShortInteger(i)
-	} else {
-		return enc.writeLongInteger(i)
-	}
-	return nil
-}
-
-// writeUintVar encodes v according to section 8.1.2 and the Basic Rules
-// described in section 8.4.2.2 of WAP-230-WSP-20010705-a.
-//
-// To encode a large unsigned integer, split it into 7-bit (0x7f) fragments
-// and place them in the payloads of multiple octets. The most significant
-// bits are placed in the first octets with the least significant bits ending
-// up in the last octet. All octets MUST set the Continue bit to 1 (|0x80)
-// except the last octet, which MUST set the Continue bit to 0.
-//
-// The unsigned integer MUST be encoded in the smallest encoding possible.
-// In other words, the encoded value MUST NOT start with an octet with the
-// value 0x80.
-func (enc *MMSEncoder) writeUintVar(v uint64) error {
-	uintVar := []byte{byte(v & 0x7f)}
-	v = v >> 7
-	for v > 0 {
-		uintVar = append([]byte{byte(0x80 | (v & 0x7f))}, uintVar...)
-		v = v >> 7
-	}
-	return enc.writeBytes(uintVar, len(uintVar))
+	return enc.WriteString(s)
 }
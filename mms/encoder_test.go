@@ -25,6 +25,7 @@ import (
 	"bytes"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	. "launchpad.net/gocheck"
@@ -138,4 +139,76 @@ func (s *EncoderTestSuite) TestEncodeMSendReq(c *C) {
 	enc := NewEncoder(&outBytes)
 	err = enc.Encode(mSendReq)
 	c.Assert(err, IsNil)
+	c.Assert(att.Close(), IsNil)
+}
+
+func (s *EncoderTestSuite) TestEncodeStreamedAttachment(c *C) {
+	data := bytes.Repeat([]byte{1, 2, 3, 4, 5, 6}, 1000)
+	streamed, err := NewAttachmentReader("text0", "text/plain;charset=utf-8", bytes.NewReader(data), int64(len(data)))
+	c.Assert(err, IsNil)
+	c.Check(streamed.Data, IsNil)
+	c.Check(streamed.DataLength(), Equals, int64(len(data)))
+
+	inMemory := *streamed
+	inMemory.reader, inMemory.readerSize = nil, 0
+	inMemory.Data = data
+
+	var streamedBytes, inMemoryBytes bytes.Buffer
+	c.Assert(NewEncoder(&streamedBytes).writeAttachments([]*Attachment{streamed}), IsNil)
+	c.Assert(NewEncoder(&inMemoryBytes).writeAttachments([]*Attachment{&inMemory}), IsNil)
+	c.Check(streamedBytes.Bytes(), DeepEquals, inMemoryBytes.Bytes())
+}
+
+func (s *EncoderTestSuite) TestEncodeTruncatedAttachment(c *C) {
+	data := []byte{1, 2, 3}
+	att, err := NewAttachmentReader("text0", "text/plain", bytes.NewReader(data), 6)
+	c.Assert(err, IsNil)
+
+	var outBytes bytes.Buffer
+	c.Check(NewEncoder(&outBytes).writeAttachments([]*Attachment{att}), NotNil)
+}
+
+func (s *EncoderTestSuite) TestNewAttachmentSmilIsRead(c *C) {
+	smil := []byte(`<smil><body><par><text src="text0.txt"/></par></body></smil>`)
+	att, err := NewAttachmentReader("smil", "application/smil", bytes.NewReader(smil), int64(len(smil)))
+	c.Assert(err, IsNil)
+	c.Check(att.Data, DeepEquals, smil)
+	c.Check(att.ContentId, Equals, "<smil>")
+	c.Check(att.DataLength(), Equals, int64(len(smil)))
+}
+
+func (s *EncoderTestSuite) TestNewAttachmentSmilIsClosed(c *C) {
+	smil := []byte(`<smil><body><par><text src="text0.txt"/></par></body></smil>`)
+	filePath := filepath.Join(c.MkDir(), "smil.xml")
+	c.Assert(ioutil.WriteFile(filePath, smil, 0600), IsNil)
+	f, err := os.Open(filePath)
+	c.Assert(err, IsNil)
+	att, err := NewAttachmentReader("smil", "application/smil", f, int64(len(smil)))
+	c.Assert(err, IsNil)
+	c.Check(att.Data, DeepEquals, smil)
+	c.Check(f.Close(), NotNil)
+}
+
+func (s *EncoderTestSuite) TestEncodeMCancelConf(c *C) {
+	expectedBytes := []byte{
+		//Message Type m-cancel.conf
+		0x8C, 0x97,
+		// Transaction Id
+		0x98, 0x30, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x00,
+		// MMS Version 1.3
+		0x8D, 0x93,
+		// Cancel Status received
+		0xBF, 0x80,
+	}
+	mCancelReq := &MCancelReq{
+		UUID:          "1",
+		Type:          TYPE_CANCEL_REQ,
+		TransactionId: "0123456",
+		Version:       MMS_MESSAGE_VERSION_1_3,
+		CancelId:      "http://mmsc/1",
+	}
+	var outBytes bytes.Buffer
+	enc := NewEncoder(&outBytes)
+	c.Assert(enc.Encode(mCancelReq.NewMCancelConf(CancelStatusReceived)), IsNil)
+	c.Assert(outBytes.Bytes(), DeepEquals, expectedBytes)
 }
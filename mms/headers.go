@@ -0,0 +1,182 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of mms.
+ *
+ * mms is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * mms is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mms
+
+import (
+	"fmt"
+
+	"github.com/ubuntu-phonedations/nuntium/wsp"
+)
+
+//Headers holds the MMS header field assignments from OMA-WAP-MMS-ENC
+//section 7.4 that the decoder and encoder know about. Application or vendor
+//specific headers can be registered here to have them decoded into a PDU
+//member of the same Field name.
+var Headers = wsp.NewRegistry().MustRegister(
+	wsp.Header{Code: BCC, Name: "Bcc", Field: "Bcc", Codec: wsp.EncodedString, Version: MMS_MESSAGE_VERSION_1_0},
+	wsp.Header{Code: CC, Name: "Cc", Field: "Cc", Codec: wsp.EncodedString, Version: MMS_MESSAGE_VERSION_1_0},
+	wsp.Header{Code: X_MMS_CONTENT_LOCATION, Name: "X-Mms-Content-Location", Field: "ContentLocation", Codec: wsp.TextString, Version: MMS_MESSAGE_VERSION_1_0},
+	wsp.Header{Code: CONTENT_TYPE, Name: "Content-Type", Field: "ContentType", Codec: wsp.MediaType, Version: MMS_MESSAGE_VERSION_1_0},
+	wsp.Header{Code: DATE, Name: "Date", Field: "Date", Codec: wsp.LongInteger, Version: MMS_MESSAGE_VERSION_1_0},
+	wsp.Header{Code: X_MMS_DELIVERY_REPORT, Name: "X-Mms-Delivery-Report", Field: "DeliveryReport", Codec: wsp.Octet, Version: MMS_MESSAGE_VERSION_1_0},
+	wsp.Header{Code: X_MMS_DELIVERY_TIME, Name: "X-Mms-Delivery-Time", Field: "DeliveryTime", Codec: expiryCodec, Version: MMS_MESSAGE_VERSION_1_0},
+	wsp.Header{Code: X_MMS_EXPIRY, Name: "X-Mms-Expiry", Field: "Expiry", Codec: expiryCodec, Version: MMS_MESSAGE_VERSION_1_0},
+	wsp.Header{Code: FROM, Name: "From", Field: "From", Codec: fromCodec, Version: MMS_MESSAGE_VERSION_1_0},
+	//TODO implement Token text form
+	wsp.Header{Code: X_MMS_MESSAGE_CLASS, Name: "X-Mms-Message-Class", Field: "Class", Codec: wsp.Octet, Version: MMS_MESSAGE_VERSION_1_0},
+	wsp.Header{Code: MESSAGE_ID, Name: "Message-ID", Field: "MessageId", Codec: wsp.TextString, Version: MMS_MESSAGE_VERSION_1_0},
+	wsp.Header{Code: X_MMS_MESSAGE_TYPE, Name: "X-Mms-Message-Type", Field: "Type", Codec: wsp.Octet, Version: MMS_MESSAGE_VERSION_1_0},
+	// TODO This should be a ShortInteger instead, but we read it as a byte
+	// because we are not properly encoding the version either, as we are
+	// using the raw value there. To fix this we need to change the
+	// MMS_MESSAGE_VERSION_1_X constants.
+	wsp.Header{Code: X_MMS_MMS_VERSION, Name: "X-Mms-MMS-Version", Field: "Version", Codec: wsp.Octet, Version: MMS_MESSAGE_VERSION_1_0},
+	wsp.Header{Code: X_MMS_MESSAGE_SIZE, Name: "X-Mms-Message-Size", Field: "Size", Codec: wsp.LongInteger, Version: MMS_MESSAGE_VERSION_1_0},
+	wsp.Header{Code: X_MMS_PRIORITY, Name: "X-Mms-Priority", Field: "Priority", Codec: wsp.Octet, Version: MMS_MESSAGE_VERSION_1_0},
+	wsp.Header{Code: X_MMS_READ_REPORT, Name: "X-Mms-Read-Report", Field: "ReadReport", Codec: wsp.Octet, Version: MMS_MESSAGE_VERSION_1_0},
+	wsp.Header{Code: X_MMS_REPORT_ALLOWED, Name: "X-Mms-Report-Allowed", Field: "ReportAllowed", Codec: wsp.Octet, Version: MMS_MESSAGE_VERSION_1_0},
+	wsp.Header{Code: X_MMS_RESPONSE_STATUS, Name: "X-Mms-Response-Status", Field: "ResponseStatus", Codec: wsp.Octet, Version: MMS_MESSAGE_VERSION_1_0},
+	wsp.Header{Code: X_MMS_RESPONSE_TEXT, Name: "X-Mms-Response-Text", Field: "ResponseText", Codec: wsp.TextString, Version: MMS_MESSAGE_VERSION_1_0},
+	wsp.Header{Code: X_MMS_SENDER_VISIBILITY, Name: "X-Mms-Sender-Visibility", Field: "SenderVisibility", Codec: wsp.Octet, Version: MMS_MESSAGE_VERSION_1_0},
+	wsp.Header{Code: X_MMS_STATUS, Name: "X-Mms-Status", Field: "Status", Codec: wsp.Octet, Version: MMS_MESSAGE_VERSION_1_0},
+	wsp.Header{Code: SUBJECT, Name: "Subject", Field: "Subject", Codec: wsp.EncodedString, Version: MMS_MESSAGE_VERSION_1_0},
+	wsp.Header{Code: TO, Name: "To", Field: "To", Codec: wsp.EncodedString, Version: MMS_MESSAGE_VERSION_1_0},
+	wsp.Header{Code: X_MMS_TRANSACTION_ID, Name: "X-Mms-Transaction-Id", Field: "TransactionId", Codec: wsp.TextString, Version: MMS_MESSAGE_VERSION_1_0},
+	wsp.Header{Code: X_MMS_RETRIEVE_STATUS, Name: "X-Mms-Retrieve-Status", Field: "RetrieveStatus", Codec: wsp.Octet, Version: MMS_MESSAGE_VERSION_1_1},
+	wsp.Header{Code: X_MMS_RETRIEVE_TEXT, Name: "X-Mms-Retrieve-Text", Field: "RetrieveText", Codec: wsp.TextString, Version: MMS_MESSAGE_VERSION_1_1},
+	wsp.Header{Code: X_MMS_READ_STATUS, Name: "X-Mms-Read-Status", Field: "ReadStatus", Codec: wsp.Octet, Version: MMS_MESSAGE_VERSION_1_1},
+	wsp.Header{Code: X_MMS_REPLY_CHARGING, Name: "X-Mms-Reply-Charging", Field: "ReplyCharging", Codec: wsp.Octet, Version: MMS_MESSAGE_VERSION_1_1},
+	wsp.Header{Code: X_MMS_REPLY_CHARGING_DEADLINE, Name: "X-Mms-Reply-Charging-Deadline", Field: "ReplyChargingDeadline", Codec: wsp.Octet, Version: MMS_MESSAGE_VERSION_1_1},
+	wsp.Header{Code: X_MMS_REPLY_CHARGING_ID, Name: "X-Mms-Reply-Charging-ID", Field: "ReplyChargingId", Codec: wsp.TextString, Version: MMS_MESSAGE_VERSION_1_1},
+	wsp.Header{Code: X_MMS_REPLACE_ID, Name: "X-Mms-Replace-ID", Field: "ReplaceId", Codec: wsp.TextString, Version: MMS_MESSAGE_VERSION_1_3},
+	wsp.Header{Code: X_MMS_CANCEL_ID, Name: "X-Mms-Cancel-ID", Field: "CancelId", Codec: wsp.TextString, Version: MMS_MESSAGE_VERSION_1_3},
+	wsp.Header{Code: X_MMS_CANCEL_STATUS, Name: "X-Mms-Cancel-Status", Field: "CancelStatus", Codec: wsp.Octet, Version: MMS_MESSAGE_VERSION_1_3},
+)
+
+//fromCodec handles the From field as defined in OMA-WAP-MMS-ENC-v1.1
+//section 7.2.11
+//
+//From-value = Value-length (Address-present-token Encoded-string-value | Insert-address-token)
+var fromCodec = wsp.Codec{
+	Decode: func(dec *wsp.Decoder) (interface{}, error) {
+		size, err := dec.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		valStart := dec.Offset
+		token, err := dec.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		switch token {
+		case TOKEN_INSERT_ADDRESS:
+			return "", nil
+		case TOKEN_ADDRESS_PRESENT:
+			// TODO add check for /TYPE=PLMN
+			from, err := dec.ReadEncodedString()
+			if err != nil {
+				return nil, err
+			}
+			if valStart+int(size) != dec.Offset {
+				return nil, fmt.Errorf("From field length is %d but expected size is %d",
+					dec.Offset-valStart, size)
+			}
+			return from, nil
+		}
+		return nil, fmt.Errorf("Unhandled token address in from field %x", token)
+	},
+	Encode: func(enc *wsp.Encoder, v interface{}) error {
+		from := v.(string)
+		if from == "" {
+			if err := enc.WriteByte(1); err != nil {
+				return err
+			}
+			return enc.WriteByte(TOKEN_INSERT_ADDRESS)
+		}
+		// +1 for the token, +1 for the string terminator
+		if err := enc.WriteLength(uint64(len(from) + 2)); err != nil {
+			return err
+		}
+		if err := enc.WriteByte(TOKEN_ADDRESS_PRESENT); err != nil {
+			return err
+		}
+		return enc.WriteString(from)
+	},
+}
+
+//expiryCodec handles the Expiry and Delivery-Time fields as defined in
+//OMA-WAP-MMS-ENC-v1.1 section 7.2.10, values are decoded as an ExpiryValue
+//and plain integers are encoded as relative ones.
+//
+//Expiry-value = Value-length (Absolute-token Date-value | Relative-token Delta-seconds-value)
+var expiryCodec = wsp.Codec{
+	Decode: func(dec *wsp.Decoder) (interface{}, error) {
+		size, err := dec.ReadLength()
+		if err != nil {
+			return nil, err
+		}
+		endOffset := dec.Offset + int(size)
+		token, err := dec.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		val, err := dec.ReadInteger()
+		if err != nil {
+			return nil, err
+		}
+		if dec.Offset != endOffset {
+			return nil, fmt.Errorf("expiry value length is %d but expected size is %d", int(size)+dec.Offset-endOffset, size)
+		}
+		switch token {
+		case ExpiryTokenAbsolute:
+			return ExpiryValue{Absolute: true, Seconds: val}, nil
+		case ExpiryTokenRelative:
+			return ExpiryValue{Seconds: val}, nil
+		}
+		return nil, fmt.Errorf("unknown expiry token %#x", token)
+	},
+	Encode: func(enc *wsp.Encoder, v interface{}) error {
+		var expiry ExpiryValue
+		switch v := v.(type) {
+		case ExpiryValue:
+			expiry = v
+		case uint64:
+			expiry.Seconds = v
+		default:
+			return fmt.Errorf("cannot encode %v as an expiry value", v)
+		}
+		encodedLong := wsp.EncodeLong(expiry.Seconds)
+		token := ExpiryTokenRelative
+		if expiry.Absolute {
+			token = ExpiryTokenAbsolute
+		}
+
+		var b []byte
+		// +1 for the token, +1 for the len of long
+		b = append(b, byte(len(encodedLong)+2))
+		b = append(b, token)
+		b = append(b, byte(len(encodedLong)))
+		b = append(b, encodedLong...)
+
+		return enc.WriteBytes(b, len(b))
+	},
+}
@@ -27,6 +27,7 @@ import (
 	"log"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -67,6 +68,13 @@ const (
 	X_MMS_PREVIOUSLY_SENT_DATE    = 0x21
 )
 
+// MMS Field names added in OMA-TS-MMS-ENC-V1_3 section 7.4
+const (
+	X_MMS_REPLACE_ID    = 0x3D
+	X_MMS_CANCEL_ID     = 0x3E
+	X_MMS_CANCEL_STATUS = 0x3F
+)
+
 // MMS Content Type Assignments OMA-WAP-MMS section 7.3 Table 13
 const (
 	PUSH_APPLICATION_ID = 4
@@ -81,6 +89,8 @@ const (
 	TYPE_RETRIEVE_CONF    = 0x84
 	TYPE_ACKNOWLEDGE_IND  = 0x85
 	TYPE_DELIVERY_IND     = 0x86
+	TYPE_CANCEL_REQ       = 0x96
+	TYPE_CANCEL_CONF      = 0x97
 )
 
 const (
@@ -102,6 +112,14 @@ const (
 	ExpiryTokenRelative byte = 129
 )
 
+//ExpiryValue is an Expiry or Delivery-Time value, either an absolute date
+//in seconds since the epoch or a number of seconds relative to when the
+//PDU was received.
+type ExpiryValue struct {
+	Absolute bool
+	Seconds  uint64
+}
+
 // From tokens defined in OMA-WAP-MMS section 7.2.11
 const (
 	TOKEN_ADDRESS_PRESENT = 0x80
@@ -180,6 +198,16 @@ const (
 	STATUS_UNRECOGNIZED = 132
 )
 
+// Cancel Status defined in OMA-TS-MMS-ENC-V1_3 section 7.3.7
+const (
+	CancelStatusReceived  byte = 128
+	CancelStatusCorrupted byte = 129
+)
+
+// localContentLocation is the prefix used by nuntium-inject-push to serve
+// test messages
+const localContentLocation = "http://localhost:9191/mms"
+
 // MSendReq holds a m-send.req message defined in
 // OMA-WAP-MMS-ENC-v1.1 section 6.1.1
 type MSendReq struct {
@@ -221,14 +249,23 @@ type MSendConf struct {
 // OMA-WAP-MMS-ENC section 6.2
 type MNotificationInd struct {
 	MMSReader
-	UUID                                 string
-	Type, Version, Class, DeliveryReport byte
-	ReplyCharging, ReplyChargingDeadline byte
-	Priority                             byte
-	ReplyChargingId                      string
-	TransactionId, ContentLocation       string
-	From, Subject                        string
-	Expiry, Size                         uint64
+	UUID                  string `encode:"no"`
+	Type                  byte
+	TransactionId         string
+	Version               byte
+	From                  string `encode:"optional"`
+	Subject               string `encode:"optional"`
+	DeliveryReport        byte   `encode:"optional"`
+	Class                 byte
+	Priority              byte `encode:"optional"`
+	Size                  uint64
+	Expiry                ExpiryValue
+	ReplyCharging         byte   `encode:"optional"`
+	ReplyChargingDeadline byte   `encode:"optional"`
+	ReplyChargingId       string `encode:"optional"`
+	ContentLocation       string
+	cancel                chan struct{}
+	cancelOnce            sync.Once
 }
 
 // MNotificationInd holds a m-notifyresp.ind message defined in
@@ -246,19 +283,49 @@ type MNotifyRespInd struct {
 // OMA-WAP-MMS-ENC-v1.1 section 6.3
 type MRetrieveConf struct {
 	MMSReader
-	UUID                                       string
-	Type, Version, Status, Class, Priority     byte
-	ReplyCharging, ReplyChargingDeadline       byte
-	ReplyChargingId                            string
-	ReadReport, RetrieveStatus, DeliveryReport byte
-	TransactionId, MessageId, RetrieveText     string
-	From, Cc, Subject                          string
-	To                                         []string
-	ReportAllowed                              byte
-	Date                                       uint64
-	Content                                    Attachment
-	Attachments                                []Attachment
-	Data                                       []byte
+	UUID                  string `encode:"no"`
+	Type                  byte
+	TransactionId         string `encode:"optional"`
+	Version               byte
+	MessageId             string `encode:"optional"`
+	From                  string
+	To                    []string `encode:"optional"`
+	Cc                    string   `encode:"optional"`
+	Subject               string   `encode:"optional"`
+	Date                  uint64
+	Class                 byte   `encode:"optional"`
+	Priority              byte   `encode:"optional"`
+	DeliveryReport        byte   `encode:"optional"`
+	ReadReport            byte   `encode:"optional"`
+	ReportAllowed         byte   `encode:"optional"`
+	Status                byte   `encode:"optional"`
+	RetrieveStatus        byte   `encode:"optional"`
+	RetrieveText          string `encode:"optional"`
+	ReplyCharging         byte   `encode:"optional"`
+	ReplyChargingDeadline byte   `encode:"optional"`
+	ReplyChargingId       string `encode:"optional"`
+	Content               Attachment
+	Attachments           []Attachment `encode:"no"`
+	Data                  []byte       `encode:"no"`
+}
+
+// MCancelReq holds a m-cancel.req message defined in
+// OMA-TS-MMS-ENC-V1_3 section 6.13
+type MCancelReq struct {
+	MMSReader
+	UUID                    string
+	Type, Version           byte
+	TransactionId, CancelId string
+}
+
+// MCancelConf holds a m-cancel.conf message defined in
+// OMA-TS-MMS-ENC-V1_3 section 6.13
+type MCancelConf struct {
+	UUID          string `encode:"no"`
+	Type          byte
+	TransactionId string
+	Version       byte
+	CancelStatus  byte
 }
 
 type MMSReader interface{}
@@ -299,11 +366,56 @@ func NewMSendConf() *MSendConf {
 }
 
 func NewMNotificationInd() *MNotificationInd {
-	return &MNotificationInd{Type: TYPE_NOTIFICATION_IND, UUID: genUUID()}
+	return &MNotificationInd{
+		Type:   TYPE_NOTIFICATION_IND,
+		UUID:   genUUID(),
+		cancel: make(chan struct{}),
+	}
+}
+
+//RestoreMNotificationInd returns the notification for uuid referring to
+//contentLocation, to resume its retrieval from the stored state.
+func RestoreMNotificationInd(uuid, contentLocation string) *MNotificationInd {
+	mNotificationInd := NewMNotificationInd()
+	mNotificationInd.UUID = uuid
+	mNotificationInd.ContentLocation = contentLocation
+	return mNotificationInd
 }
 
 func (mNotificationInd *MNotificationInd) IsLocal() bool {
-	return strings.HasPrefix(mNotificationInd.ContentLocation, "http://localhost:9191/mms")
+	return strings.HasPrefix(mNotificationInd.ContentLocation, localContentLocation)
+}
+
+//Cancel aborts an ongoing or future DownloadContent for this notification.
+func (mNotificationInd *MNotificationInd) Cancel() {
+	if mNotificationInd.cancel == nil {
+		return
+	}
+	mNotificationInd.cancelOnce.Do(func() { close(mNotificationInd.cancel) })
+}
+
+//Expires returns when the message the notification refers to expires on the
+//MMSC given the time the notification was received, or the zero time if it
+//has no expiry.
+func (mNotificationInd *MNotificationInd) Expires(received time.Time) time.Time {
+	switch {
+	case mNotificationInd.Expiry.Seconds == 0:
+		return time.Time{}
+	case mNotificationInd.Expiry.Absolute:
+		return time.Unix(int64(mNotificationInd.Expiry.Seconds), 0)
+	default:
+		return received.Add(time.Duration(mNotificationInd.Expiry.Seconds) * time.Second)
+	}
+}
+
+//IsCanceled returns true if Cancel was called for this notification.
+func (mNotificationInd *MNotificationInd) IsCanceled() bool {
+	select {
+	case <-mNotificationInd.cancel:
+		return true
+	default:
+		return false
+	}
 }
 
 func (mNotificationInd *MNotificationInd) NewMNotifyRespInd(status byte, deliveryReport bool) *MNotifyRespInd {
@@ -336,6 +448,34 @@ func NewMRetrieveConf(uuid string) *MRetrieveConf {
 	return &MRetrieveConf{Type: TYPE_RETRIEVE_CONF, UUID: uuid}
 }
 
+func NewMCancelReq() *MCancelReq {
+	return &MCancelReq{Type: TYPE_CANCEL_REQ, UUID: genUUID()}
+}
+
+func (mCancelReq *MCancelReq) IsLocal() bool {
+	return strings.HasPrefix(mCancelReq.CancelId, localContentLocation)
+}
+
+func (mCancelReq *MCancelReq) NewMCancelConf(status byte) *MCancelConf {
+	return &MCancelConf{
+		Type:          TYPE_CANCEL_CONF,
+		UUID:          mCancelReq.UUID,
+		TransactionId: mCancelReq.TransactionId,
+		Version:       mCancelReq.Version,
+		CancelStatus:  status,
+	}
+}
+
+//GetMessageType returns the X-Mms-Message-Type of an encoded PDU without
+//decoding it, it is always the first header as defined in
+//OMA-WAP-MMS-ENC-v1.1 section 7.
+func GetMessageType(data []byte) (byte, error) {
+	if len(data) < 2 || data[0] != X_MMS_MESSAGE_TYPE|0x80 {
+		return 0, errors.New("data does not start with a message type header")
+	}
+	return data[1], nil
+}
+
 func genUUID() string {
 	var id string
 	random, err := os.Open("/dev/urandom")
@@ -35,3 +35,13 @@ func (s *MMSTestSuite) TestNewMSendReq(c *C) {
 	c.Check(mSendReq.ContentType, Equals, "application/vnd.wap.multipart.related")
 	c.Check(mSendReq.Type, Equals, byte(TYPE_SEND_REQ))
 }
+
+func (s *MMSTestSuite) TestRestoreMNotificationInd(c *C) {
+	mNotificationInd := RestoreMNotificationInd("0123abcd", "http://mmsc.example.com/mms/1")
+	c.Check(mNotificationInd.UUID, Equals, "0123abcd")
+	c.Check(mNotificationInd.ContentLocation, Equals, "http://mmsc.example.com/mms/1")
+	c.Check(mNotificationInd.Type, Equals, byte(TYPE_NOTIFICATION_IND))
+	c.Check(mNotificationInd.IsCanceled(), Equals, false)
+	mNotificationInd.Cancel()
+	c.Check(mNotificationInd.IsCanceled(), Equals, true)
+}
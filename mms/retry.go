@@ -0,0 +1,80 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of mms.
+ *
+ * mms is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * mms is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mms
+
+import (
+	"math/rand"
+	"time"
+)
+
+//RetryPolicy computes when a failed transfer is attempted again. The delay
+//starts at Initial and is multiplied by Factor after every failed attempt up
+//to Max, a random Jitter fraction of it is added or removed so transfers
+//failing together do not retry together.
+type RetryPolicy struct {
+	Initial     time.Duration
+	Max         time.Duration
+	Factor      float64
+	Jitter      float64
+	MaxAttempts int
+}
+
+//DefaultRetryPolicy retries for a bit over two hours.
+var DefaultRetryPolicy = RetryPolicy{
+	Initial:     30 * time.Second,
+	Max:         30 * time.Minute,
+	Factor:      2,
+	Jitter:      0.2,
+	MaxAttempts: 10,
+}
+
+//Delay returns the time to wait after the given number of failed attempts,
+//before applying the jitter.
+func (p RetryPolicy) Delay(attempts int) time.Duration {
+	delay := float64(p.Initial)
+	for i := 1; i < attempts && delay < float64(p.Max); i++ {
+		delay *= p.Factor
+	}
+	if p.Max > 0 && delay > float64(p.Max) {
+		return p.Max
+	}
+	return time.Duration(delay)
+}
+
+//Next returns when to attempt a transfer again after the given number of
+//failed attempts, or false if it should not be retried because there were
+//too many attempts or the retry would happen after expires. A zero expires
+//never expires.
+func (p RetryPolicy) Next(attempts int, now, expires time.Time) (time.Time, bool) {
+	if p.MaxAttempts > 0 && attempts >= p.MaxAttempts {
+		return time.Time{}, false
+	}
+	delay := p.Delay(attempts)
+	if p.Jitter > 0 {
+		delay += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(delay))
+	}
+	next := now.Add(delay)
+	if !expires.IsZero() && !next.Before(expires) {
+		return time.Time{}, false
+	}
+	return next, true
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of mms.
+ *
+ * mms is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * mms is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mms
+
+import (
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+type RetryTestSuite struct{}
+
+var _ = Suite(&RetryTestSuite{})
+
+var testRetryPolicy = RetryPolicy{
+	Initial:     10 * time.Second,
+	Max:         time.Minute,
+	Factor:      2,
+	MaxAttempts: 5,
+}
+
+func (s *RetryTestSuite) TestDelay(c *C) {
+	c.Check(testRetryPolicy.Delay(1), Equals, 10*time.Second)
+	c.Check(testRetryPolicy.Delay(2), Equals, 20*time.Second)
+	c.Check(testRetryPolicy.Delay(3), Equals, 40*time.Second)
+	c.Check(testRetryPolicy.Delay(4), Equals, time.Minute)
+	c.Check(testRetryPolicy.Delay(100), Equals, time.Minute)
+}
+
+func (s *RetryTestSuite) TestNext(c *C) {
+	now := time.Unix(1400000000, 0)
+	next, ok := testRetryPolicy.Next(2, now, time.Time{})
+	c.Assert(ok, Equals, true)
+	c.Check(next, Equals, now.Add(20*time.Second))
+}
+
+func (s *RetryTestSuite) TestNextMaxAttempts(c *C) {
+	now := time.Unix(1400000000, 0)
+	_, ok := testRetryPolicy.Next(4, now, time.Time{})
+	c.Check(ok, Equals, true)
+	_, ok = testRetryPolicy.Next(5, now, time.Time{})
+	c.Check(ok, Equals, false)
+}
+
+func (s *RetryTestSuite) TestNextExpired(c *C) {
+	now := time.Unix(1400000000, 0)
+	_, ok := testRetryPolicy.Next(1, now, now.Add(11*time.Second))
+	c.Check(ok, Equals, true)
+	_, ok = testRetryPolicy.Next(1, now, now.Add(10*time.Second))
+	c.Check(ok, Equals, false)
+	_, ok = testRetryPolicy.Next(1, now, now.Add(-time.Second))
+	c.Check(ok, Equals, false)
+}
+
+func (s *RetryTestSuite) TestNextJitter(c *C) {
+	policy := testRetryPolicy
+	policy.Jitter = 0.5
+	now := time.Unix(1400000000, 0)
+	for i := 0; i < 100; i++ {
+		next, ok := policy.Next(1, now, time.Time{})
+		c.Assert(ok, Equals, true)
+		delay := next.Sub(now)
+		c.Assert(delay >= 5*time.Second && delay <= 15*time.Second, Equals, true, Commentf("delay %s", delay))
+	}
+}
+
+func (s *RetryTestSuite) TestExpires(c *C) {
+	received := time.Unix(1400000000, 0)
+	mNotificationInd := NewMNotificationInd()
+	c.Check(mNotificationInd.Expires(received).IsZero(), Equals, true)
+
+	mNotificationInd.Expiry = ExpiryValue{Seconds: 7 * 24 * 60 * 60}
+	c.Check(mNotificationInd.Expires(received), Equals, received.Add(7*24*time.Hour))
+
+	mNotificationInd.Expiry = ExpiryValue{Absolute: true, Seconds: 1400086400}
+	c.Check(mNotificationInd.Expires(received), Equals, time.Unix(1400086400, 0))
+
+	//absolute dates are not mistaken for relative ones however small
+	mNotificationInd.Expiry = ExpiryValue{Absolute: true, Seconds: 3600}
+	c.Check(mNotificationInd.Expires(received), Equals, time.Unix(3600, 0))
+}
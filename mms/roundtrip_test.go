@@ -0,0 +1,272 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of mms.
+ *
+ * mms is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * mms is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mms
+
+import (
+	"bytes"
+	"io/ioutil"
+
+	. "launchpad.net/gocheck"
+)
+
+type RoundTripTestSuite struct{}
+
+var _ = Suite(&RoundTripTestSuite{})
+
+//operatorNotifications holds m-notification.ind bodies captured from
+//operator pushes, see ofono/push_decode_test.go
+var operatorNotifications = map[string][]byte{
+	"Vodafone Spain": []byte{
+		0x8c, 0x82, 0x98, 0x4e, 0x4f, 0x4b, 0x35, 0x43, 0x64, 0x7a, 0x30, 0x38, 0x42,
+		0x41, 0x73, 0x77, 0x61, 0x62, 0x77, 0x55, 0x48, 0x00, 0x8d, 0x90, 0x89, 0x18,
+		0x80, 0x2b, 0x33, 0x34, 0x36, 0x30, 0x30, 0x39, 0x34, 0x34, 0x34, 0x36, 0x33,
+		0x2f, 0x54, 0x59, 0x50, 0x45, 0x3d, 0x50, 0x4c, 0x4d, 0x4e, 0x00, 0x8a, 0x80,
+		0x8e, 0x02, 0x74, 0x00, 0x88, 0x05, 0x81, 0x03, 0x02, 0xa3, 0x00, 0x83, 0x68,
+		0x74, 0x74, 0x70, 0x3a, 0x2f, 0x2f, 0x6d, 0x6d, 0x31, 0x66, 0x65, 0x31, 0x2f,
+		0x73, 0x65, 0x72, 0x76, 0x6c, 0x65, 0x74, 0x73, 0x2f, 0x4e, 0x4f, 0x4b, 0x35,
+		0x43, 0x64, 0x7a, 0x30, 0x38, 0x42, 0x41, 0x73, 0x77, 0x61, 0x62, 0x77, 0x55,
+		0x48, 0x00,
+	},
+	"Telecom Personal": []byte{
+		0x8c, 0x82, 0x98, 0x6d, 0x30, 0x34, 0x42, 0x4b, 0x6b, 0x73, 0x69, 0x6d, 0x30,
+		0x35, 0x40, 0x6d, 0x6d, 0x73, 0x2e, 0x70, 0x65, 0x72, 0x73, 0x6f, 0x6e, 0x61,
+		0x6c, 0x2e, 0x63, 0x6f, 0x6d, 0x2e, 0x61, 0x72, 0x00, 0x8d, 0x90, 0x89, 0x19,
+		0x80, 0x2b, 0x35, 0x34, 0x33, 0x35, 0x31, 0x35, 0x39, 0x32, 0x34, 0x39, 0x30,
+		0x36, 0x2f, 0x54, 0x59, 0x50, 0x45, 0x3d, 0x50, 0x4c, 0x4d, 0x4e, 0x00, 0x8a,
+		0x80, 0x8e, 0x02, 0x74, 0x00, 0x88, 0x05, 0x81, 0x03, 0x02, 0xa2, 0xff, 0x83,
+		0x68, 0x74, 0x74, 0x70, 0x3a, 0x2f, 0x2f, 0x31, 0x37, 0x32, 0x2e, 0x32, 0x35,
+		0x2e, 0x37, 0x2e, 0x31, 0x33, 0x31, 0x2f, 0x3f, 0x6d, 0x65, 0x73, 0x73, 0x61,
+		0x67, 0x65, 0x2d, 0x69, 0x64, 0x3d, 0x6d, 0x30, 0x34, 0x42, 0x4b, 0x68, 0x34,
+		0x33, 0x65, 0x30, 0x33, 0x00,
+	},
+	"T-Mobile USA": []byte{
+		0x8c, 0x82, 0x98, 0x6d, 0x61, 0x76, 0x6f, 0x64, 0x69, 0x2d, 0x37, 0x2d, 0x38,
+		0x39, 0x2d, 0x31, 0x63, 0x30, 0x2d, 0x37, 0x2d, 0x63, 0x61, 0x2d, 0x35, 0x30,
+		0x66, 0x39, 0x33, 0x38, 0x34, 0x33, 0x2d, 0x37, 0x2d, 0x31, 0x33, 0x62, 0x2d,
+		0x32, 0x65, 0x62, 0x2d, 0x31, 0x2d, 0x63, 0x61, 0x2d, 0x33, 0x36, 0x31, 0x65,
+		0x33, 0x31, 0x35, 0x00, 0x8d, 0x92, 0x89, 0x1a, 0x80, 0x18, 0x83, 0x2b, 0x31,
+		0x39, 0x31, 0x39, 0x39, 0x30, 0x33, 0x33, 0x34, 0x38, 0x38, 0x2f, 0x54, 0x59,
+		0x50, 0x45, 0x3d, 0x50, 0x4c, 0x4d, 0x4e, 0x00, 0x8a, 0x80, 0x8e, 0x03, 0x0f,
+		0x21, 0x9f, 0x88, 0x05, 0x81, 0x03, 0x03, 0xf4, 0x80, 0x83, 0x68, 0x74, 0x74,
+		0x70, 0x3a, 0x2f, 0x2f, 0x61, 0x74, 0x6c, 0x32, 0x6d, 0x6f, 0x73, 0x67, 0x65,
+		0x74, 0x2e, 0x6d, 0x73, 0x67, 0x2e, 0x65, 0x6e, 0x67, 0x2e, 0x74, 0x2d, 0x6d,
+		0x6f, 0x62, 0x69, 0x6c, 0x65, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6d, 0x6d, 0x73,
+		0x2f, 0x77, 0x61, 0x70, 0x65, 0x6e, 0x63, 0x3f, 0x54, 0x3d, 0x6d, 0x61, 0x76,
+		0x6f, 0x64, 0x69, 0x2d, 0x37, 0x2d, 0x31, 0x33, 0x62, 0x2d, 0x32, 0x65, 0x62,
+		0x2d, 0x31, 0x2d, 0x63, 0x61, 0x2d, 0x33, 0x36, 0x31, 0x65, 0x33, 0x31, 0x35,
+		0x00,
+	},
+	"Play Poland": []byte{
+		0x8c, 0x82, 0x98, 0x31, 0x34, 0x34, 0x32, 0x34, 0x30, 0x31, 0x33, 0x31, 0x38,
+		0x40, 0x6d, 0x6d, 0x73, 0x32, 0x00, 0x8d, 0x92, 0x89, 0x18, 0x80, 0x2b, 0x34,
+		0x38, 0x38, 0x38, 0x32, 0x30, 0x34, 0x30, 0x32, 0x32, 0x35, 0x2f, 0x54, 0x59,
+		0x50, 0x45, 0x3d, 0x50, 0x4c, 0x4d, 0x4e, 0x00, 0x8f, 0x81, 0x86, 0x80, 0x8a,
+		0x80, 0x8e, 0x03, 0x03, 0xad, 0x21, 0x88, 0x05, 0x81, 0x03, 0x03, 0xf4, 0x80,
+		0x83, 0x68, 0x74, 0x74, 0x70, 0x3a, 0x2f, 0x2f, 0x6d, 0x6d, 0x73, 0x63, 0x2e,
+		0x70, 0x6c, 0x61, 0x79, 0x2e, 0x70, 0x6c, 0x2f, 0x3f, 0x69, 0x64, 0x3d, 0x31,
+		0x34, 0x34, 0x32, 0x34, 0x30, 0x31, 0x33, 0x31, 0x38, 0x42, 0x00,
+	},
+}
+
+func encodePDU(c *C, pdu MMSWriter) []byte {
+	var outBytes bytes.Buffer
+	enc := NewEncoder(&outBytes)
+	c.Assert(enc.Encode(pdu), IsNil)
+	return outBytes.Bytes()
+}
+
+//clearOffsets zeroes the members the decoder fills in with positions and
+//lengths from the payload it read.
+func clearOffsets(mRetrieveConf *MRetrieveConf) {
+	mRetrieveConf.Content.Length = 0
+	for i := range mRetrieveConf.Attachments {
+		mRetrieveConf.Attachments[i].Offset = 0
+		mRetrieveConf.Attachments[i].Length = 0
+	}
+}
+
+func (s *RoundTripTestSuite) TestEncodeMNotificationInd(c *C) {
+	mNotificationInd := &MNotificationInd{
+		Type:            TYPE_NOTIFICATION_IND,
+		TransactionId:   "0123456",
+		Version:         MMS_MESSAGE_VERSION_1_3,
+		From:            "+5491122334455/TYPE=PLMN",
+		Subject:         "Hello",
+		Class:           ClassPersonal,
+		Size:            29696,
+		Expiry:          ExpiryValue{Seconds: 172800},
+		ContentLocation: "http://localhost:9191/mms",
+	}
+	expectedBytes := []byte{
+		//Message Type m-notification.ind
+		0x8c, 0x82,
+		//Transaction Id
+		0x98, 0x30, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x00,
+		//MMS Version 1.3
+		0x8d, 0x93,
+		//From, address present
+		0x89, 0x1a, 0x80, 0x2b, 0x35, 0x34, 0x39, 0x31, 0x31, 0x32, 0x32, 0x33, 0x33,
+		0x34, 0x34, 0x35, 0x35, 0x2f, 0x54, 0x59, 0x50, 0x45, 0x3d, 0x50, 0x4c, 0x4d,
+		0x4e, 0x00,
+		//Subject
+		0x96, 0x48, 0x65, 0x6c, 0x6c, 0x6f, 0x00,
+		//Message Class personal
+		0x8a, 0x80,
+		//Message Size
+		0x8e, 0x02, 0x74, 0x00,
+		//Expiry, relative
+		0x88, 0x05, 0x81, 0x03, 0x02, 0xa3, 0x00,
+		//Content Location
+		0x83, 0x68, 0x74, 0x74, 0x70, 0x3a, 0x2f, 0x2f, 0x6c, 0x6f, 0x63, 0x61, 0x6c,
+		0x68, 0x6f, 0x73, 0x74, 0x3a, 0x39, 0x31, 0x39, 0x31, 0x2f, 0x6d, 0x6d, 0x73,
+		0x00,
+	}
+	outBytes := encodePDU(c, mNotificationInd)
+	c.Check(outBytes, DeepEquals, expectedBytes)
+
+	decoded := &MNotificationInd{Type: TYPE_NOTIFICATION_IND}
+	c.Assert(NewDecoder(outBytes).Decode(decoded), IsNil)
+	c.Check(decoded, DeepEquals, mNotificationInd)
+}
+
+func (s *RoundTripTestSuite) TestEncodeMNotificationIndWithoutOptionalFields(c *C) {
+	mNotificationInd := &MNotificationInd{
+		Type:            TYPE_NOTIFICATION_IND,
+		TransactionId:   "1",
+		Version:         MMS_MESSAGE_VERSION_1_0,
+		Class:           ClassAuto,
+		Size:            1,
+		Expiry:          ExpiryValue{Seconds: 1},
+		ContentLocation: "http://mmsc/1",
+	}
+	outBytes := encodePDU(c, mNotificationInd)
+	decoded := &MNotificationInd{Type: TYPE_NOTIFICATION_IND}
+	c.Assert(NewDecoder(outBytes).Decode(decoded), IsNil)
+	c.Check(decoded, DeepEquals, mNotificationInd)
+}
+
+func (s *RoundTripTestSuite) TestEncodeMNotificationIndAbsoluteExpiry(c *C) {
+	mNotificationInd := &MNotificationInd{
+		Type:            TYPE_NOTIFICATION_IND,
+		TransactionId:   "1",
+		Version:         MMS_MESSAGE_VERSION_1_0,
+		Class:           ClassAuto,
+		Size:            1,
+		Expiry:          ExpiryValue{Absolute: true, Seconds: 1400086400},
+		ContentLocation: "http://mmsc/1",
+	}
+	outBytes := encodePDU(c, mNotificationInd)
+	//Expiry, absolute
+	c.Check(bytes.Contains(outBytes, []byte{0x88, 0x06, 0x80, 0x04, 0x53, 0x73, 0x9f, 0x80}), Equals, true)
+	decoded := &MNotificationInd{Type: TYPE_NOTIFICATION_IND}
+	c.Assert(NewDecoder(outBytes).Decode(decoded), IsNil)
+	c.Check(decoded, DeepEquals, mNotificationInd)
+}
+
+func (s *RoundTripTestSuite) TestOperatorMNotificationInd(c *C) {
+	for operator, inputBytes := range operatorNotifications {
+		c.Log("Round tripping ", operator)
+		mNotificationInd := &MNotificationInd{Type: TYPE_NOTIFICATION_IND}
+		c.Assert(NewDecoder(inputBytes).Decode(mNotificationInd), IsNil)
+
+		decoded := &MNotificationInd{Type: TYPE_NOTIFICATION_IND}
+		c.Assert(NewDecoder(encodePDU(c, mNotificationInd)).Decode(decoded), IsNil)
+		c.Check(decoded, DeepEquals, mNotificationInd)
+	}
+}
+
+func (s *RoundTripTestSuite) TestOperatorMNotificationIndBytes(c *C) {
+	//these operators send the headers in the order the encoder writes them
+	for _, operator := range []string{"Vodafone Spain", "Telecom Personal"} {
+		mNotificationInd := &MNotificationInd{Type: TYPE_NOTIFICATION_IND}
+		c.Assert(NewDecoder(operatorNotifications[operator]).Decode(mNotificationInd), IsNil)
+		c.Check(encodePDU(c, mNotificationInd), DeepEquals, operatorNotifications[operator])
+	}
+}
+
+func (s *RoundTripTestSuite) TestEncodeMRetrieveConfTextPlain(c *C) {
+	mRetrieveConf := &MRetrieveConf{
+		Type:          TYPE_RETRIEVE_CONF,
+		TransactionId: "0123456",
+		Version:       MMS_MESSAGE_VERSION_1_1,
+		MessageId:     "m1",
+		From:          "+12345/TYPE=PLMN",
+		To:            []string{"+54321/TYPE=PLMN"},
+		Subject:       "Hi",
+		Date:          1400000000,
+		Class:         ClassPersonal,
+		Content:       Attachment{MediaType: "text/plain", Charset: "utf-8"},
+		Data:          []byte("Hello world"),
+	}
+	decoded := NewMRetrieveConf("")
+	c.Assert(NewDecoder(encodePDU(c, mRetrieveConf)).Decode(decoded), IsNil)
+	clearOffsets(decoded)
+	c.Check(decoded, DeepEquals, mRetrieveConf)
+}
+
+func (s *RoundTripTestSuite) TestEncodeMRetrieveConfMultipart(c *C) {
+	mRetrieveConf := &MRetrieveConf{
+		Type:    TYPE_RETRIEVE_CONF,
+		Version: MMS_MESSAGE_VERSION_1_3,
+		From:    "+12345/TYPE=PLMN",
+		Date:    1400000000,
+		Content: Attachment{
+			MediaType: "application/vnd.wap.multipart.related",
+			Start:     "<smil>",
+			Type:      "application/smil",
+		},
+		Attachments: []Attachment{
+			Attachment{
+				MediaType:       "application/smil",
+				ContentId:       "<smil>",
+				ContentLocation: "smil.xml",
+				Data:            []byte("<smil><body><par><text src=\"text0.txt\"/></par></body></smil>"),
+			},
+			Attachment{
+				MediaType:       "text/plain;charset=utf-8",
+				Charset:         "utf-8",
+				Name:            "text0.txt",
+				ContentId:       "<text0>",
+				ContentLocation: "text0.txt",
+				Data:            []byte("Hello world"),
+			},
+		},
+	}
+	decoded := NewMRetrieveConf("")
+	c.Assert(NewDecoder(encodePDU(c, mRetrieveConf)).Decode(decoded), IsNil)
+	clearOffsets(decoded)
+	c.Check(decoded, DeepEquals, mRetrieveConf)
+}
+
+func (s *RoundTripTestSuite) TestMRetrieveConfPayload(c *C) {
+	inputBytes, err := ioutil.ReadFile("test_payloads/m-retrieve.conf_success")
+	c.Assert(err, IsNil)
+
+	mRetrieveConf := NewMRetrieveConf("")
+	c.Assert(NewDecoder(inputBytes).Decode(mRetrieveConf), IsNil)
+
+	decoded := NewMRetrieveConf("")
+	c.Assert(NewDecoder(encodePDU(c, mRetrieveConf)).Decode(decoded), IsNil)
+	clearOffsets(mRetrieveConf)
+	clearOffsets(decoded)
+	c.Check(decoded, DeepEquals, mRetrieveConf)
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of mms.
+ *
+ * mms is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * mms is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mms
+
+import (
+	"encoding/xml"
+	"io"
+	"log"
+	"strings"
+	"unicode/utf8"
+)
+
+//Text returns the text/plain parts of the message converted to UTF-8 and
+//joined in the order the SMIL presentation references them, parts the SMIL
+//does not reference follow in message order. fallback is set if any part
+//could not be converted from its declared charset and was read as UTF-8
+//or ISO-8859-1 instead.
+func (pdu *MRetrieveConf) Text() (text string, fallback bool) {
+	//single part messages carry the text as the message body
+	if strings.EqualFold(pdu.Content.MediaType, "text/plain") {
+		t, ok := decodeTextFallback(pdu.Data, pdu.Content.Charset)
+		return t, !ok
+	}
+	var texts []string
+	for _, part := range pdu.orderedParts() {
+		mediaType, _ := splitCharset(part.MediaType)
+		if !strings.EqualFold(mediaType, "text/plain") {
+			continue
+		}
+		t, ok := decodeTextFallback(part.Data, part.Charset)
+		if !ok {
+			log.Printf("Cannot decode text part %s as %q, falling back", part.ContentId, part.Charset)
+			fallback = true
+		}
+		texts = append(texts, t)
+	}
+	return strings.Join(texts, "\n"), fallback
+}
+
+//decodeTextFallback converts data from charset to UTF-8 reading it as UTF-8
+//or ISO-8859-1 if that fails, it returns false if a fallback was used.
+func decodeTextFallback(data []byte, charset string) (string, bool) {
+	if t, err := decodeText(data, charset); err == nil {
+		return t, true
+	}
+	if utf8.Valid(data) {
+		return string(data), false
+	}
+	t, _ := decodeText(data, "iso-8859-1")
+	return t, false
+}
+
+//orderedParts returns the data parts in the order they are referenced from
+//the SMIL part.
+func (pdu *MRetrieveConf) orderedParts() []Attachment {
+	parts := pdu.GetDataParts()
+	smil, err := pdu.GetSmil()
+	if err != nil {
+		return parts
+	}
+	refs, err := smilSources(smil)
+	if err != nil {
+		log.Print("Cannot parse SMIL, using message order: ", err)
+		return parts
+	}
+
+	var ordered []Attachment
+	used := make([]bool, len(parts))
+	for _, ref := range refs {
+		for i := range parts {
+			if !used[i] && parts[i].isReferencedBy(ref) {
+				ordered = append(ordered, parts[i])
+				used[i] = true
+				break
+			}
+		}
+	}
+	for i := range parts {
+		if !used[i] {
+			ordered = append(ordered, parts[i])
+		}
+	}
+	return ordered
+}
+
+//isReferencedBy returns true if src, from a SMIL media element, refers to
+//the part by its Content-Location or by its Content-ID with a cid: URL.
+func (a *Attachment) isReferencedBy(src string) bool {
+	if strings.HasPrefix(strings.ToLower(src), "cid:") {
+		return strings.Trim(a.ContentId, "<>") == src[len("cid:"):]
+	}
+	return src == a.ContentLocation || src == strings.Trim(a.ContentId, "<>")
+}
+
+//smilSources returns the src attributes of the SMIL elements in document
+//order.
+func smilSources(smil string) ([]string, error) {
+	var refs []string
+	dec := xml.NewDecoder(strings.NewReader(smil))
+	dec.Strict = false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return refs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			for _, attr := range start.Attr {
+				if attr.Name.Local == "src" {
+					refs = append(refs, attr.Value)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of mms.
+ *
+ * mms is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * mms is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mms
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+type TextTestSuite struct{}
+
+var _ = Suite(&TextTestSuite{})
+
+const testSmil = `<smil><head><layout/></head><body>` +
+	`<par dur="5s"><img src="image.jpg"/><text src="cid:second"/></par>` +
+	`<par dur="5s"><text src="first.txt"/></par>` +
+	`</body></smil>`
+
+func (s *TextTestSuite) TestTextSmilOrder(c *C) {
+	pdu := &MRetrieveConf{
+		Attachments: []Attachment{
+			Attachment{MediaType: "application/smil", Data: []byte(testSmil)},
+			Attachment{MediaType: "text/plain;charset=utf-8", Charset: "utf-8", ContentLocation: "first.txt", Data: []byte("last")},
+			Attachment{MediaType: "image/jpeg", ContentLocation: "image.jpg", Data: []byte{0xff, 0xd8}},
+			Attachment{MediaType: "text/plain;charset=iso-8859-1", Charset: "iso-8859-1", ContentId: "<second>", Data: []byte("caf\xe9")},
+			Attachment{MediaType: "text/plain", ContentLocation: "unreferenced.txt", Data: []byte("trailer")},
+		},
+	}
+	text, fallback := pdu.Text()
+	c.Check(text, Equals, "café\nlast\ntrailer")
+	c.Check(fallback, Equals, false)
+}
+
+func (s *TextTestSuite) TestTextWithoutSmil(c *C) {
+	pdu := &MRetrieveConf{
+		Attachments: []Attachment{
+			Attachment{MediaType: "text/plain", Data: []byte("one")},
+			Attachment{MediaType: "text/plain", Data: []byte("two")},
+		},
+	}
+	text, _ := pdu.Text()
+	c.Check(text, Equals, "one\ntwo")
+}
+
+func (s *TextTestSuite) TestTextUTF16(c *C) {
+	pdu := &MRetrieveConf{
+		Attachments: []Attachment{
+			Attachment{MediaType: "text/plain;charset=utf-16", Charset: "utf-16", Data: []byte{0xff, 0xfe, 0x48, 0x00, 0x69, 0x00}},
+		},
+	}
+	text, fallback := pdu.Text()
+	c.Check(text, Equals, "Hi")
+	c.Check(fallback, Equals, false)
+}
+
+func (s *TextTestSuite) TestTextCharsetFallback(c *C) {
+	pdu := &MRetrieveConf{
+		Attachments: []Attachment{
+			//declared as UTF-8 but ISO-8859-1 on the wire
+			Attachment{MediaType: "text/plain;charset=utf-8", Charset: "utf-8", Data: []byte("ni\xf1o")},
+			//unsupported charset with valid UTF-8
+			Attachment{MediaType: "text/plain;charset=big5", Charset: "big5", Data: []byte("ok")},
+		},
+	}
+	text, fallback := pdu.Text()
+	c.Check(text, Equals, "niño\nok")
+	c.Check(fallback, Equals, true)
+}
+
+func (s *TextTestSuite) TestTextSinglePart(c *C) {
+	pdu := &MRetrieveConf{
+		Content: Attachment{MediaType: "text/plain", Charset: "iso-8859-1"},
+		Data:    []byte("se\xf1al"),
+	}
+	text, fallback := pdu.Text()
+	c.Check(text, Equals, "señal")
+	c.Check(fallback, Equals, false)
+}
@@ -0,0 +1,139 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of mms.
+ *
+ * mms is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * mms is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mms
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+)
+
+//TLSOptions configure the connections to MMSCs served over HTTPS. CAFile
+//is a PEM bundle of certificates trusted besides the system ones, Pins are
+//base64 encoded SHA-256 hashes of subject public key infos one of which the
+//verified chain must hold when set and MinVersion is the lowest TLS version
+//accepted, such as tls.VersionTLS12.
+type TLSOptions struct {
+	CAFile     string
+	Pins       []string
+	MinVersion uint16
+}
+
+//CertificateError is returned when the certificate of the MMSC cannot be
+//trusted, retrying the transfer does not help.
+type CertificateError struct {
+	Err error
+}
+
+func (e *CertificateError) Error() string {
+	return fmt.Sprintf("untrusted MMSC certificate: %s", e.Err)
+}
+
+var errPinMismatch = errors.New("no certificate in the chain matches the configured pins")
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+//ParseTLSVersion returns the TLS version for a string such as "1.2".
+func ParseTLSVersion(version string) (uint16, error) {
+	if v, ok := tlsVersions[version]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %s", version)
+}
+
+//newTLSConfig returns the TLS client configuration for options, nil when
+//the defaults apply.
+func newTLSConfig(options TLSOptions) (*tls.Config, error) {
+	if options.CAFile == "" && len(options.Pins) == 0 && options.MinVersion == 0 {
+		return nil, nil
+	}
+	config := &tls.Config{MinVersion: options.MinVersion}
+	if options.CAFile != "" {
+		pem, err := ioutil.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", options.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	if len(options.Pins) > 0 {
+		pins := make(map[string]bool)
+		for _, pin := range options.Pins {
+			pins[pin] = true
+		}
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, chain := range cs.VerifiedChains {
+				for _, cert := range chain {
+					sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+					if pins[base64.StdEncoding.EncodeToString(sum[:])] {
+						return nil
+					}
+				}
+			}
+			return errPinMismatch
+		}
+	}
+	return config, nil
+}
+
+//certificateError returns err as a CertificateError if it was caused by
+//the verification of the server certificate. The causes are unwrapped by
+//hand as errors.As is not available with every supported toolchain.
+func certificateError(err error) error {
+	cause := err
+	for cause != nil {
+		switch e := cause.(type) {
+		case x509.UnknownAuthorityError, x509.HostnameError, x509.CertificateInvalidError:
+			return &CertificateError{Err: e}
+		case *url.Error:
+			cause = e.Err
+			continue
+		}
+		if cause == errPinMismatch {
+			return &CertificateError{Err: errPinMismatch}
+		}
+		wrapper, ok := cause.(interface {
+			Unwrap() error
+		})
+		if !ok {
+			break
+		}
+		cause = wrapper.Unwrap()
+	}
+	return err
+}
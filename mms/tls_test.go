@@ -0,0 +1,128 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of mms.
+ *
+ * mms is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * mms is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mms
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+
+	. "launchpad.net/gocheck"
+)
+
+type TLSTestSuite struct {
+	dir       string
+	transport *HTTPTransport
+	server    *httptest.Server
+}
+
+var _ = Suite(&TLSTestSuite{})
+
+func (s *TLSTestSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+	s.transport = &HTTPTransport{Dir: s.dir}
+	s.server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secure"))
+	}))
+	//handshakes the client rejects are logged by the server otherwise
+	s.server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	s.server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	s.server.StartTLS()
+}
+
+func (s *TLSTestSuite) TearDownTest(c *C) {
+	s.server.Close()
+}
+
+//caFile writes the certificate of the test server as a PEM bundle.
+func (s *TLSTestSuite) caFile(c *C) string {
+	file := filepath.Join(s.dir, "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.server.Certificate().Raw})
+	c.Assert(ioutil.WriteFile(file, data, 0644), IsNil)
+	return file
+}
+
+func (s *TLSTestSuite) pin() string {
+	sum := sha256.Sum256(s.server.Certificate().RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func (s *TLSTestSuite) download(c *C, options TLSOptions) error {
+	return s.transport.Download(s.server.URL, filepath.Join(s.dir, "download"), TransferOptions{TLS: options}, nil, nil)
+}
+
+func (s *TLSTestSuite) TestUntrustedCertificate(c *C) {
+	err := s.download(c, TLSOptions{})
+	c.Assert(err, FitsTypeOf, &CertificateError{})
+}
+
+func (s *TLSTestSuite) TestTrustedCA(c *C) {
+	c.Assert(s.download(c, TLSOptions{CAFile: s.caFile(c)}), IsNil)
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, "download"))
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, "secure")
+}
+
+func (s *TLSTestSuite) TestInvalidCAFile(c *C) {
+	file := filepath.Join(s.dir, "ca.pem")
+	c.Assert(ioutil.WriteFile(file, []byte("not a certificate"), 0644), IsNil)
+	err := s.download(c, TLSOptions{CAFile: file})
+	c.Check(err, ErrorMatches, "no certificates found in .*")
+}
+
+func (s *TLSTestSuite) TestPinMatches(c *C) {
+	c.Check(s.download(c, TLSOptions{CAFile: s.caFile(c), Pins: []string{"bm90IHRoaXMgb25l", s.pin()}}), IsNil)
+}
+
+func (s *TLSTestSuite) TestPinMismatch(c *C) {
+	err := s.download(c, TLSOptions{CAFile: s.caFile(c), Pins: []string{"bm90IHRoaXMgb25l"}})
+	c.Assert(err, FitsTypeOf, &CertificateError{})
+	c.Check(err.(*CertificateError).Err, Equals, errPinMismatch)
+}
+
+func (s *TLSTestSuite) TestUploadUntrustedCertificate(c *C) {
+	file := filepath.Join(s.dir, "m-send.req")
+	c.Assert(ioutil.WriteFile(file, []byte{0x8c, 0x80}, 0644), IsNil)
+	_, err := s.transport.Upload(file, s.server.URL, TransferOptions{}, nil, nil)
+	c.Check(err, FitsTypeOf, &CertificateError{})
+}
+
+func (s *TLSTestSuite) TestMinVersion(c *C) {
+	err := s.download(c, TLSOptions{CAFile: s.caFile(c), MinVersion: tls.VersionTLS13})
+	c.Assert(err, NotNil)
+	_, ok := err.(*CertificateError)
+	c.Check(ok, Equals, false)
+}
+
+func (s *TLSTestSuite) TestParseTLSVersion(c *C) {
+	v, err := ParseTLSVersion("1.2")
+	c.Check(err, IsNil)
+	c.Check(v, Equals, uint16(tls.VersionTLS12))
+	_, err = ParseTLSVersion("2.0")
+	c.Check(err, ErrorMatches, "unknown TLS version 2.0")
+}
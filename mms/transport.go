@@ -0,0 +1,117 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of mms.
+ *
+ * mms is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * mms is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mms
+
+import "time"
+
+//Proxy is the HTTP proxy of the MMS context, an empty Host means no proxy.
+//Host may be an IPv6 literal without brackets, Username and Password are
+//sent with basic authentication when set.
+type Proxy struct {
+	Host     string
+	Port     uint64
+	Username string
+	Password string
+}
+
+//Progress is called as a transfer advances with the bytes transferred so
+//far and the total, which is -1 if unknown. It may be nil.
+type Progress func(transferred, total int64)
+
+//Timeouts bound a transfer, Deadline is the time the whole transfer may
+//take and Inactivity the time it may go without sending or receiving any
+//data. Zero values disable them.
+type Timeouts struct {
+	Deadline   time.Duration
+	Inactivity time.Duration
+}
+
+var (
+	DefaultDownloadTimeouts = Timeouts{Deadline: 3 * time.Minute, Inactivity: time.Minute}
+	DefaultUploadTimeouts   = Timeouts{Deadline: 10 * time.Minute, Inactivity: time.Minute}
+)
+
+//technologyFactors scales the default timeouts for the slower access
+//technologies reported by oFono's NetworkRegistration, where "gsm" stands
+//for GPRS.
+var technologyFactors = map[string]float64{
+	"gsm":  4,
+	"edge": 2,
+}
+
+//Scale returns the timeouts scaled for the access technology, they are
+//unchanged for unknown or fast technologies.
+func (t Timeouts) Scale(technology string) Timeouts {
+	factor, ok := technologyFactors[technology]
+	if !ok {
+		return t
+	}
+	return Timeouts{
+		Deadline:   time.Duration(float64(t.Deadline) * factor),
+		Inactivity: time.Duration(float64(t.Inactivity) * factor),
+	}
+}
+
+//DefaultUserAgent and DefaultUAProf are sent as the User-Agent and
+//x-wap-profile headers unless configured otherwise, they are the ones of the
+//Android MMS stack which most MMSCs recognise.
+const (
+	DefaultUserAgent = "Android-Mms/2.0"
+	DefaultUAProf    = "http://www.google.com/oha/rdf/ua-profile-kila.xml"
+)
+
+//TransferOptions are the settings a transfer with the MMSC is made with.
+//Interface and Nameservers are the network interface and DNS servers of the
+//MMS context, the system defaults are used when empty.
+//
+//UserAgent and UAProf are sent as the User-Agent and x-wap-profile headers,
+//DefaultUserAgent and DefaultUAProf are used when empty. Headers are added
+//after them and may override them, an empty value removing the header.
+type TransferOptions struct {
+	Proxy       Proxy
+	Timeouts    Timeouts
+	Interface   string
+	Nameservers []string
+	UserAgent   string
+	UAProf      string
+	Headers     map[string]string
+	TLS         TLSOptions
+}
+
+//Transport transfers PDUs between the device and the MMSC.
+type Transport interface {
+	//Download retrieves url into file, resuming from the data already in
+	//it when the server allows it. It returns ErrCanceled if cancel is
+	//closed before it is done, file is kept on errors so the download can be
+	//resumed.
+	Download(url, file string, options TransferOptions, cancel <-chan struct{}, progress Progress) error
+	//Upload posts the PDU held in file to msc and returns the path of the
+	//file holding the response. It returns ErrCanceled if cancel is closed
+	//before it is done.
+	Upload(file, msc string, options TransferOptions, cancel <-chan struct{}, progress Progress) (string, error)
+}
+
+//NewTransport returns the transport to use writing its files in dir, the
+//net/http one unless built with the udm build tag.
+var NewTransport = func(dir string) Transport {
+	return &HTTPTransport{Dir: dir}
+}
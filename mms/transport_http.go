@@ -0,0 +1,390 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of mms.
+ *
+ * mms is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * mms is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//HTTPTransport transfers PDUs with net/http.
+type HTTPTransport struct {
+	//Dir is where downloaded content and upload responses are written, the
+	//default directory for temporary files is used if empty
+	Dir string
+}
+
+func (t *HTTPTransport) Download(contentLocation, file string, options TransferOptions, cancel <-chan struct{}, progress Progress) error {
+	var offset int64
+	if fi, err := os.Stat(file); err == nil {
+		offset = fi.Size()
+	}
+	req, err := http.NewRequest("GET", contentLocation, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", VND_WAP_MMS_MESSAGE+", */*")
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		log.Print("Resuming download of ", contentLocation, " from byte ", offset, " with proxy ", options.Proxy.Host, ":", options.Proxy.Port)
+	} else {
+		log.Print("Starting download of ", contentLocation, " with proxy ", options.Proxy.Host, ":", options.Proxy.Port)
+	}
+	err = t.do(req, options, cancel, func(resp *http.Response) error {
+		if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0 {
+			return errRangeNotSatisfiable
+		}
+		if err := checkStatus(resp); err != nil {
+			return err
+		}
+		return receive(file, offset, resp, progress)
+	})
+	if err == errRangeNotSatisfiable {
+		// the partial content does not match what the server has anymore
+		log.Print("Cannot resume download of ", contentLocation, ", starting over")
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+		return t.Download(contentLocation, file, options, cancel, progress)
+	}
+	return err
+}
+
+func (t *HTTPTransport) Upload(file, msc string, options TransferOptions, cancel <-chan struct{}, progress Progress) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	body := &progressReader{r: f, size: fi.Size(), progress: progress}
+	req, err := http.NewRequest("POST", msc, body)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = fi.Size()
+	req.Header.Set("Content-Type", VND_WAP_MMS_MESSAGE)
+	req.Header.Set("Accept", VND_WAP_MMS_MESSAGE+", */*")
+	log.Print("Starting upload of ", file, " to ", msc, " with proxy ", options.Proxy.Host, ":", options.Proxy.Port)
+	var responseFile string
+	err = t.do(req, options, cancel, func(resp *http.Response) error {
+		if err := checkStatus(resp); err != nil {
+			return err
+		}
+		f, err := ioutil.TempFile(t.Dir, "response")
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(f, resp.Body)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(f.Name())
+			return err
+		}
+		log.Printf("Wrote %d bytes from %s to %s", n, req.URL, f.Name())
+		responseFile = f.Name()
+		return nil
+	})
+	return responseFile, err
+}
+
+//do sends req and passes the response to handle, the transfer is aborted
+//when cancel is closed or when it exceeds the timeouts in options. A
+//CertificateError is returned if the MMSC certificate cannot be trusted.
+func (t *HTTPTransport) do(req *http.Request, options TransferOptions, cancel <-chan struct{}, handle func(*http.Response) error) error {
+	tlsConfig, err := newTLSConfig(options.TLS)
+	if err != nil {
+		return err
+	}
+	transport := &http.Transport{
+		Proxy:           proxyURL(options.Proxy),
+		DialContext:     newDialer(options).DialContext,
+		TLSClientConfig: tlsConfig,
+	}
+	//connections are bound to the context, which is deactivated once done
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+
+	ctx, stop := context.WithCancel(context.Background())
+	w := newWatchdog(stop, options.Timeouts, cancel)
+	defer w.close()
+
+	if req.Body != nil {
+		req.Body = &watchedReader{ReadCloser: req.Body, w: w}
+	}
+	setHeaders(req, options)
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return w.errOr(certificateError(err))
+	}
+	defer resp.Body.Close()
+	w.kick()
+	resp.Body = &watchedReader{ReadCloser: resp.Body, w: w}
+	if err := handle(resp); err != nil {
+		return w.errOr(err)
+	}
+	return nil
+}
+
+//watchdog aborts a transfer when it is canceled, when it exceeds its
+//deadline or when it goes without data for longer than the inactivity
+//timeout, keeping the reason.
+type watchdog struct {
+	stop       context.CancelFunc
+	inactivity time.Duration
+	deadline   *time.Timer
+	idle       *time.Timer
+	done       chan struct{}
+	lock       sync.Mutex
+	err        error
+}
+
+func newWatchdog(stop context.CancelFunc, timeouts Timeouts, cancel <-chan struct{}) *watchdog {
+	w := &watchdog{
+		stop:       stop,
+		inactivity: timeouts.Inactivity,
+		done:       make(chan struct{}),
+	}
+	if timeouts.Deadline > 0 {
+		w.deadline = time.AfterFunc(timeouts.Deadline, func() { w.abort(ErrDeadlineExceeded) })
+	}
+	if timeouts.Inactivity > 0 {
+		w.idle = time.AfterFunc(timeouts.Inactivity, func() { w.abort(ErrInactive) })
+	}
+	go func() {
+		select {
+		case <-cancel:
+			w.abort(ErrCanceled)
+		case <-w.done:
+		}
+	}()
+	return w
+}
+
+func (w *watchdog) abort(err error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.err == nil {
+		w.err = err
+		w.stop()
+	}
+}
+
+//kick restarts the inactivity timeout.
+func (w *watchdog) kick() {
+	if w.idle != nil {
+		w.idle.Reset(w.inactivity)
+	}
+}
+
+func (w *watchdog) close() {
+	if w.deadline != nil {
+		w.deadline.Stop()
+	}
+	if w.idle != nil {
+		w.idle.Stop()
+	}
+	close(w.done)
+	w.stop()
+}
+
+//errOr returns the reason the transfer was aborted for, or err if it was
+//not.
+func (w *watchdog) errOr(err error) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	return err
+}
+
+//watchedReader kicks the watchdog whenever data goes through it.
+type watchedReader struct {
+	io.ReadCloser
+	w *watchdog
+}
+
+func (r *watchedReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.w.kick()
+	}
+	return n, err
+}
+
+//setHeaders adds the operator specific headers in options to req.
+func setHeaders(req *http.Request, options TransferOptions) {
+	userAgent, uaProf := options.UserAgent, options.UAProf
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	if uaProf == "" {
+		uaProf = DefaultUAProf
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("X-Wap-Profile", uaProf)
+	for name, value := range options.Headers {
+		if value == "" {
+			req.Header.Del(name)
+		} else {
+			req.Header.Set(name, value)
+		}
+	}
+}
+
+var errRangeNotSatisfiable = errors.New("requested range not satisfiable")
+
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s for %s", resp.Status, resp.Request.URL)
+	}
+	return nil
+}
+
+//receive writes the body of resp to file, appending to the offset bytes
+//already in it if the server honored the range request. The data received
+//is kept on errors.
+func receive(file string, offset int64, resp *http.Response, progress Progress) error {
+	flags := os.O_WRONLY | os.O_CREATE
+	size := resp.ContentLength
+	if resp.StatusCode == http.StatusPartialContent {
+		start, total, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return err
+		}
+		if start != offset {
+			return fmt.Errorf("server sent content from byte %d instead of %d", start, offset)
+		}
+		flags |= os.O_APPEND
+		size = total
+		if size < 0 && resp.ContentLength >= 0 {
+			size = offset + resp.ContentLength
+		}
+	} else {
+		if offset > 0 {
+			log.Print("Server ignored the range request for ", resp.Request.URL, ", starting over")
+		}
+		flags |= os.O_TRUNC
+		offset = 0
+	}
+	f, err := os.OpenFile(file, flags, 0600)
+	if err != nil {
+		return err
+	}
+	w := &progressWriter{w: f, n: offset, size: size, progress: progress}
+	n, err := io.Copy(w, resp.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if size >= 0 && w.n != size {
+		return fmt.Errorf("received %d bytes out of %d from %s", w.n, size, resp.Request.URL)
+	}
+	log.Printf("Wrote %d bytes from %s to %s", n, resp.Request.URL, file)
+	return nil
+}
+
+//parseContentRange parses a Content-Range header of the form
+//"bytes first-last/length", length is -1 if it is unknown.
+func parseContentRange(contentRange string) (start, length int64, err error) {
+	var last int64
+	var rest string
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%s", &start, &last, &rest); err != nil {
+		return 0, 0, fmt.Errorf("cannot parse Content-Range %q: %s", contentRange, err)
+	}
+	if rest == "*" {
+		return start, -1, nil
+	}
+	if length, err = strconv.ParseInt(rest, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("cannot parse Content-Range %q: %s", contentRange, err)
+	}
+	return start, length, nil
+}
+
+//progressWriter reports the bytes written through it to progress.
+type progressWriter struct {
+	w        io.Writer
+	n, size  int64
+	progress Progress
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.n += int64(n)
+	if pw.progress != nil {
+		pw.progress(pw.n, pw.size)
+	}
+	return n, err
+}
+
+//progressReader reports the bytes read through it to progress.
+type progressReader struct {
+	r        io.Reader
+	n, size  int64
+	progress Progress
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	pr.n += int64(n)
+	if n > 0 && pr.progress != nil {
+		pr.progress(pr.n, pr.size)
+	}
+	return n, err
+}
+
+func proxyURL(proxy Proxy) func(*http.Request) (*url.URL, error) {
+	if proxy.Host == "" {
+		return nil
+	}
+	host := proxy.Host
+	if proxy.Port != 0 {
+		host = net.JoinHostPort(proxy.Host, strconv.FormatUint(proxy.Port, 10))
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	u := &url.URL{Scheme: "http", Host: host}
+	if proxy.Username != "" {
+		u.User = url.UserPassword(proxy.Username, proxy.Password)
+	}
+	return http.ProxyURL(u)
+}
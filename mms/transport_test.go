@@ -0,0 +1,463 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of mms.
+ *
+ * mms is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * mms is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mms
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+type TransportTestSuite struct {
+	dir       string
+	transport *HTTPTransport
+}
+
+var _ = Suite(&TransportTestSuite{})
+
+func (s *TransportTestSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+	s.transport = &HTTPTransport{Dir: s.dir}
+}
+
+//serverProxy returns the Proxy for server so it is used as an HTTP proxy.
+func serverProxy(c *C, server *httptest.Server) Proxy {
+	u, err := url.Parse(server.URL)
+	c.Assert(err, IsNil)
+	host, port, _ := net.SplitHostPort(u.Host)
+	p, err := strconv.ParseUint(port, 10, 64)
+	c.Assert(err, IsNil)
+	return Proxy{Host: host, Port: p}
+}
+
+func (s *TransportTestSuite) TestDownload(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, Equals, "GET")
+		c.Check(r.URL.Path, Equals, "/mms/1")
+		c.Check(r.Header.Get("Range"), Equals, "")
+		w.Write([]byte{0x8c, 0x84})
+	}))
+	defer server.Close()
+
+	file := filepath.Join(s.dir, "download")
+	err := s.transport.Download(server.URL+"/mms/1", file, TransferOptions{}, nil, nil)
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadFile(file)
+	c.Assert(err, IsNil)
+	c.Check(data, DeepEquals, []byte{0x8c, 0x84})
+}
+
+func (s *TransportTestSuite) TestDownloadThroughProxy(c *C) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//proxied requests carry the absolute URL
+		c.Check(r.URL.String(), Equals, "http://mmsc.example.com/mms/1")
+		w.Write([]byte("proxied"))
+	}))
+	defer proxy.Close()
+
+	file := filepath.Join(s.dir, "download")
+	err := s.transport.Download("http://mmsc.example.com/mms/1", file, TransferOptions{Proxy: serverProxy(c, proxy)}, nil, nil)
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadFile(file)
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, "proxied")
+}
+
+func (s *TransportTestSuite) TestDownloadError(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	err := s.transport.Download(server.URL, filepath.Join(s.dir, "download"), TransferOptions{}, nil, nil)
+	c.Check(err, ErrorMatches, "unexpected status 404 Not Found for .*")
+	files, _ := ioutil.ReadDir(s.dir)
+	c.Check(files, HasLen, 0)
+}
+
+func (s *TransportTestSuite) TestDownloadCanceled(c *C) {
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte{0x8c})
+		w.(http.Flusher).Flush()
+		select {
+		case <-block:
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+	defer close(block)
+
+	cancel := make(chan struct{})
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(cancel)
+	}()
+	file := filepath.Join(s.dir, "download")
+	err := s.transport.Download(server.URL, file, TransferOptions{}, cancel, nil)
+	c.Check(err, Equals, ErrCanceled)
+	//what was received is kept for the caller to resume or remove
+	data, err := ioutil.ReadFile(file)
+	c.Assert(err, IsNil)
+	c.Check(data, DeepEquals, []byte{0x8c})
+}
+
+//rangeServer serves content honoring range requests with the standard
+//library, which answers 206 Partial Content.
+func rangeServer(c *C, content string, ranges *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*ranges = append(*ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+}
+
+func (s *TransportTestSuite) TestDownloadResume(c *C) {
+	var ranges []string
+	server := rangeServer(c, "0123456789", &ranges)
+	defer server.Close()
+
+	file := filepath.Join(s.dir, "download")
+	c.Assert(ioutil.WriteFile(file, []byte("0123"), 0600), IsNil)
+	var transferred, total []int64
+	progress := func(n, size int64) {
+		transferred = append(transferred, n)
+		total = append(total, size)
+	}
+	err := s.transport.Download(server.URL, file, TransferOptions{}, nil, progress)
+	c.Assert(err, IsNil)
+	c.Check(ranges, DeepEquals, []string{"bytes=4-"})
+	data, err := ioutil.ReadFile(file)
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, "0123456789")
+	c.Assert(transferred, Not(HasLen), 0)
+	c.Check(transferred[len(transferred)-1], Equals, int64(10))
+	c.Check(total[len(total)-1], Equals, int64(10))
+}
+
+func (s *TransportTestSuite) TestDownloadResumeIgnored(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Header.Get("Range"), Equals, "bytes=4-")
+		w.Write([]byte("0123456789"))
+	}))
+	defer server.Close()
+
+	file := filepath.Join(s.dir, "download")
+	c.Assert(ioutil.WriteFile(file, []byte("0123"), 0600), IsNil)
+	err := s.transport.Download(server.URL, file, TransferOptions{}, nil, nil)
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadFile(file)
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, "0123456789")
+}
+
+func (s *TransportTestSuite) TestDownloadResumeNotSatisfiable(c *C) {
+	var ranges []string
+	server := rangeServer(c, "0123", &ranges)
+	defer server.Close()
+
+	//a stale partial download longer than the content
+	file := filepath.Join(s.dir, "download")
+	c.Assert(ioutil.WriteFile(file, []byte("abcdefgh"), 0600), IsNil)
+	err := s.transport.Download(server.URL, file, TransferOptions{}, nil, nil)
+	c.Assert(err, IsNil)
+	c.Check(ranges, DeepEquals, []string{"bytes=8-", ""})
+	data, err := ioutil.ReadFile(file)
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, "0123")
+}
+
+func (s *TransportTestSuite) TestDownloadTruncated(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "10")
+		w.Write([]byte("01234"))
+		w.(http.Flusher).Flush()
+		//hijack to drop the connection before the whole body is sent
+		conn, _, err := w.(http.Hijacker).Hijack()
+		c.Assert(err, IsNil)
+		conn.Close()
+	}))
+	defer server.Close()
+
+	file := filepath.Join(s.dir, "download")
+	err := s.transport.Download(server.URL, file, TransferOptions{}, nil, nil)
+	c.Check(err, NotNil)
+	data, err := ioutil.ReadFile(file)
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, "01234")
+}
+
+func (s *TransportTestSuite) TestParseContentRange(c *C) {
+	start, length, err := parseContentRange("bytes 4-9/10")
+	c.Assert(err, IsNil)
+	c.Check(start, Equals, int64(4))
+	c.Check(length, Equals, int64(10))
+
+	start, length, err = parseContentRange("bytes 4-9/*")
+	c.Assert(err, IsNil)
+	c.Check(start, Equals, int64(4))
+	c.Check(length, Equals, int64(-1))
+
+	_, _, err = parseContentRange("bytes */10")
+	c.Check(err, NotNil)
+}
+
+func (s *TransportTestSuite) TestUpload(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, Equals, "POST")
+		c.Check(r.Header.Get("Content-Type"), Equals, VND_WAP_MMS_MESSAGE)
+		c.Check(r.ContentLength, Equals, int64(3))
+		body, err := ioutil.ReadAll(r.Body)
+		c.Check(err, IsNil)
+		c.Check(body, DeepEquals, []byte{0x8c, 0x80, 0x98})
+		w.Header().Set("Content-Type", VND_WAP_MMS_MESSAGE)
+		w.Write([]byte{0x8c, 0x81})
+	}))
+	defer server.Close()
+
+	file := filepath.Join(s.dir, "m-send.req")
+	c.Assert(ioutil.WriteFile(file, []byte{0x8c, 0x80, 0x98}, 0644), IsNil)
+	responseFile, err := s.transport.Upload(file, server.URL, TransferOptions{}, nil, nil)
+	c.Assert(err, IsNil)
+	defer os.Remove(responseFile)
+	data, err := ioutil.ReadFile(responseFile)
+	c.Assert(err, IsNil)
+	c.Check(data, DeepEquals, []byte{0x8c, 0x81})
+}
+
+func (s *TransportTestSuite) TestUploadMissingFile(c *C) {
+	_, err := s.transport.Upload(filepath.Join(s.dir, "missing"), "http://mmsc.example.com", TransferOptions{}, nil, nil)
+	c.Check(err, NotNil)
+}
+
+//trickleServer writes chunks of content every interval.
+func trickleServer(chunks int, interval time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < chunks; i++ {
+			if _, err := w.Write([]byte{byte(i)}); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			time.Sleep(interval)
+		}
+	}))
+}
+
+func (s *TransportTestSuite) TestDownloadInactivityResetByData(c *C) {
+	server := trickleServer(6, 50*time.Millisecond)
+	defer server.Close()
+
+	//the whole transfer takes longer than the inactivity timeout
+	options := TransferOptions{Timeouts: Timeouts{Inactivity: 200 * time.Millisecond}}
+	file := filepath.Join(s.dir, "download")
+	err := s.transport.Download(server.URL, file, options, nil, nil)
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadFile(file)
+	c.Assert(err, IsNil)
+	c.Check(data, HasLen, 6)
+}
+
+func (s *TransportTestSuite) TestDownloadInactive(c *C) {
+	server := trickleServer(2, time.Second)
+	defer server.Close()
+
+	options := TransferOptions{Timeouts: Timeouts{Inactivity: 200 * time.Millisecond}}
+	file := filepath.Join(s.dir, "download")
+	err := s.transport.Download(server.URL, file, options, nil, nil)
+	c.Check(err, Equals, ErrInactive)
+	data, err := ioutil.ReadFile(file)
+	c.Assert(err, IsNil)
+	c.Check(data, DeepEquals, []byte{0})
+}
+
+func (s *TransportTestSuite) TestDownloadDeadlineExceeded(c *C) {
+	server := trickleServer(20, 50*time.Millisecond)
+	defer server.Close()
+
+	options := TransferOptions{Timeouts: Timeouts{Deadline: 300 * time.Millisecond, Inactivity: 200 * time.Millisecond}}
+	err := s.transport.Download(server.URL, filepath.Join(s.dir, "download"), options, nil, nil)
+	c.Check(err, Equals, ErrDeadlineExceeded)
+}
+
+func (s *TransportTestSuite) TestUploadInactive(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		time.Sleep(time.Second)
+	}))
+	defer server.Close()
+
+	file := filepath.Join(s.dir, "m-send.req")
+	c.Assert(ioutil.WriteFile(file, []byte{0x8c, 0x80}, 0644), IsNil)
+	options := TransferOptions{Timeouts: Timeouts{Inactivity: 200 * time.Millisecond}}
+	_, err := s.transport.Upload(file, server.URL, options, nil, nil)
+	c.Check(err, Equals, ErrInactive)
+}
+
+func (s *TransportTestSuite) TestUploadProgress(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		w.Write([]byte{0x8c, 0x81})
+	}))
+	defer server.Close()
+
+	file := filepath.Join(s.dir, "m-send.req")
+	c.Assert(ioutil.WriteFile(file, make([]byte, 4096), 0644), IsNil)
+	var last, total int64
+	responseFile, err := s.transport.Upload(file, server.URL, TransferOptions{}, nil, func(transferred, size int64) {
+		c.Check(transferred > last, Equals, true)
+		last, total = transferred, size
+	})
+	c.Assert(err, IsNil)
+	os.Remove(responseFile)
+	c.Check(last, Equals, int64(4096))
+	c.Check(total, Equals, int64(4096))
+}
+
+func (s *TransportTestSuite) TestUploadCanceled(c *C) {
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		select {
+		case <-block:
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+	defer close(block)
+
+	file := filepath.Join(s.dir, "m-send.req")
+	c.Assert(ioutil.WriteFile(file, []byte{0x8c, 0x80}, 0644), IsNil)
+	cancel := make(chan struct{})
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(cancel)
+	}()
+	_, err := s.transport.Upload(file, server.URL, TransferOptions{}, cancel, nil)
+	c.Check(err, Equals, ErrCanceled)
+	files, _ := ioutil.ReadDir(s.dir)
+	c.Check(files, HasLen, 1)
+}
+
+func (s *TransportTestSuite) TestTimeoutsScale(c *C) {
+	timeouts := Timeouts{Deadline: time.Minute, Inactivity: 10 * time.Second}
+	c.Check(timeouts.Scale("gsm"), Equals, Timeouts{Deadline: 4 * time.Minute, Inactivity: 40 * time.Second})
+	c.Check(timeouts.Scale("edge"), Equals, Timeouts{Deadline: 2 * time.Minute, Inactivity: 20 * time.Second})
+	c.Check(timeouts.Scale("lte"), Equals, timeouts)
+	c.Check(timeouts.Scale(""), Equals, timeouts)
+}
+
+func (s *TransportTestSuite) TestDefaultHeaders(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Header.Get("User-Agent"), Equals, DefaultUserAgent)
+		c.Check(r.Header.Get("X-Wap-Profile"), Equals, DefaultUAProf)
+	}))
+	defer server.Close()
+
+	err := s.transport.Download(server.URL, filepath.Join(s.dir, "download"), TransferOptions{}, nil, nil)
+	c.Check(err, IsNil)
+}
+
+func (s *TransportTestSuite) TestOperatorHeaders(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Header.Get("User-Agent"), Equals, "Operator-Phone/1.0")
+		c.Check(r.Header.Get("X-Wap-Profile"), Equals, "http://operator.example.com/uaprof.xml")
+		c.Check(r.Header.Get("X-Operator-Token"), Equals, "1234")
+	}))
+	defer server.Close()
+
+	options := TransferOptions{
+		UserAgent: "Operator-Phone/1.0",
+		UAProf:    "http://operator.example.com/uaprof.xml",
+		Headers:   map[string]string{"X-Operator-Token": "1234"},
+	}
+	err := s.transport.Download(server.URL, filepath.Join(s.dir, "download"), options, nil, nil)
+	c.Check(err, IsNil)
+
+	file := filepath.Join(s.dir, "m-send.req")
+	c.Assert(ioutil.WriteFile(file, []byte{0x8c, 0x80}, 0644), IsNil)
+	responseFile, err := s.transport.Upload(file, server.URL, options, nil, nil)
+	c.Check(err, IsNil)
+	os.Remove(responseFile)
+}
+
+func (s *TransportTestSuite) TestRemoveHeader(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := r.Header["X-Wap-Profile"]
+		c.Check(ok, Equals, false)
+	}))
+	defer server.Close()
+
+	options := TransferOptions{Headers: map[string]string{"x-wap-profile": ""}}
+	err := s.transport.Download(server.URL, filepath.Join(s.dir, "download"), options, nil, nil)
+	c.Check(err, IsNil)
+}
+
+func (s *TransportTestSuite) TestDownloadThroughAuthenticatedProxy(c *C) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//basic credentials for mms:secret
+		c.Check(r.Header.Get("Proxy-Authorization"), Equals, "Basic bW1zOnNlY3JldA==")
+		w.Write([]byte("proxied"))
+	}))
+	defer proxy.Close()
+
+	options := TransferOptions{Proxy: serverProxy(c, proxy)}
+	options.Proxy.Username = "mms"
+	options.Proxy.Password = "secret"
+	err := s.transport.Download("http://mmsc.example.com/mms/1", filepath.Join(s.dir, "download"), options, nil, nil)
+	c.Assert(err, IsNil)
+
+	file := filepath.Join(s.dir, "m-send.req")
+	c.Assert(ioutil.WriteFile(file, []byte{0x8c, 0x80}, 0644), IsNil)
+	responseFile, err := s.transport.Upload(file, "http://mmsc.example.com/mms", options, nil, nil)
+	c.Assert(err, IsNil)
+	os.Remove(responseFile)
+}
+
+func (s *TransportTestSuite) TestDownloadThroughIPv6Proxy(c *C) {
+	l, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		c.Skip("IPv6 is not available: " + err.Error())
+	}
+	proxy := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.String(), Equals, "http://mmsc.example.com/mms/1")
+		w.Write([]byte("proxied"))
+	}))
+	proxy.Listener = l
+	proxy.Start()
+	defer proxy.Close()
+
+	options := TransferOptions{Proxy: serverProxy(c, proxy)}
+	c.Check(options.Proxy.Host, Equals, "::1")
+	file := filepath.Join(s.dir, "download")
+	c.Assert(s.transport.Download("http://mmsc.example.com/mms/1", file, options, nil, nil), IsNil)
+	data, err := ioutil.ReadFile(file)
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, "proxied")
+}
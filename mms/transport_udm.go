@@ -0,0 +1,184 @@
+// +build udm
+
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of mms.
+ *
+ * mms is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * mms is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mms
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"launchpad.net/udm"
+)
+
+//UDMTransport transfers PDUs with the Ubuntu download and upload managers
+//over D-Bus, it is only built with the udm build tag. The managers route
+//the traffic themselves and send their own headers, the Interface,
+//Nameservers, header and TLS options as well as proxy credentials are
+//ignored.
+type UDMTransport struct{}
+
+func init() {
+	NewTransport = func(dir string) Transport { return &UDMTransport{} }
+}
+
+//Download retrieves url into file, the download manager cannot resume
+//partial downloads so it always starts over.
+func (t *UDMTransport) Download(url, file string, options TransferOptions, cancel <-chan struct{}, progress Progress) error {
+	proxyHost, proxyPort := options.Proxy.Host, int32(options.Proxy.Port)
+	downloadManager, err := udm.NewDownloadManager()
+	if err != nil {
+		return err
+	}
+	download, err := downloadManager.CreateMmsDownload(url, proxyHost, proxyPort)
+	if err != nil {
+		return err
+	}
+	f := download.Finished()
+	p := download.DownloadProgress()
+	e := download.Error()
+	deadline := newTimeout(options.Timeouts.Deadline)
+	defer deadline.stop()
+	idle := newTimeout(options.Timeouts.Inactivity)
+	defer idle.stop()
+	log.Print("Starting download of ", url, " with proxy ", proxyHost, ":", proxyPort)
+	download.Start()
+	for {
+		var abort error
+		select {
+		case update := <-p:
+			log.Print("Progress:", update.Total, update.Received)
+			idle.reset()
+			if progress != nil {
+				progress(int64(update.Received), int64(update.Total))
+			}
+		case downloadFilePath := <-f:
+			log.Print("File downloaded to ", downloadFilePath)
+			return os.Rename(downloadFilePath, file)
+		case <-deadline.C:
+			abort = ErrDeadlineExceeded
+		case <-idle.C:
+			abort = ErrInactive
+		case <-cancel:
+			abort = ErrCanceled
+		case err := <-e:
+			return err
+		}
+		if abort != nil {
+			log.Print("Aborting download of ", url, ": ", abort)
+			if err := download.Cancel(); err != nil {
+				log.Print("Cannot cancel download: ", err)
+			}
+			return abort
+		}
+	}
+}
+
+func (t *UDMTransport) Upload(file, msc string, options TransferOptions, cancel <-chan struct{}, progress Progress) (string, error) {
+	proxyHost, proxyPort := options.Proxy.Host, int32(options.Proxy.Port)
+	udm, err := udm.NewUploadManager()
+	if err != nil {
+		return "", err
+	}
+	upload, err := udm.CreateMmsUpload(msc, file, proxyHost, proxyPort)
+	if err != nil {
+		return "", err
+	}
+	f := upload.Finished()
+	p := upload.UploadProgress()
+	e := upload.Error()
+	deadline := newTimeout(options.Timeouts.Deadline)
+	defer deadline.stop()
+	idle := newTimeout(options.Timeouts.Inactivity)
+	defer idle.stop()
+	log.Print("Starting upload of ", file, " to ", msc, " with proxy ", proxyHost, ":", proxyPort)
+	if err := upload.Start(); err != nil {
+		return "", err
+	}
+
+	for {
+		var abort error
+		select {
+		case update := <-p:
+			log.Print("Progress:", update.Total, update.Received)
+			idle.reset()
+			if progress != nil {
+				progress(int64(update.Received), int64(update.Total))
+			}
+		case responseFile := <-f:
+			log.Print("File ", responseFile, " returned in upload")
+			return responseFile, nil
+		case <-deadline.C:
+			abort = ErrDeadlineExceeded
+		case <-idle.C:
+			abort = ErrInactive
+		case <-cancel:
+			abort = ErrCanceled
+		case err := <-e:
+			return "", err
+		}
+		if abort != nil {
+			log.Print("Aborting upload of ", file, ": ", abort)
+			if err := upload.Cancel(); err != nil {
+				log.Print("Cannot cancel upload: ", err)
+			}
+			return "", abort
+		}
+	}
+}
+
+//timeout fires on C once its duration elapses, C is nil and never fires
+//for a zero duration.
+type timeout struct {
+	C     <-chan time.Time
+	d     time.Duration
+	timer *time.Timer
+}
+
+func newTimeout(d time.Duration) *timeout {
+	if d == 0 {
+		return &timeout{}
+	}
+	timer := time.NewTimer(d)
+	return &timeout{C: timer.C, d: d, timer: timer}
+}
+
+//reset restarts the timeout from now.
+func (t *timeout) reset() {
+	if t.timer == nil {
+		return
+	}
+	if !t.timer.Stop() {
+		select {
+		case <-t.timer.C:
+		default:
+		}
+	}
+	t.timer.Reset(t.d)
+}
+
+func (t *timeout) stop() {
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+}
@@ -0,0 +1,177 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of mms.
+ *
+ * mms is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * mms is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mms
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+	ErrTooManyRecipients    = errors.New("too many recipients")
+	ErrMessageTooLarge      = errors.New("message too large")
+)
+
+//ValidationError describes why a m-send.req was rejected, Kind is one of
+//ErrUnsupportedMediaType, ErrTooManyRecipients or ErrMessageTooLarge.
+type ValidationError struct {
+	Kind   error
+	Detail string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Kind.Error() + ": " + e.Detail
+}
+
+//ContentClass is a set of media types and a maximum message size as
+//defined in OMA-MMS-CONF section 7.
+type ContentClass struct {
+	Name       string
+	MaxSize    uint64
+	MediaTypes []string
+}
+
+var (
+	ContentClassText = ContentClass{
+		Name:       "Text",
+		MaxSize:    30 * 1024,
+		MediaTypes: []string{"text/plain", "application/smil"},
+	}
+	ContentClassImageBasic = ContentClass{
+		Name:    "ImageBasic",
+		MaxSize: 30 * 1024,
+		MediaTypes: extend(ContentClassText.MediaTypes,
+			"image/jpeg", "image/jpg", "image/gif", "image/vnd.wap.wbmp",
+			"text/x-vcard", "text/vcard", "text/x-vcalendar", "text/calendar"),
+	}
+	ContentClassImageRich = ContentClass{
+		Name:       "ImageRich",
+		MaxSize:    100 * 1024,
+		MediaTypes: extend(ContentClassImageBasic.MediaTypes, "image/png"),
+	}
+	ContentClassVideoBasic = ContentClass{
+		Name:       "VideoBasic",
+		MaxSize:    300 * 1024,
+		MediaTypes: extend(ContentClassImageRich.MediaTypes, "audio/amr", "audio/3gpp", "video/3gpp"),
+	}
+	ContentClassVideoRich = ContentClass{
+		Name:    "VideoRich",
+		MaxSize: 300 * 1024,
+		MediaTypes: extend(ContentClassVideoBasic.MediaTypes,
+			"audio/amr-wb", "audio/mp4", "video/3gpp2", "video/mp4"),
+	}
+)
+
+//extend returns a copy of base with more appended so content classes do
+//not share their media types.
+func extend(base []string, more ...string) []string {
+	return append(append([]string{}, base...), more...)
+}
+
+var contentClasses = map[string]ContentClass{
+	ContentClassText.Name:       ContentClassText,
+	ContentClassImageBasic.Name: ContentClassImageBasic,
+	ContentClassImageRich.Name:  ContentClassImageRich,
+	ContentClassVideoBasic.Name: ContentClassVideoBasic,
+	ContentClassVideoRich.Name:  ContentClassVideoRich,
+}
+
+//Validator checks a m-send.req against a content class and the operator
+//limits before it is sent. Zero values disable a check.
+type Validator struct {
+	Class         *ContentClass
+	MaxRecipients int
+	MaxSize       uint64
+}
+
+//NewValidator creates a Validator for the content class called class, an
+//empty class allows any media type.
+func NewValidator(class string, maxRecipients int, maxSize uint64) (*Validator, error) {
+	v := &Validator{MaxRecipients: maxRecipients, MaxSize: maxSize}
+	if class != "" {
+		cc, ok := contentClasses[class]
+		if !ok {
+			return nil, fmt.Errorf("unknown content class %s", class)
+		}
+		v.Class = &cc
+	}
+	return v, nil
+}
+
+//Validate returns a *ValidationError if pdu breaks any of the validator
+//rules.
+func (v *Validator) Validate(pdu *MSendReq) error {
+	if v.MaxRecipients > 0 && len(pdu.To) > v.MaxRecipients {
+		return &ValidationError{
+			Kind:   ErrTooManyRecipients,
+			Detail: fmt.Sprintf("%d recipients with a maximum of %d", len(pdu.To), v.MaxRecipients),
+		}
+	}
+	if v.Class != nil {
+		for _, a := range pdu.Attachments {
+			if !v.Class.allows(a.MediaType) {
+				return &ValidationError{
+					Kind:   ErrUnsupportedMediaType,
+					Detail: fmt.Sprintf("%s of %s is not allowed in the %s content class", a.MediaType, a.ContentId, v.Class.Name),
+				}
+			}
+		}
+	}
+
+	maxSize := v.Limit()
+	if maxSize == 0 {
+		return nil
+	}
+	size, err := pdu.EncodedSize()
+	if err != nil {
+		return err
+	}
+	if size > maxSize {
+		return &ValidationError{
+			Kind:   ErrMessageTooLarge,
+			Detail: fmt.Sprintf("%d bytes with a maximum of %d", size, maxSize),
+		}
+	}
+	return nil
+}
+
+//Limit returns the maximum message size allowed, the smaller of MaxSize
+//and the content class one, or 0 if there is none.
+func (v *Validator) Limit() uint64 {
+	maxSize := v.MaxSize
+	if v.Class != nil && (maxSize == 0 || v.Class.MaxSize < maxSize) {
+		maxSize = v.Class.MaxSize
+	}
+	return maxSize
+}
+
+func (cc *ContentClass) allows(mediaType string) bool {
+	mediaType = strings.ToLower(strings.TrimSpace(strings.Split(mediaType, ";")[0]))
+	for _, mt := range cc.MediaTypes {
+		if mt == mediaType {
+			return true
+		}
+	}
+	return false
+}
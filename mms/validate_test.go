@@ -0,0 +1,123 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of mms.
+ *
+ * mms is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * mms is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mms
+
+import (
+	"bytes"
+
+	. "launchpad.net/gocheck"
+)
+
+type ValidateTestSuite struct{}
+
+var _ = Suite(&ValidateTestSuite{})
+
+func newTestMSendReq(c *C, recipients []string, mediaTypes ...string) *MSendReq {
+	var attachments []*Attachment
+	for _, mediaType := range mediaTypes {
+		data := make([]byte, 1024)
+		att, err := NewAttachmentReader("part", mediaType, bytes.NewReader(data), int64(len(data)))
+		c.Assert(err, IsNil)
+		attachments = append(attachments, att)
+	}
+	return NewMSendReq(recipients, attachments, false)
+}
+
+func (s *ValidateTestSuite) TestUnknownContentClass(c *C) {
+	_, err := NewValidator("Megapixel", 0, 0)
+	c.Check(err, NotNil)
+}
+
+func (s *ValidateTestSuite) TestNoRules(c *C) {
+	v, err := NewValidator("", 0, 0)
+	c.Assert(err, IsNil)
+	c.Check(v.Limit(), Equals, uint64(0))
+	c.Check(v.Validate(newTestMSendReq(c, []string{"+1", "+2"}, "application/x-anything")), IsNil)
+}
+
+func (s *ValidateTestSuite) TestNoLimits(c *C) {
+	v, err := NewValidator("", 0, 0)
+	c.Assert(err, IsNil)
+	c.Check(v.Limit(), Equals, uint64(0))
+	c.Check(v.Validate(newTestMSendReq(c, []string{"+1", "+2"}, "audio/mpeg", "video/mp4")), IsNil)
+}
+
+func (s *ValidateTestSuite) TestContentClassMediaTypes(c *C) {
+	v, err := NewValidator("ImageBasic", 0, 0)
+	c.Assert(err, IsNil)
+	c.Check(v.Validate(newTestMSendReq(c, []string{"+1"}, "text/plain;charset=utf-8", "image/JPEG")), IsNil)
+
+	err = v.Validate(newTestMSendReq(c, []string{"+1"}, "image/png"))
+	c.Assert(err, FitsTypeOf, &ValidationError{})
+	c.Check(err.(*ValidationError).Kind, Equals, ErrUnsupportedMediaType)
+
+	v, err = NewValidator("ImageRich", 0, 0)
+	c.Assert(err, IsNil)
+	c.Check(v.Validate(newTestMSendReq(c, []string{"+1"}, "image/png")), IsNil)
+
+	err = v.Validate(newTestMSendReq(c, []string{"+1"}, "video/3gpp"))
+	c.Assert(err, FitsTypeOf, &ValidationError{})
+	c.Check(err.(*ValidationError).Kind, Equals, ErrUnsupportedMediaType)
+}
+
+func (s *ValidateTestSuite) TestContentClassesDoNotShareMediaTypes(c *C) {
+	c.Check(ContentClassText.allows("image/jpeg"), Equals, false)
+	c.Check(ContentClassImageBasic.allows("image/png"), Equals, false)
+	c.Check(ContentClassImageRich.allows("video/3gpp"), Equals, false)
+	c.Check(ContentClassVideoBasic.allows("video/mp4"), Equals, false)
+	c.Check(ContentClassVideoRich.allows("video/mp4"), Equals, true)
+}
+
+func (s *ValidateTestSuite) TestMaxRecipients(c *C) {
+	v, err := NewValidator("", 2, 0)
+	c.Assert(err, IsNil)
+	c.Check(v.Validate(newTestMSendReq(c, []string{"+1", "+2"}, "text/plain")), IsNil)
+
+	err = v.Validate(newTestMSendReq(c, []string{"+1", "+2", "+3"}, "text/plain"))
+	c.Assert(err, FitsTypeOf, &ValidationError{})
+	c.Check(err.(*ValidationError).Kind, Equals, ErrTooManyRecipients)
+	c.Check(err.Error(), Equals, "too many recipients: 3 recipients with a maximum of 2")
+}
+
+func (s *ValidateTestSuite) TestMaxSize(c *C) {
+	v, err := NewValidator("", 0, 2048)
+	c.Assert(err, IsNil)
+	c.Check(v.Validate(newTestMSendReq(c, []string{"+1"}, "text/plain")), IsNil)
+
+	err = v.Validate(newTestMSendReq(c, []string{"+1"}, "text/plain", "text/plain"))
+	c.Assert(err, FitsTypeOf, &ValidationError{})
+	c.Check(err.(*ValidationError).Kind, Equals, ErrMessageTooLarge)
+}
+
+func (s *ValidateTestSuite) TestLimit(c *C) {
+	v, err := NewValidator("ImageRich", 0, 300*1024)
+	c.Assert(err, IsNil)
+	c.Check(v.Limit(), Equals, uint64(100*1024))
+
+	v, err = NewValidator("VideoRich", 0, 100*1024)
+	c.Assert(err, IsNil)
+	c.Check(v.Limit(), Equals, uint64(100*1024))
+
+	v, err = NewValidator("Text", 0, 0)
+	c.Assert(err, IsNil)
+	c.Check(v.Limit(), Equals, uint64(30*1024))
+}
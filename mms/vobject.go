@@ -0,0 +1,421 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of mms.
+ *
+ * mms is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * mms is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mms
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime/quotedprintable"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+//Phone is a TEL property of a vCard, Types holds its parameters such as
+//CELL or HOME.
+type Phone struct {
+	Number string
+	Types  []string
+}
+
+//Contact holds the properties of a vCard as defined in RFC 2426 that are
+//relevant to display it.
+type Contact struct {
+	FormattedName string
+	//Name holds the N components: family, given, additional, prefixes
+	//and suffixes
+	Name         []string
+	Phones       []Phone
+	Emails       []string
+	Organization string
+}
+
+//Event holds the properties of a VEVENT of a vCalendar 1.0 or iCalendar
+//object.
+type Event struct {
+	Title       string
+	Location    string
+	Description string
+	Start       time.Time
+	End         time.Time
+	//AllDay is set when Start is a date without a time
+	AllDay bool
+}
+
+//contentLine is a single unfolded property line of a vCard or vCalendar
+//object.
+type contentLine struct {
+	name   string
+	params map[string][]string
+	value  string
+}
+
+//DisplayName returns the formatted name of the contact, or one built from
+//its name components if there is none.
+func (c *Contact) DisplayName() string {
+	if c.FormattedName != "" {
+		return c.FormattedName
+	}
+	var parts []string
+	//given name first
+	for _, i := range []int{3, 1, 2, 0, 4} {
+		if i < len(c.Name) && c.Name[i] != "" {
+			parts = append(parts, c.Name[i])
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+//Summary returns the name and first phone number of the contact.
+func (c *Contact) Summary() string {
+	parts := []string{}
+	if name := c.DisplayName(); name != "" {
+		parts = append(parts, name)
+	}
+	if len(c.Phones) > 0 {
+		parts = append(parts, c.Phones[0].Number)
+	} else if len(c.Emails) > 0 {
+		parts = append(parts, c.Emails[0])
+	}
+	return strings.Join(parts, ", ")
+}
+
+//Summary returns the title and start time of the event.
+func (e *Event) Summary() string {
+	if e.Start.IsZero() {
+		return e.Title
+	}
+	if e.AllDay {
+		return e.Title + ", " + e.Start.Format("2006-01-02")
+	}
+	return e.Title + ", " + e.Start.Format(time.RFC3339)
+}
+
+//ParseVCard parses the vCard objects in data, charset is the one from the
+//part Content-Type and is used unless a property sets its own.
+func ParseVCard(data []byte, charset string) ([]Contact, error) {
+	lines, err := parseContentLines(data, charset)
+	if err != nil {
+		return nil, err
+	}
+	var contacts []Contact
+	var contact *Contact
+	for _, line := range lines {
+		switch line.name {
+		case "BEGIN":
+			if strings.EqualFold(line.value, "VCARD") {
+				contact = &Contact{}
+			}
+		case "END":
+			if strings.EqualFold(line.value, "VCARD") && contact != nil {
+				contacts = append(contacts, *contact)
+				contact = nil
+			}
+		}
+		if contact == nil {
+			continue
+		}
+		switch line.name {
+		case "FN":
+			contact.FormattedName = unescapeText(line.value)
+		case "N":
+			contact.Name = splitComponents(line.value)
+		case "TEL":
+			contact.Phones = append(contact.Phones, Phone{Number: line.value, Types: line.types()})
+		case "EMAIL":
+			contact.Emails = append(contact.Emails, line.value)
+		case "ORG":
+			contact.Organization = strings.Join(splitComponents(line.value), ", ")
+		}
+	}
+	if len(contacts) == 0 {
+		return nil, errors.New("no vCard found")
+	}
+	return contacts, nil
+}
+
+//ParseVCalendar parses the VEVENT objects in data, charset is the one from
+//the part Content-Type and is used unless a property sets its own.
+func ParseVCalendar(data []byte, charset string) ([]Event, error) {
+	lines, err := parseContentLines(data, charset)
+	if err != nil {
+		return nil, err
+	}
+	var events []Event
+	var event *Event
+	for _, line := range lines {
+		switch line.name {
+		case "BEGIN":
+			if strings.EqualFold(line.value, "VEVENT") {
+				event = &Event{}
+			}
+		case "END":
+			if strings.EqualFold(line.value, "VEVENT") && event != nil {
+				events = append(events, *event)
+				event = nil
+			}
+		}
+		if event == nil {
+			continue
+		}
+		var err error
+		switch line.name {
+		case "SUMMARY":
+			event.Title = unescapeText(line.value)
+		case "LOCATION":
+			event.Location = unescapeText(line.value)
+		case "DESCRIPTION":
+			event.Description = unescapeText(line.value)
+		case "DTSTART":
+			event.Start, event.AllDay, err = line.time()
+		case "DTEND":
+			event.End, _, err = line.time()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(events) == 0 {
+		return nil, errors.New("no vCalendar event found")
+	}
+	return events, nil
+}
+
+//Contacts parses the attachment as a vCard.
+func (a *Attachment) Contacts() ([]Contact, error) {
+	return ParseVCard(a.Data, a.Charset)
+}
+
+//Events parses the attachment as a vCalendar.
+func (a *Attachment) Events() ([]Event, error) {
+	return ParseVCalendar(a.Data, a.Charset)
+}
+
+//Summary returns a short description of vCard and vCalendar attachments,
+//the contact name and phone or the event title and start time, to display
+//in notifications. It returns false for any other attachment.
+func (a *Attachment) Summary() (string, bool) {
+	mediaType, _ := splitCharset(a.MediaType)
+	var summaries []string
+	switch strings.ToLower(mediaType) {
+	case "text/x-vcard", "text/vcard", "text/directory":
+		contacts, err := a.Contacts()
+		if err != nil {
+			return "", false
+		}
+		for i := range contacts {
+			summaries = append(summaries, contacts[i].Summary())
+		}
+	case "text/x-vcalendar", "text/calendar":
+		events, err := a.Events()
+		if err != nil {
+			return "", false
+		}
+		for i := range events {
+			summaries = append(summaries, events[i].Summary())
+		}
+	default:
+		return "", false
+	}
+	return strings.Join(summaries, "; "), true
+}
+
+//parseContentLines unfolds data and splits it into content lines, values
+//are decoded from quoted printable and converted to UTF-8.
+func parseContentLines(data []byte, charset string) ([]contentLine, error) {
+	text, err := decodeText(data, charset)
+	if err != nil {
+		return nil, err
+	}
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	var lines []contentLine
+	var raw []string
+	for _, l := range strings.Split(text, "\n") {
+		switch {
+		//vCard 2.1 quoted printable soft line breaks
+		case len(raw) > 0 && strings.HasSuffix(raw[len(raw)-1], "=") && isQuotedPrintable(raw[len(raw)-1]):
+			raw[len(raw)-1] += "\n" + l
+		case (strings.HasPrefix(l, " ") || strings.HasPrefix(l, "\t")) && len(raw) > 0:
+			raw[len(raw)-1] += l[1:]
+		default:
+			raw = append(raw, l)
+		}
+	}
+	for _, l := range raw {
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+		line, err := parseContentLine(l)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+func isQuotedPrintable(l string) bool {
+	i := strings.Index(l, ":")
+	return i != -1 && strings.Contains(strings.ToUpper(l[:i]), "QUOTED-PRINTABLE")
+}
+
+func parseContentLine(l string) (contentLine, error) {
+	i := strings.Index(l, ":")
+	if i == -1 {
+		return contentLine{}, fmt.Errorf("malformed content line %q", l)
+	}
+	line := contentLine{params: make(map[string][]string), value: l[i+1:]}
+	fields := strings.Split(l[:i], ";")
+	line.name = strings.ToUpper(fields[0])
+	//drop the group
+	if j := strings.LastIndex(line.name, "."); j != -1 {
+		line.name = line.name[j+1:]
+	}
+	for _, param := range fields[1:] {
+		if kv := strings.SplitN(param, "=", 2); len(kv) == 2 {
+			key := strings.ToUpper(kv[0])
+			line.params[key] = append(line.params[key], strings.Split(kv[1], ",")...)
+		} else {
+			//vCard 2.1 parameters without a name are types
+			line.params["TYPE"] = append(line.params["TYPE"], param)
+		}
+	}
+
+	if enc := line.param("ENCODING"); strings.EqualFold(enc, "QUOTED-PRINTABLE") {
+		value := strings.Replace(line.value, "=\n", "", -1)
+		b, err := ioutil.ReadAll(quotedprintable.NewReader(strings.NewReader(value)))
+		if err != nil {
+			return contentLine{}, fmt.Errorf("cannot decode quoted printable %s: %s", line.name, err)
+		}
+		if line.value, err = decodeText(b, line.param("CHARSET")); err != nil {
+			return contentLine{}, err
+		}
+	}
+	return line, nil
+}
+
+func (line contentLine) param(key string) string {
+	if values := line.params[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (line contentLine) types() []string {
+	var types []string
+	for _, t := range line.params["TYPE"] {
+		types = append(types, strings.ToUpper(t))
+	}
+	return types
+}
+
+//time parses DATE-TIME and DATE values in the basic ISO 8601 format used
+//by vCalendar and iCalendar, floating times are taken in TZID or local
+//time.
+func (line contentLine) time() (time.Time, bool, error) {
+	loc := time.Local
+	if tzid := line.param("TZID"); tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	value := strings.TrimSpace(line.value)
+	if t, err := time.Parse("20060102T150405Z", value); err == nil {
+		return t, false, nil
+	}
+	if t, err := time.ParseInLocation("20060102T150405", value, loc); err == nil {
+		return t, false, nil
+	}
+	if t, err := time.ParseInLocation("20060102", value, loc); err == nil {
+		return t, true, nil
+	}
+	return time.Time{}, false, fmt.Errorf("cannot parse %s %q", line.name, value)
+}
+
+//splitComponents splits a structured value on unescaped semicolons.
+func splitComponents(value string) []string {
+	var components []string
+	var current bytes.Buffer
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && i+1 < len(value):
+			i++
+			current.WriteByte(value[i])
+		case value[i] == ';':
+			components = append(components, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(value[i])
+		}
+	}
+	return append(components, current.String())
+}
+
+func unescapeText(value string) string {
+	r := strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+	return r.Replace(value)
+}
+
+//decodeText converts data in charset to UTF-8, data without a charset is
+//taken as UTF-8 unless it has a UTF-16 byte order mark.
+func decodeText(data []byte, charset string) (string, error) {
+	charset = strings.ToLower(charset)
+	if charset == "" && len(data) >= 2 && (data[0] == 0xfe && data[1] == 0xff || data[0] == 0xff && data[1] == 0xfe) {
+		charset = "utf-16"
+	}
+	switch charset {
+	case "", "utf-8", "us-ascii":
+		if !utf8.Valid(data) {
+			return "", fmt.Errorf("invalid %s text", charset)
+		}
+		return strings.TrimPrefix(string(data), "\ufeff"), nil
+	case "iso-8859-1", "latin1":
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes), nil
+	case "utf-16", "iso-10646-ucs-2", "utf-16be", "utf-16le":
+		if len(data)%2 != 0 {
+			return "", errors.New("odd length UTF-16 text")
+		}
+		bigEndian := charset != "utf-16le"
+		if len(data) >= 2 && data[0] == 0xff && data[1] == 0xfe {
+			bigEndian, data = false, data[2:]
+		} else if len(data) >= 2 && data[0] == 0xfe && data[1] == 0xff {
+			bigEndian, data = true, data[2:]
+		}
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			if bigEndian {
+				units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+			} else {
+				units[i] = uint16(data[2*i+1])<<8 | uint16(data[2*i])
+			}
+		}
+		return string(utf16.Decode(units)), nil
+	}
+	return "", fmt.Errorf("unsupported charset %s", charset)
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of mms.
+ *
+ * mms is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * mms is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package mms
+
+import (
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+type VObjectTestSuite struct{}
+
+var _ = Suite(&VObjectTestSuite{})
+
+func (s *VObjectTestSuite) TestParseVCard30(c *C) {
+	vcard := "BEGIN:VCARD\r\n" +
+		"VERSION:3.0\r\n" +
+		"N:Doe;John;;Dr.;\r\n" +
+		"FN:Dr. John Doe\r\n" +
+		"ORG:Example\\, Inc.;Sales\r\n" +
+		"item1.TEL;TYPE=cell,voice:+1 555 0100\r\n" +
+		"TEL;TYPE=home:+1 555 0199\r\n" +
+		"EMAIL;TYPE=internet:john@exam\r\n" +
+		" ple.com\r\n" +
+		"END:VCARD\r\n"
+	contacts, err := ParseVCard([]byte(vcard), "utf-8")
+	c.Assert(err, IsNil)
+	c.Assert(contacts, HasLen, 1)
+	contact := contacts[0]
+	c.Check(contact.FormattedName, Equals, "Dr. John Doe")
+	c.Check(contact.Name, DeepEquals, []string{"Doe", "John", "", "Dr.", ""})
+	c.Check(contact.Organization, Equals, "Example, Inc., Sales")
+	c.Check(contact.Phones, DeepEquals, []Phone{
+		Phone{Number: "+1 555 0100", Types: []string{"CELL", "VOICE"}},
+		Phone{Number: "+1 555 0199", Types: []string{"HOME"}},
+	})
+	c.Check(contact.Emails, DeepEquals, []string{"john@example.com"})
+	c.Check(contact.Summary(), Equals, "Dr. John Doe, +1 555 0100")
+}
+
+func (s *VObjectTestSuite) TestParseVCard21QuotedPrintable(c *C) {
+	vcard := "BEGIN:VCARD\r\n" +
+		"VERSION:2.1\r\n" +
+		"N;CHARSET=ISO-8859-1;ENCODING=QUOTED-PRINTABLE:Mu=F1oz;Jos=E9\r\n" +
+		"TEL;CELL:+34600000000\r\n" +
+		"END:VCARD\r\n"
+	contacts, err := ParseVCard([]byte(vcard), "")
+	c.Assert(err, IsNil)
+	c.Assert(contacts, HasLen, 1)
+	c.Check(contacts[0].Name, DeepEquals, []string{"Muñoz", "José"})
+	c.Check(contacts[0].DisplayName(), Equals, "José Muñoz")
+	c.Check(contacts[0].Phones[0].Types, DeepEquals, []string{"CELL"})
+	c.Check(contacts[0].Summary(), Equals, "José Muñoz, +34600000000")
+}
+
+func (s *VObjectTestSuite) TestParseVCardSoftLineBreak(c *C) {
+	vcard := "BEGIN:VCARD\r\n" +
+		"VERSION:2.1\r\n" +
+		"FN;ENCODING=QUOTED-PRINTABLE;CHARSET=UTF-8:Jo=C3=A3o =\r\n" +
+		"Silva\r\n" +
+		"END:VCARD\r\n"
+	contacts, err := ParseVCard([]byte(vcard), "")
+	c.Assert(err, IsNil)
+	c.Check(contacts[0].FormattedName, Equals, "João Silva")
+}
+
+func (s *VObjectTestSuite) TestParseVCardCharsets(c *C) {
+	latin1 := []byte("BEGIN:VCARD\nFN:Andr\xe9\nEND:VCARD\n")
+	contacts, err := ParseVCard(latin1, "iso-8859-1")
+	c.Assert(err, IsNil)
+	c.Check(contacts[0].FormattedName, Equals, "André")
+
+	utf16 := []byte{0xfe, 0xff}
+	for _, r := range "BEGIN:VCARD\nFN:Zoë\nEND:VCARD\n" {
+		utf16 = append(utf16, byte(r>>8), byte(r))
+	}
+	contacts, err = ParseVCard(utf16, "")
+	c.Assert(err, IsNil)
+	c.Check(contacts[0].FormattedName, Equals, "Zoë")
+
+	_, err = ParseVCard([]byte("BEGIN:VCARD\nFN:x\nEND:VCARD\n"), "koi8-r")
+	c.Check(err, NotNil)
+}
+
+func (s *VObjectTestSuite) TestParseVCardEmpty(c *C) {
+	_, err := ParseVCard([]byte("hello"), "")
+	c.Check(err, NotNil)
+}
+
+func (s *VObjectTestSuite) TestParseVCalendar(c *C) {
+	vcal := "BEGIN:VCALENDAR\r\n" +
+		"VERSION:1.0\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"SUMMARY:Team meeting\\, weekly\r\n" +
+		"LOCATION:Room 1\r\n" +
+		"DTSTART:20140330T181530Z\r\n" +
+		"DTEND:20140330T191530Z\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"SUMMARY:Holiday\r\n" +
+		"DTSTART;VALUE=DATE:20140401\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+	events, err := ParseVCalendar([]byte(vcal), "utf-8")
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 2)
+	c.Check(events[0].Title, Equals, "Team meeting, weekly")
+	c.Check(events[0].Location, Equals, "Room 1")
+	c.Check(events[0].Start.Equal(time.Date(2014, 3, 30, 18, 15, 30, 0, time.UTC)), Equals, true)
+	c.Check(events[0].End.Equal(time.Date(2014, 3, 30, 19, 15, 30, 0, time.UTC)), Equals, true)
+	c.Check(events[0].Summary(), Equals, "Team meeting, weekly, 2014-03-30T18:15:30Z")
+	c.Check(events[1].AllDay, Equals, true)
+	c.Check(events[1].Summary(), Equals, "Holiday, 2014-04-01")
+}
+
+func (s *VObjectTestSuite) TestParseVCalendarTZID(c *C) {
+	vcal := "BEGIN:VCALENDAR\nBEGIN:VEVENT\nSUMMARY:Call\nDTSTART;TZID=UTC:20140330T181530\nEND:VEVENT\nEND:VCALENDAR\n"
+	events, err := ParseVCalendar([]byte(vcal), "")
+	c.Assert(err, IsNil)
+	c.Check(events[0].Start.Equal(time.Date(2014, 3, 30, 18, 15, 30, 0, time.UTC)), Equals, true)
+}
+
+func (s *VObjectTestSuite) TestParseVCalendarBadDate(c *C) {
+	vcal := "BEGIN:VCALENDAR\nBEGIN:VEVENT\nDTSTART:tomorrow\nEND:VEVENT\nEND:VCALENDAR\n"
+	_, err := ParseVCalendar([]byte(vcal), "")
+	c.Check(err, NotNil)
+}
+
+func (s *VObjectTestSuite) TestAttachmentSummary(c *C) {
+	vcard := Attachment{
+		MediaType: "text/x-vCard;charset=utf-8",
+		Charset:   "utf-8",
+		Data:      []byte("BEGIN:VCARD\nFN:Jane\nTEL:+1234\nEND:VCARD\n"),
+	}
+	summary, ok := vcard.Summary()
+	c.Check(ok, Equals, true)
+	c.Check(summary, Equals, "Jane, +1234")
+
+	vcal := Attachment{
+		MediaType: "text/x-vCalendar",
+		Data:      []byte("BEGIN:VCALENDAR\nBEGIN:VEVENT\nSUMMARY:Lunch\nEND:VEVENT\nEND:VCALENDAR\n"),
+	}
+	summary, ok = vcal.Summary()
+	c.Check(ok, Equals, true)
+	c.Check(summary, Equals, "Lunch")
+
+	image := Attachment{MediaType: "image/jpeg", Data: []byte{0xff, 0xd8}}
+	_, ok = image.Summary()
+	c.Check(ok, Equals, false)
+}
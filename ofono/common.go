@@ -30,6 +30,7 @@ const (
 	CONNECTION_MANAGER_INTERFACE      = "org.ofono.ConnectionManager"
 	CONNECTION_CONTEXT_INTERFACE      = "org.ofono.ConnectionContext"
 	SIM_MANAGER_INTERFACE             = "org.ofono.SimManager"
+	NETWORK_REGISTRATION_INTERFACE    = "org.ofono.NetworkRegistration"
 	OFONO_MANAGER_INTERFACE           = "org.ofono.Manager"
 	OFONO_SENDER                      = "org.ofono"
 	MODEM_INTERFACE                   = "org.ofono.Modem"
@@ -71,6 +71,15 @@ func (s *ContextTestSuite) SetUpTest(c *C) {
 	}
 }
 
+func (s *ContextTestSuite) provision(identity string, settings ProvisionedSettings) {
+	s.modem.Provisioned = func(id string) (ProvisionedSettings, error) {
+		if id != identity {
+			return ProvisionedSettings{}, errors.New("no provisioned settings for identity")
+		}
+		return settings, nil
+	}
+}
+
 func (s *ContextTestSuite) TestNoContext(c *C) {
 	context, err := s.modem.GetMMSContexts("")
 	c.Check(context, IsNil)
@@ -368,3 +377,167 @@ func (s *ContextTestSuite) TestGetProxyNoPort(c *C) {
 	c.Assert(err, IsNil)
 	c.Check(p, DeepEquals, ProxyInfo{Host: proxy.Host, Port: 80})
 }
+
+func (s *ContextTestSuite) TestGetInterfaceAndNameservers(c *C) {
+	context := OfonoContext{
+		ObjectPath: "/ril_0/context1",
+		Properties: makeGenericContextProperty("Context1", contextTypeMMS, true, true, true, false),
+	}
+	m := make(map[interface{}]interface{})
+	iface := dbus.Variant{"rmnet1"}
+	nameservers := dbus.Variant{[]interface{}{"10.10.0.1", "10.10.0.2"}}
+	m["Interface"] = &iface
+	m["DomainNameServers"] = &nameservers
+	context.Properties["Settings"] = dbus.Variant{m}
+
+	c.Check(context.GetInterface(), Equals, "rmnet1")
+	c.Check(context.GetNameservers(), DeepEquals, []string{"10.10.0.1", "10.10.0.2"})
+}
+
+func (s *ContextTestSuite) TestGetInterfaceAndNameserversUnset(c *C) {
+	context := OfonoContext{
+		ObjectPath: "/ril_0/context1",
+		Properties: makeGenericContextProperty("Context1", contextTypeMMS, true, true, false, false),
+	}
+
+	c.Check(context.GetInterface(), Equals, "")
+	c.Check(context.GetNameservers(), HasLen, 0)
+}
+
+func (s *ContextTestSuite) TestGetProxyIPv6(c *C) {
+	context := OfonoContext{
+		ObjectPath: "/ril_0/context1",
+		Properties: makeGenericContextProperty("Context1", contextTypeMMS, true, true, false, false),
+	}
+	m := make(map[interface{}]interface{})
+	pr := dbus.Variant{"[2001:db8::1]"}
+	pr_pt := dbus.Variant{uint16(8080)}
+	m["Proxy"] = &pr
+	m["ProxyPort"] = &pr_pt
+	context.Properties["Settings"] = dbus.Variant{m}
+
+	p, err := context.GetProxy()
+	c.Assert(err, IsNil)
+	c.Check(p, DeepEquals, ProxyInfo{Host: "2001:db8::1", Port: 8080})
+	c.Check(p.String(), Equals, "[2001:db8::1]:8080")
+}
+
+func (s *ContextTestSuite) TestGetProxyIPv6OnlyContext(c *C) {
+	context := OfonoContext{
+		ObjectPath: "/ril_0/context1",
+		Properties: makeGenericContextProperty("Context1", contextTypeMMS, true, true, false, false),
+	}
+	context.Properties["MessageProxy"] = dbus.Variant{"[2001:db8::1]:8080"}
+	m := make(map[interface{}]interface{})
+	iface := dbus.Variant{"rmnet2"}
+	nameservers := dbus.Variant{[]interface{}{"2001:db8::53"}}
+	m["Interface"] = &iface
+	m["DomainNameServers"] = &nameservers
+	context.Properties["IPv6.Settings"] = dbus.Variant{m}
+
+	p, err := context.GetProxy()
+	c.Assert(err, IsNil)
+	c.Check(p, DeepEquals, ProxyInfo{Host: "2001:db8::1", Port: 8080})
+	c.Check(context.GetInterface(), Equals, "rmnet2")
+	c.Check(context.GetNameservers(), DeepEquals, []string{"2001:db8::53"})
+}
+
+func (s *ContextTestSuite) TestParseProxy(c *C) {
+	for _, t := range []struct {
+		proxy string
+		info  ProxyInfo
+	}{
+		{"10.0.0.1", ProxyInfo{Host: "10.0.0.1", Port: 80}},
+		{"10.0.0.1:8080", ProxyInfo{Host: "10.0.0.1", Port: 8080}},
+		{"proxy.example.com", ProxyInfo{Host: "proxy.example.com", Port: 80}},
+		{"proxy.example.com:8080", ProxyInfo{Host: "proxy.example.com", Port: 8080}},
+		{"2001:db8::1", ProxyInfo{Host: "2001:db8::1", Port: 80}},
+		{"[2001:db8::1]", ProxyInfo{Host: "2001:db8::1", Port: 80}},
+		{"[2001:db8::1]:8080", ProxyInfo{Host: "2001:db8::1", Port: 8080}},
+		{"http://user:secret@[2001:db8::1]:8080/", ProxyInfo{Host: "2001:db8::1", Port: 8080, Username: "user", Password: "secret"}},
+		{"user@10.0.0.1", ProxyInfo{Host: "10.0.0.1", Port: 80, Username: "user"}},
+	} {
+		info, err := parseProxy(t.proxy, 80)
+		c.Check(err, IsNil, Commentf(t.proxy))
+		c.Check(info, DeepEquals, t.info, Commentf(t.proxy))
+	}
+	_, err := parseProxy("10.0.0.1:http", 80)
+	c.Check(err, NotNil)
+}
+
+func (s *ContextTestSuite) TestGetProvisionedProxyCredentials(c *C) {
+	context := OfonoContext{
+		ObjectPath: "/ril_0/context1",
+		Properties: makeGenericContextProperty("Context1", contextTypeInternet, true, false, false, false),
+		provisionedSettings: &ProvisionedSettings{
+			MessageCenter: "http://mms.provisioned.com",
+			Proxy:         "2001:db8::1",
+			ProxyPort:     8080,
+			ProxyUsername: "mms",
+			ProxyPassword: "secret",
+		},
+	}
+
+	p, err := context.GetProxy()
+	c.Assert(err, IsNil)
+	c.Check(p, DeepEquals, ProxyInfo{Host: "2001:db8::1", Port: 8080, Username: "mms", Password: "secret"})
+}
+
+func (s *ContextTestSuite) TestMMSOverProvisionedInternet(c *C) {
+	s.modem.identity = "310150123456789"
+	s.provision(s.modem.identity, ProvisionedSettings{
+		MessageCenter: "http://mms.provisioned.com",
+		Proxy:         "10.0.0.1",
+		ProxyPort:     8080,
+		APN:           "internet.apn",
+	})
+	context1 := OfonoContext{
+		ObjectPath: "/ril_0/context1",
+		Properties: makeGenericContextProperty("Context1", contextTypeInternet, true, false, false, false),
+	}
+	context1.Properties["AccessPointName"] = dbus.Variant{"internet.apn"}
+	s.contexts = append(s.contexts, context1)
+
+	contexts, err := s.modem.GetMMSContexts("")
+	c.Assert(err, IsNil)
+	c.Assert(len(contexts), Equals, 1)
+	c.Check(contexts[0].ObjectPath, Equals, context1.ObjectPath)
+	c.Assert(contexts[0].provisionedSettings, NotNil)
+
+	mmsc, err := contexts[0].GetMessageCenter()
+	c.Assert(err, IsNil)
+	c.Check(mmsc, Equals, "http://mms.provisioned.com")
+	p, err := contexts[0].GetProxy()
+	c.Assert(err, IsNil)
+	c.Check(p, DeepEquals, ProxyInfo{Host: "10.0.0.1", Port: 8080})
+}
+
+func (s *ContextTestSuite) TestMMSOverProvisionedInternetOtherAPN(c *C) {
+	s.modem.identity = "310150123456789"
+	s.provision(s.modem.identity, ProvisionedSettings{
+		MessageCenter: "http://mms.provisioned.com",
+		APN:           "mms.apn",
+	})
+	context1 := OfonoContext{
+		ObjectPath: "/ril_0/context1",
+		Properties: makeGenericContextProperty("Context1", contextTypeInternet, true, false, false, false),
+	}
+	context1.Properties["AccessPointName"] = dbus.Variant{"internet.apn"}
+	s.contexts = append(s.contexts, context1)
+
+	contexts, err := s.modem.GetMMSContexts("")
+	c.Check(contexts, IsNil)
+	c.Assert(err, NotNil)
+}
+
+func (s *ContextTestSuite) TestGetMessageCenterPrefersContext(c *C) {
+	context := OfonoContext{
+		ObjectPath:          "/ril_0/context1",
+		Properties:          makeGenericContextProperty("Context1", contextTypeMMS, true, true, false, false),
+		provisionedSettings: &ProvisionedSettings{MessageCenter: "http://mms.provisioned.com"},
+	}
+
+	mmsc, err := context.GetMessageCenter()
+	c.Assert(err, IsNil)
+	c.Check(mmsc, Equals, "http://messagecenter.com")
+}
@@ -0,0 +1,216 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of nuntium.
+ *
+ * nuntium is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * nuntium is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package ofono
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/ubuntu-phonedations/nuntium/mms"
+)
+
+//Push application ids as assigned by OMNA in the WAP Push Application ID
+//registry, used in the X-Wap-Application-Id header
+const (
+	PUSH_APPLICATION_ANY       = 0x00 // x-wap-application:*
+	PUSH_APPLICATION_SIA       = 0x01 // x-wap-application:push.sia
+	PUSH_APPLICATION_WML_UA    = 0x02 // x-wap-application:wml.ua
+	PUSH_APPLICATION_WTA_UA    = 0x03 // x-wap-application:wta.ua
+	PUSH_APPLICATION_MMS_UA    = 0x04 // x-wap-application:mms.ua
+	PUSH_APPLICATION_SYNCML    = 0x05 // x-wap-application:push.syncml
+	PUSH_APPLICATION_LOC_UA    = 0x06 // x-wap-application:loc.ua
+	PUSH_APPLICATION_SYNCML_DM = 0x07 // x-wap-application:syncml.dm
+	PUSH_APPLICATION_DRM_UA    = 0x08 // x-wap-application:drm.ua
+	PUSH_APPLICATION_EMN_UA    = 0x09 // x-wap-application:emn.ua
+	PUSH_APPLICATION_WV_UA     = 0x0A // x-wap-application:wv.ua
+	PUSH_APPLICATION_ULP_UA    = 0x10 // x-oma-application:ulp.ua
+)
+
+//pushApplicationIds maps the textual application ids that can be sent
+//instead of the registered codes to them
+var pushApplicationIds = map[string]uint64{
+	"x-wap-application:*":           PUSH_APPLICATION_ANY,
+	"x-wap-application:push.sia":    PUSH_APPLICATION_SIA,
+	"x-wap-application:wml.ua":      PUSH_APPLICATION_WML_UA,
+	"x-wap-application:wta.ua":      PUSH_APPLICATION_WTA_UA,
+	"x-wap-application:mms.ua":      PUSH_APPLICATION_MMS_UA,
+	"x-wap-application:push.syncml": PUSH_APPLICATION_SYNCML,
+	"x-wap-application:loc.ua":      PUSH_APPLICATION_LOC_UA,
+	"x-wap-application:syncml.dm":   PUSH_APPLICATION_SYNCML_DM,
+	"x-wap-application:drm.ua":      PUSH_APPLICATION_DRM_UA,
+	"x-wap-application:emn.ua":      PUSH_APPLICATION_EMN_UA,
+	"x-wap-application:wv.ua":       PUSH_APPLICATION_WV_UA,
+	"x-oma-application:ulp.ua":      PUSH_APPLICATION_ULP_UA,
+}
+
+//applicationIdCode returns the registered code of an application id sent
+//as text so it is matched like the numeric one, any other value is returned
+//as is.
+func applicationIdCode(v interface{}) interface{} {
+	if id, ok := v.(string); ok {
+		if code, ok := pushApplicationIds[strings.ToLower(id)]; ok {
+			return code
+		}
+	}
+	return v
+}
+
+//Content types for the push applications nuntium knows about
+const (
+	CONTENT_TYPE_SI              = "text/vnd.wap.si"
+	CONTENT_TYPE_SIC             = "application/vnd.wap.sic"
+	CONTENT_TYPE_SL              = "text/vnd.wap.sl"
+	CONTENT_TYPE_SLC             = "application/vnd.wap.slc"
+	CONTENT_TYPE_CONNECTIVITY    = "text/vnd.wap.connectivity-xml"
+	CONTENT_TYPE_CONNECTIVITY_WB = "application/vnd.wap.connectivity-wbxml"
+	CONTENT_TYPE_SUPL_INIT       = "application/vnd.omaloc-supl-init"
+	CONTENT_TYPE_DRM_RIGHTS      = "application/vnd.oma.drm.rights+xml"
+	CONTENT_TYPE_DRM_RIGHTS_WB   = "application/vnd.oma.drm.rights+wbxml"
+)
+
+//PUSH_QUEUE_SIZE is the number of push PDUs that can be queued for a push
+//application before new ones are dropped
+const PUSH_QUEUE_SIZE = 16
+
+//ErrPushQueueFull is returned by Dispatch when a matching push application
+//could not take the PDU because its queue is full
+var ErrPushQueueFull = errors.New("push queue is full")
+
+//PushApplication describes which push PDUs a handler is interested in. An
+//ApplicationId of PUSH_APPLICATION_ANY matches any application id and no
+//ContentTypes matches any content type.
+type PushApplication struct {
+	Name          string
+	ApplicationId uint64
+	ContentTypes  []string
+}
+
+var (
+	MMSPushApplication = PushApplication{
+		Name:          "mms",
+		ApplicationId: mms.PUSH_APPLICATION_ID,
+		ContentTypes:  []string{mms.VND_WAP_MMS_MESSAGE},
+	}
+	//Client provisioning pushes are frequently sent without an
+	//application id so they are matched by content type only
+	ClientProvisioningPushApplication = PushApplication{
+		Name:          "client provisioning",
+		ApplicationId: PUSH_APPLICATION_ANY,
+		ContentTypes:  []string{CONTENT_TYPE_CONNECTIVITY, CONTENT_TYPE_CONNECTIVITY_WB},
+	}
+	//Service indication and loading pushes are usually sent without an
+	//application id, which defaults to wml.ua, so they are matched by
+	//content type only
+	ServiceIndicationPushApplication = PushApplication{
+		Name:          "service indication",
+		ApplicationId: PUSH_APPLICATION_ANY,
+		ContentTypes:  []string{CONTENT_TYPE_SI, CONTENT_TYPE_SIC, CONTENT_TYPE_SL, CONTENT_TYPE_SLC},
+	}
+	SUPLPushApplication = PushApplication{
+		Name:          "supl",
+		ApplicationId: PUSH_APPLICATION_ULP_UA,
+		ContentTypes:  []string{CONTENT_TYPE_SUPL_INIT},
+	}
+	DRMPushApplication = PushApplication{
+		Name:          "drm rights",
+		ApplicationId: PUSH_APPLICATION_DRM_UA,
+		ContentTypes:  []string{CONTENT_TYPE_DRM_RIGHTS, CONTENT_TYPE_DRM_RIGHTS_WB},
+	}
+)
+
+func (app PushApplication) matches(pdu *PushPDU) bool {
+	if app.ApplicationId != PUSH_APPLICATION_ANY && app.ApplicationId != pdu.ApplicationId {
+		return false
+	}
+	if len(app.ContentTypes) == 0 {
+		return true
+	}
+	for _, ct := range app.ContentTypes {
+		if ct == pdu.ContentType {
+			return true
+		}
+	}
+	return false
+}
+
+type pushSubscription struct {
+	app PushApplication
+	ch  chan *PushPDU
+}
+
+//PushDispatcher routes decoded push PDUs to the channels of the push
+//applications subscribed to them, each with its own queue so a slow
+//application does not hold back the others.
+type PushDispatcher struct {
+	lock          sync.Mutex
+	subscriptions []*pushSubscription
+}
+
+func NewPushDispatcher() *PushDispatcher {
+	return &PushDispatcher{}
+}
+
+//Subscribe returns a channel where the push PDUs matching app are delivered
+//until Unsubscribe is called with it.
+func (dispatcher *PushDispatcher) Subscribe(app PushApplication) chan *PushPDU {
+	dispatcher.lock.Lock()
+	defer dispatcher.lock.Unlock()
+	sub := &pushSubscription{app: app, ch: make(chan *PushPDU, PUSH_QUEUE_SIZE)}
+	dispatcher.subscriptions = append(dispatcher.subscriptions, sub)
+	return sub.ch
+}
+
+//Unsubscribe stops delivering to ch and closes it.
+func (dispatcher *PushDispatcher) Unsubscribe(ch chan *PushPDU) {
+	dispatcher.lock.Lock()
+	defer dispatcher.lock.Unlock()
+	for i, sub := range dispatcher.subscriptions {
+		if sub.ch == ch {
+			dispatcher.subscriptions = append(dispatcher.subscriptions[:i], dispatcher.subscriptions[i+1:]...)
+			close(ch)
+			return
+		}
+	}
+}
+
+//Dispatch delivers pdu to every matching push application and returns
+//false if there were none. ErrPushQueueFull is returned if pdu was dropped
+//for any of them.
+func (dispatcher *PushDispatcher) Dispatch(pdu *PushPDU) (handled bool, err error) {
+	dispatcher.lock.Lock()
+	defer dispatcher.lock.Unlock()
+	for _, sub := range dispatcher.subscriptions {
+		if !sub.app.matches(pdu) {
+			continue
+		}
+		handled = true
+		select {
+		case sub.ch <- pdu:
+		default:
+			log.Printf("Dropping push pdu for %s, queue is full", sub.app.Name)
+			err = ErrPushQueueFull
+		}
+	}
+	return handled, err
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of nuntium.
+ *
+ * nuntium is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * nuntium is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package ofono
+
+import (
+	"github.com/ubuntu-phonedations/nuntium/mms"
+	. "launchpad.net/gocheck"
+)
+
+type DispatcherTestSuite struct {
+	dispatcher *PushDispatcher
+}
+
+var _ = Suite(&DispatcherTestSuite{})
+
+func (s *DispatcherTestSuite) SetUpTest(c *C) {
+	s.dispatcher = NewPushDispatcher()
+}
+
+func (s *DispatcherTestSuite) TestDispatchByApplicationAndContentType(c *C) {
+	mmsCh := s.dispatcher.Subscribe(MMSPushApplication)
+	siCh := s.dispatcher.Subscribe(ServiceIndicationPushApplication)
+	cpCh := s.dispatcher.Subscribe(ClientProvisioningPushApplication)
+
+	mmsPdu := &PushPDU{ApplicationId: mms.PUSH_APPLICATION_ID, ContentType: mms.VND_WAP_MMS_MESSAGE}
+	siPdu := &PushPDU{ApplicationId: PUSH_APPLICATION_WML_UA, ContentType: CONTENT_TYPE_SIC}
+	cpPdu := &PushPDU{ContentType: CONTENT_TYPE_CONNECTIVITY_WB}
+
+	for _, pdu := range []*PushPDU{mmsPdu, siPdu, cpPdu} {
+		handled, err := s.dispatcher.Dispatch(pdu)
+		c.Check(err, IsNil)
+		c.Check(handled, Equals, true)
+	}
+
+	c.Check(<-mmsCh, Equals, mmsPdu)
+	c.Check(<-siCh, Equals, siPdu)
+	c.Check(<-cpCh, Equals, cpPdu)
+	c.Check(len(mmsCh)+len(siCh)+len(cpCh), Equals, 0)
+}
+
+func (s *DispatcherTestSuite) TestDispatchUnhandled(c *C) {
+	s.dispatcher.Subscribe(MMSPushApplication)
+	pdu := &PushPDU{ApplicationId: mms.PUSH_APPLICATION_ID, ContentType: CONTENT_TYPE_SIC}
+	handled, err := s.dispatcher.Dispatch(pdu)
+	c.Check(err, IsNil)
+	c.Check(handled, Equals, false)
+}
+
+func (s *DispatcherTestSuite) TestDispatchLargeApplicationId(c *C) {
+	s.dispatcher.Subscribe(MMSPushApplication)
+	pdu := &PushPDU{ApplicationId: 0x8004, ContentType: mms.VND_WAP_MMS_MESSAGE}
+	handled, err := s.dispatcher.Dispatch(pdu)
+	c.Check(err, IsNil)
+	c.Check(handled, Equals, false)
+}
+
+func (s *DispatcherTestSuite) TestDispatchServiceIndicationWithoutApplicationId(c *C) {
+	siCh := s.dispatcher.Subscribe(ServiceIndicationPushApplication)
+	for _, contentType := range []string{CONTENT_TYPE_SI, CONTENT_TYPE_SIC, CONTENT_TYPE_SL, CONTENT_TYPE_SLC} {
+		pdu := &PushPDU{ContentType: contentType}
+		handled, err := s.dispatcher.Dispatch(pdu)
+		c.Check(err, IsNil)
+		c.Check(handled, Equals, true)
+		c.Check(<-siCh, Equals, pdu)
+	}
+}
+
+func (s *DispatcherTestSuite) TestDispatchFullQueueDoesNotBlock(c *C) {
+	supl := s.dispatcher.Subscribe(SUPLPushApplication)
+	drm := s.dispatcher.Subscribe(DRMPushApplication)
+	suplPdu := &PushPDU{ApplicationId: PUSH_APPLICATION_ULP_UA, ContentType: CONTENT_TYPE_SUPL_INIT}
+	for i := 0; i < PUSH_QUEUE_SIZE; i++ {
+		_, err := s.dispatcher.Dispatch(suplPdu)
+		c.Check(err, IsNil)
+	}
+	handled, err := s.dispatcher.Dispatch(suplPdu)
+	c.Check(handled, Equals, true)
+	c.Check(err, Equals, ErrPushQueueFull)
+	c.Check(len(supl), Equals, PUSH_QUEUE_SIZE)
+
+	drmPdu := &PushPDU{ApplicationId: PUSH_APPLICATION_DRM_UA, ContentType: CONTENT_TYPE_DRM_RIGHTS_WB}
+	handled, err = s.dispatcher.Dispatch(drmPdu)
+	c.Check(err, IsNil)
+	c.Check(handled, Equals, true)
+	c.Check(<-drm, Equals, drmPdu)
+}
+
+func (s *DispatcherTestSuite) TestUnsubscribe(c *C) {
+	ch := s.dispatcher.Subscribe(MMSPushApplication)
+	s.dispatcher.Unsubscribe(ch)
+	_, ok := <-ch
+	c.Check(ok, Equals, false)
+	pdu := &PushPDU{ApplicationId: mms.PUSH_APPLICATION_ID, ContentType: mms.VND_WAP_MMS_MESSAGE}
+	handled, _ := s.dispatcher.Dispatch(pdu)
+	c.Check(handled, Equals, false)
+}
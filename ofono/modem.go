@@ -25,7 +25,10 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"reflect"
+	"strconv"
+	"strings"
 	"time"
 
 	"launchpad.net/go-dbus/v1"
@@ -46,8 +49,25 @@ const (
 type OfonoContext struct {
 	ObjectPath dbus.ObjectPath
 	Properties PropertiesType
+	//provisionedSettings are the settings received through client
+	//provisioning for the modem identity, if any
+	provisionedSettings *ProvisionedSettings
 }
 
+//ProvisionedSettings are the MMS settings received over the air with OMA
+//Client Provisioning, used for contexts that lack their own.
+type ProvisionedSettings struct {
+	MessageCenter string
+	Proxy         string
+	ProxyPort     uint64
+	ProxyUsername string
+	ProxyPassword string
+	APN           string
+}
+
+//ProvisionedSettingsLookup returns the provisioned settings for identity.
+type ProvisionedSettingsLookup func(identity string) (ProvisionedSettings, error)
+
 type Modem struct {
 	conn                   *dbus.Connection
 	Modem                  dbus.ObjectPath
@@ -60,20 +80,62 @@ type Modem struct {
 	pushInterfaceAvailable bool
 	online                 bool
 	modemSignal, simSignal *dbus.SignalWatch
+	//Provisioned is used by the MMS contexts to look up settings received
+	//through client provisioning, if set.
+	Provisioned ProvisionedSettingsLookup
 }
 
+//ProxyInfo is the MMS proxy of a context, Host may be an IPv6 literal
+//without brackets.
 type ProxyInfo struct {
-	Host string
-	Port uint64
+	Host     string
+	Port     uint64
+	Username string
+	Password string
 }
 
 const PROP_SETTINGS = "Settings"
+const PROP_IPV6_SETTINGS = "IPv6.Settings"
 const SETTINGS_PROXY = "Proxy"
 const SETTINGS_PROXYPORT = "ProxyPort"
+const SETTINGS_INTERFACE = "Interface"
+const SETTINGS_NAMESERVERS = "DomainNameServers"
 const DBUS_CALL_GET_PROPERTIES = "GetProperties"
 
 func (p ProxyInfo) String() string {
-	return fmt.Sprintf("%s:%d", p.Host, p.Port)
+	return net.JoinHostPort(p.Host, strconv.FormatUint(p.Port, 10))
+}
+
+//parseProxy parses a proxy given as a host name or an IP address, IPv6
+//ones with or without brackets, optionally followed by a port and preceded
+//by an http scheme and credentials.
+func parseProxy(proxy string, defaultPort uint64) (ProxyInfo, error) {
+	proxyInfo := ProxyInfo{Port: defaultPort}
+	proxy = strings.TrimSuffix(strings.TrimPrefix(proxy, "http://"), "/")
+	if i := strings.LastIndex(proxy, "@"); i != -1 {
+		userinfo := proxy[:i]
+		proxy = proxy[i+1:]
+		if j := strings.Index(userinfo, ":"); j != -1 {
+			proxyInfo.Username, proxyInfo.Password = userinfo[:j], userinfo[j+1:]
+		} else {
+			proxyInfo.Username = userinfo
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(proxy, "["), "]")); ip != nil {
+		proxyInfo.Host = ip.String()
+		return proxyInfo, nil
+	}
+	host, port, err := net.SplitHostPort(proxy)
+	if err != nil {
+		// a host name without a port
+		proxyInfo.Host = proxy
+		return proxyInfo, nil
+	}
+	if proxyInfo.Port, err = strconv.ParseUint(port, 10, 16); err != nil {
+		return ProxyInfo{}, fmt.Errorf("invalid port in proxy %s", proxy)
+	}
+	proxyInfo.Host = host
+	return proxyInfo, nil
 }
 
 func (oProp OfonoContext) String() string {
@@ -350,6 +412,16 @@ func (oContext OfonoContext) hasMessageCenter() bool {
 	return oContext.messageCenter() != ""
 }
 
+//isMessageCapable checks for a Message Center in the context or one
+//provisioned over the air for the context's access point.
+func (oContext OfonoContext) isMessageCapable() bool {
+	if oContext.hasMessageCenter() {
+		return true
+	}
+	settings, ok := oContext.provisioned()
+	return ok && settings.APN != "" && settings.MessageCenter != ""
+}
+
 func (oContext OfonoContext) messageCenter() string {
 	if v, ok := oContext.Properties["MessageCenter"]; ok {
 		return reflect.ValueOf(v.Value).String()
@@ -419,26 +491,113 @@ func (oContext OfonoContext) settingsProxyPort() uint64 {
 	return uint64(port)
 }
 
+//provisioned returns the settings received through client provisioning for
+//the context's identity if they apply to this context.
+func (oContext OfonoContext) provisioned() (ProvisionedSettings, bool) {
+	if oContext.provisionedSettings == nil {
+		return ProvisionedSettings{}, false
+	}
+	settings := *oContext.provisionedSettings
+	if settings.APN != "" && settings.APN != oContext.accessPointName() {
+		return settings, false
+	}
+	return settings, true
+}
+
+func (oContext OfonoContext) accessPointName() string {
+	if v, ok := oContext.Properties["AccessPointName"]; ok {
+		return reflect.ValueOf(v.Value).String()
+	}
+	return ""
+}
+
 func (oContext OfonoContext) GetMessageCenter() (string, error) {
 	if oContext.hasMessageCenter() {
 		return oContext.messageCenter(), nil
-	} else {
-		return "", errors.New("context setting for the Message Center value is empty")
 	}
+	if settings, ok := oContext.provisioned(); ok && settings.MessageCenter != "" {
+		log.Println("Using provisioned Message Center", settings.MessageCenter)
+		return settings.MessageCenter, nil
+	}
+	return "", errors.New("context setting for the Message Center value is empty")
 }
 
 func (oContext OfonoContext) GetProxy() (proxyInfo ProxyInfo, err error) {
 	proxy := oContext.settingsProxy()
+	// oFono only sets the proxy in the IPv4 settings, IPv6 only mms
+	// contexts just have it in MessageProxy
+	if proxy == "" && oContext.isTypeMMS() && oContext.messageProxy() != "" {
+		log.Println("Using the context MessageProxy", oContext.messageProxy())
+		return parseProxy(oContext.messageProxy(), 80)
+	}
 	// we need to support empty proxies
 	if proxy == "" {
+		if settings, ok := oContext.provisioned(); ok && settings.Proxy != "" && !oContext.hasMessageCenter() {
+			log.Println("Using provisioned proxy", settings.Proxy)
+			if proxyInfo, err = parseProxy(settings.Proxy, settings.ProxyPort); err != nil {
+				return proxyInfo, err
+			}
+			if settings.ProxyUsername != "" {
+				proxyInfo.Username = settings.ProxyUsername
+				proxyInfo.Password = settings.ProxyPassword
+			}
+			return proxyInfo, nil
+		}
 		log.Println("No proxy in ofono settings")
 		return proxyInfo, nil
 	}
 
-	proxyInfo.Host = proxy
-	proxyInfo.Port = oContext.settingsProxyPort()
+	return parseProxy(proxy, oContext.settingsProxyPort())
+}
 
-	return proxyInfo, nil
+//settingsValue returns the value for key in the context Settings, or in its
+//IPv6.Settings for IPv6 only contexts.
+func (oContext OfonoContext) settingsValue(key string) (interface{}, bool) {
+	for _, prop := range []string{PROP_SETTINGS, PROP_IPV6_SETTINGS} {
+		v, ok := oContext.Properties[prop]
+		if !ok {
+			continue
+		}
+		settings, ok := v.Value.(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+		value, ok := settings[key]
+		if !ok {
+			continue
+		}
+		if variant, ok := value.(*dbus.Variant); ok {
+			return variant.Value, true
+		}
+		return value, true
+	}
+	return nil, false
+}
+
+//GetInterface returns the network interface of the active context, or an
+//empty string if oFono did not set it.
+func (oContext OfonoContext) GetInterface() string {
+	v, _ := oContext.settingsValue(SETTINGS_INTERFACE)
+	iface, _ := v.(string)
+	return iface
+}
+
+//GetNameservers returns the DNS servers of the active context.
+func (oContext OfonoContext) GetNameservers() []string {
+	v, _ := oContext.settingsValue(SETTINGS_NAMESERVERS)
+	switch v := v.(type) {
+	case []string:
+		return v
+	case []interface{}:
+		var nameservers []string
+		for _, ns := range v {
+			if s, ok := ns.(string); ok {
+				nameservers = append(nameservers, s)
+			}
+		}
+		return nameservers
+	}
+	return nil
 }
 
 //GetMMSContexts returns the contexts that are MMS capable; by convention it has
@@ -447,7 +606,8 @@ func (oContext OfonoContext) GetProxy() (proxyInfo ProxyInfo, err error) {
 //
 //The following rules take place:
 //- if current type=internet context, check for MessageProxy & MessageCenter;
-//  if they exist and aren't empty AND the context is active, add it to the list
+//  if they exist and aren't empty AND the context is active, add it to the list;
+//  a MessageCenter provisioned over the air for the context's APN counts too
 //- if current type=mms, add it to the list
 //- if ofono's ConnectionManager.Preferred property is set, use only that context
 //- prioritize active and recently successfully used contexts
@@ -460,8 +620,15 @@ func (modem *Modem) GetMMSContexts(preferredContext dbus.ObjectPath) (mmsContext
 		return mmsContexts, err
 	}
 
+	var provisioned *ProvisionedSettings
+	if modem.identity != "" && modem.Provisioned != nil {
+		if settings, err := modem.Provisioned(modem.identity); err == nil {
+			provisioned = &settings
+		}
+	}
 	for _, context := range contexts {
-		if (context.isTypeInternet() && context.isActive() && context.hasMessageCenter()) || context.isTypeMMS() {
+		context.provisionedSettings = provisioned
+		if (context.isTypeInternet() && context.isActive() && context.isMessageCapable()) || context.isTypeMMS() {
 			if context.isPreferred() {
 				mmsContexts = []OfonoContext{context}
 				break
@@ -496,6 +663,25 @@ func (modem *Modem) getProperty(interfaceName, propertyName string) (*dbus.Varia
 	}
 }
 
+//Technology returns the access technology of the current network
+//registration, one of "gsm", "edge", "umts", "hspa" or "lte".
+func (modem *Modem) Technology() (string, error) {
+	v, err := modem.getProperty(NETWORK_REGISTRATION_INTERFACE, "Technology")
+	if err != nil {
+		return "", err
+	}
+	technology, ok := v.Value.(string)
+	if !ok {
+		return "", fmt.Errorf("unexpected Technology value %v", v.Value)
+	}
+	return technology, nil
+}
+
+//Identity returns the subscriber identity of the modem's SIM.
+func (modem *Modem) Identity() string {
+	return modem.identity
+}
+
 func (modem *Modem) Delete() {
 	if modem.identity != "" {
 		modem.IdentityRemoved <- modem.identity
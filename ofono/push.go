@@ -24,28 +24,41 @@ package ofono
 import (
 	"errors"
 	"fmt"
+	"log"
 	"reflect"
 
-	"github.com/ubuntu-phonedations/nuntium/mms"
+	"github.com/ubuntu-phonedations/nuntium/wsp"
 )
 
-type PDU byte
-
+//PushPDU holds a decoded WSP Push PDU as described in section 8.2.4.1 of
+//WAP-230-WSP-20010705-a.
+//
+//Every header in the header block is kept in Headers keyed by its name,
+//application headers use the name they were sent with; the most commonly
+//used ones are also set in their own member. The decoder only accepts
+//connectionless pushes so Type is always wsp.PUSH once decoded.
 type PushPDU struct {
-	HeaderLength                             uint64
-	ContentLength                            uint64
-	ApplicationId, EncodingVersion, PushFlag byte
-	ContentType                              string
-	Data                                     []byte
+	TransactionId             byte
+	Type                      wsp.PDU
+	HeaderLength              uint64
+	ContentLength             uint64
+	ApplicationId             uint64
+	EncodingVersion, PushFlag byte
+	ContentType               string
+	ContentTypeParams         map[string]string
+	InitiatorURI, ContentURI  string
+	Date                      uint64
+	Headers                   map[string]interface{}
+	Data                      []byte
 }
 
 type PushPDUDecoder struct {
-	mms.MMSDecoder
+	wsp.Decoder
 }
 
 func NewDecoder(data []byte) *PushPDUDecoder {
 	decoder := new(PushPDUDecoder)
-	decoder.MMSDecoder.Data = data
+	decoder.Data = data
 	return decoder
 }
 
@@ -57,56 +70,111 @@ func NewDecoder(data []byte) *PushPDUDecoder {
 // provided to and reported from the underlying transport. The Data field starts immediately after the Headers field and
 // ends at the end of the SDU.
 func (dec *PushPDUDecoder) Decode(pdu *PushPDU) (err error) {
-	if PDU(dec.Data[1]) != PUSH {
-		return errors.New(fmt.Sprintf("%x != %x is not a push PDU", PDU(dec.Data[1]), PUSH))
+	if len(dec.Data) < 3 {
+		return fmt.Errorf("push PDU too short with length %d", len(dec.Data))
+	}
+	if wsp.PDU(dec.Data[1]) != wsp.PUSH {
+		return errors.New(fmt.Sprintf("%x != %x is not a push PDU", wsp.PDU(dec.Data[1]), wsp.PUSH))
 	}
+	pdu.TransactionId, pdu.Type = dec.Data[0], wsp.PUSH
 	// Move offset +tid +type = +2
 	dec.Offset = 1
-	rValue := reflect.ValueOf(pdu).Elem()
-	if _, err = dec.ReadUintVar(&rValue, "HeaderLength"); err != nil {
+	if pdu.HeaderLength, err = dec.ReadUintVar(); err != nil {
 		return err
 	}
-	if err = dec.ReadMediaType(&rValue, "ContentType"); err != nil {
+	dataStart := dec.Offset + 1 + int(pdu.HeaderLength)
+	if dataStart > len(dec.Data) {
+		return fmt.Errorf("header length %d exceeds the push PDU length %d", pdu.HeaderLength, len(dec.Data))
+	}
+	if pdu.ContentType, pdu.ContentTypeParams, err = dec.ReadContentType(); err != nil {
 		return err
 	}
-	dec.Offset++
-	remainHeaders := int(pdu.HeaderLength) - dec.Offset + 3
-	if err = dec.decodeHeaders(pdu, remainHeaders); err != nil {
+	if err = dec.decodeHeaders(pdu, dataStart); err != nil {
 		return err
 	}
-	pdu.Data = dec.Data[(pdu.HeaderLength + 3):]
+	pdu.Data = dec.Data[dataStart:]
 	return nil
 }
 
-func (dec *PushPDUDecoder) decodeHeaders(pdu *PushPDU, hdrLengthRemain int) error {
+//decodeHeaders decodes the push headers up to the end offset. Well known
+//headers are decoded with the codecs from wsp.Headers, their values are also
+//stored in the PushPDU member named after the header Field; unknown well
+//known headers are kept as their raw value.
+func (dec *PushPDUDecoder) decodeHeaders(pdu *PushPDU, end int) error {
 	rValue := reflect.ValueOf(pdu).Elem()
-	var err error
-	for ; dec.Offset < (hdrLengthRemain + dec.Offset); dec.Offset++ {
-		param := dec.Data[dec.Offset] & 0x7F
-		switch param {
-		case X_WAP_APPLICATION_ID:
-			_, err = dec.ReadInteger(&rValue, "ApplicationId")
-		case PUSH_FLAG:
-			_, err = dec.ReadShortInteger(&rValue, "PushFlag")
-		case ENCODING_VERSION:
-			dec.Offset++
-			pdu.EncodingVersion = dec.Data[dec.Offset] & 0x7F
+	pdu.Headers = make(map[string]interface{})
+	for dec.Offset+1 < end {
+		next, err := dec.Peek()
+		if err != nil {
+			return err
+		}
+		switch {
+		case next == 0x7F:
+			// Shift-delimiter Page-identity, only the default code page is
+			// known so the page is ignored
+			dec.Offset += 2
+			log.Printf("Ignoring header code page shift to %d", dec.Data[dec.Offset])
+			continue
+		case next < wsp.TEXT_MIN:
+			// Short-cut-shift-delimiter
 			dec.Offset++
-		case CONTENT_LENGTH:
-			_, err = dec.ReadInteger(&rValue, "ContentLength")
-		case X_WAP_INITIATOR_URI:
-			var v string
-			v, err = dec.ReadString(nil, "")
-			fmt.Println("Unsaved value decoded:", v)
-		default:
-			err = fmt.Errorf("Unhandled header data %#x @%d", dec.Data[dec.Offset], dec.Offset)
+			log.Printf("Ignoring header code page shift to %d", next)
+			continue
+		case next <= wsp.TEXT_MAX:
+			if err := dec.decodeApplicationHeader(pdu); err != nil {
+				return err
+			}
+			continue
+		}
+		code, _ := dec.ReadShortInteger()
+		hdr, ok := wsp.Headers.Lookup(code)
+		if !ok {
+			v, err := wsp.Generic.Decode(&dec.Decoder)
+			if err != nil {
+				return fmt.Errorf("error while decoding header %#x @%d: %s", code, dec.Offset, err)
+			}
+			pdu.Headers[fmt.Sprintf("%#x", code)] = v
+			continue
 		}
+		v, err := hdr.Codec.Decode(&dec.Decoder)
 		if err != nil {
-			return fmt.Errorf("error while decoding %#x @%d: ", param, dec.Offset, err)
-		} else if pdu.ApplicationId != 0 {
-			return nil
+			return fmt.Errorf("error while decoding %s @%d: %s", hdr.Name, dec.Offset, err)
+		}
+		pdu.Headers[hdr.Name] = v
+		if hdr.Code == wsp.X_WAP_APPLICATION_ID {
+			v = applicationIdCode(v)
+		}
+		if _, err := wsp.SetField(rValue, hdr.Field, v); err != nil {
+			log.Printf("Keeping %s only in the header map: %s", hdr.Name, err)
 		}
+	}
+	if dec.Offset+1 != end {
+		return fmt.Errorf("push headers end @%d but header block ends @%d", dec.Offset+1, end)
+	}
+	return nil
+}
 
+//decodeApplicationHeader decodes an Application-header, which is a
+//Token-text field name followed by an Application-specific-value.
+func (dec *PushPDUDecoder) decodeApplicationHeader(pdu *PushPDU) error {
+	name, err := dec.ReadString()
+	if err != nil {
+		return err
+	}
+	value, err := dec.ReadString()
+	if err != nil {
+		return fmt.Errorf("error while decoding application header %s: %s", name, err)
+	}
+	if hdr, ok := wsp.Headers.LookupName(name); ok {
+		name = hdr.Name
+		var v interface{} = value
+		if hdr.Code == wsp.X_WAP_APPLICATION_ID {
+			v = applicationIdCode(value)
+		}
+		if _, err := wsp.SetField(reflect.ValueOf(pdu).Elem(), hdr.Field, v); err != nil {
+			log.Printf("Keeping %s only in the header map: %s", name, err)
+		}
 	}
+	pdu.Headers[name] = value
 	return nil
 }
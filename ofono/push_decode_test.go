@@ -223,3 +223,37 @@ func (s *PushDecodeTestSuite) TestDecodePlayPoland(c *C) {
 	c.Check(s.pdu.ContentType, Equals, mms.VND_WAP_MMS_MESSAGE)
 	c.Check(len(s.pdu.Data), Equals, 102)
 }
+
+func (s *PushDecodeTestSuite) TestDecodeAllHeaders(c *C) {
+	inputBytes := []byte{
+		0x01, 0x06, 0x50, 0x03, 0xbe, 0x81, 0xea, 0xb1, 0x2b, 0x31, 0x35, 0x35, 0x35,
+		0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x2f, 0x54, 0x59, 0x50, 0x45, 0x3d,
+		0x50, 0x4c, 0x4d, 0x4e, 0x00, 0xaf, 0x84, 0x92, 0x04, 0x53, 0x8f, 0x3a, 0x00,
+		0xb0, 0x68, 0x74, 0x74, 0x70, 0x3a, 0x2f, 0x2f, 0x6d, 0x6d, 0x73, 0x2e, 0x65,
+		0x78, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x78, 0x00,
+		0xb4, 0x80, 0xa7, 0x01, 0x05, 0x58, 0x2d, 0x56, 0x65, 0x6e, 0x64, 0x6f, 0x72,
+		0x00, 0x66, 0x6f, 0x6f, 0x00, 0x82, 0x0a,
+	}
+	dec := NewDecoder(inputBytes)
+	c.Assert(dec.Decode(s.pdu), IsNil)
+
+	c.Check(int(s.pdu.HeaderLength), Equals, 80)
+	c.Check(s.pdu.ContentType, Equals, mms.VND_WAP_MMS_MESSAGE)
+	c.Check(int(s.pdu.ApplicationId), Equals, mms.PUSH_APPLICATION_ID)
+	c.Check(s.pdu.InitiatorURI, Equals, "+15551234567/TYPE=PLMN")
+	c.Check(s.pdu.ContentURI, Equals, "http://mms.example.com/x")
+	c.Check(s.pdu.Date, Equals, uint64(1401895424))
+	c.Check(s.pdu.Headers["X-Wap-Initiator-URI"], Equals, "+15551234567/TYPE=PLMN")
+	c.Check(s.pdu.Headers["Push-Flag"], Equals, uint64(0))
+	c.Check(s.pdu.Headers["0x27"], DeepEquals, []byte{0x01, 0x05})
+	c.Check(s.pdu.Headers["X-Vendor"], Equals, "foo")
+	c.Check(s.pdu.Data, DeepEquals, []byte{0x82, 0x0a})
+}
+
+func (s *PushDecodeTestSuite) TestDecodeHeaderLengthOverflow(c *C) {
+	inputBytes := []byte{
+		0x01, 0x06, 0x10, 0xbe, 0xaf, 0x84,
+	}
+	dec := NewDecoder(inputBytes)
+	c.Assert(dec.Decode(s.pdu), NotNil)
+}
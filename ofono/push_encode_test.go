@@ -0,0 +1,146 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@canonical.com
+ *
+ * This file is part of mms.
+ *
+ * mms is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * mms is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package ofono
+
+import (
+	"bytes"
+
+	"github.com/ubuntu-phonedations/nuntium/mms"
+	"github.com/ubuntu-phonedations/nuntium/wsp"
+	. "launchpad.net/gocheck"
+)
+
+type PushEncodeTestSuite struct{}
+
+var _ = Suite(&PushEncodeTestSuite{})
+
+func (s *PushEncodeTestSuite) TestEncodeMMSPush(c *C) {
+	pdu := PushPDU{
+		TransactionId:   0x01,
+		ContentType:     mms.VND_WAP_MMS_MESSAGE,
+		ApplicationId:   mms.PUSH_APPLICATION_ID,
+		PushFlag:        0x06,
+		EncodingVersion: 0x15,
+		Data:            []byte{0x8c, 0x82},
+	}
+	var buf bytes.Buffer
+	c.Assert(NewEncoder(&buf).Encode(&pdu), IsNil)
+	c.Check(buf.Bytes(), DeepEquals, []byte{
+		0x01, 0x06, 0x07, 0xbe, 0xaf, 0x84, 0xb4, 0x86, 0xc3, 0x95, 0x8c, 0x82,
+	})
+	c.Check(pdu.HeaderLength, Equals, uint64(7))
+}
+
+func (s *PushEncodeTestSuite) TestEncodeConfirmedPush(c *C) {
+	pdu := PushPDU{
+		TransactionId: 0x2a,
+		Type:          wsp.CONFIRMED_PUSH,
+		ContentType:   CONTENT_TYPE_SIC,
+		Data:          []byte{0x02, 0x05},
+	}
+	var buf bytes.Buffer
+	c.Assert(NewEncoder(&buf).Encode(&pdu), IsNil)
+	c.Check(buf.Bytes(), DeepEquals, []byte{0x2a, 0x07, 0x01, 0xae, 0x02, 0x05})
+}
+
+func (s *PushEncodeTestSuite) TestEncodeNotAPush(c *C) {
+	var buf bytes.Buffer
+	c.Check(NewEncoder(&buf).Encode(&PushPDU{Type: wsp.GET}), NotNil)
+	c.Check(buf.Len(), Equals, 0)
+}
+
+func (s *PushEncodeTestSuite) TestRoundTripAllHeaders(c *C) {
+	inputBytes := []byte{
+		0x01, 0x06, 0x50, 0x03, 0xbe, 0x81, 0xea, 0xb1, 0x2b, 0x31, 0x35, 0x35, 0x35,
+		0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x2f, 0x54, 0x59, 0x50, 0x45, 0x3d,
+		0x50, 0x4c, 0x4d, 0x4e, 0x00, 0xaf, 0x84, 0x92, 0x04, 0x53, 0x8f, 0x3a, 0x00,
+		0xb0, 0x68, 0x74, 0x74, 0x70, 0x3a, 0x2f, 0x2f, 0x6d, 0x6d, 0x73, 0x2e, 0x65,
+		0x78, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x78, 0x00,
+		0xb4, 0x80, 0xa7, 0x01, 0x05, 0x58, 0x2d, 0x56, 0x65, 0x6e, 0x64, 0x6f, 0x72,
+		0x00, 0x66, 0x6f, 0x6f, 0x00, 0x82, 0x0a,
+	}
+	pdu := new(PushPDU)
+	c.Assert(NewDecoder(inputBytes).Decode(pdu), IsNil)
+
+	var buf bytes.Buffer
+	c.Assert(NewEncoder(&buf).Encode(pdu), IsNil)
+	c.Check(buf.Len(), Equals, len(inputBytes))
+
+	roundTrip := new(PushPDU)
+	c.Assert(NewDecoder(buf.Bytes()).Decode(roundTrip), IsNil)
+	c.Check(roundTrip, DeepEquals, pdu)
+}
+
+func (s *PushEncodeTestSuite) TestRoundTripContentTypeParams(c *C) {
+	pdu := &PushPDU{
+		ContentType:       CONTENT_TYPE_CONNECTIVITY_WB,
+		ContentTypeParams: map[string]string{"sec": "NETWPIN", "mac": "0A1B2C"},
+		Headers:           map[string]interface{}{"X-Wap-Application-Id": uint64(PUSH_APPLICATION_ANY)},
+		Data:              []byte{0x03, 0x0b},
+	}
+	var buf bytes.Buffer
+	c.Assert(NewEncoder(&buf).Encode(pdu), IsNil)
+
+	roundTrip := new(PushPDU)
+	c.Assert(NewDecoder(buf.Bytes()).Decode(roundTrip), IsNil)
+	c.Check(roundTrip.Type, Equals, wsp.PUSH)
+	c.Check(roundTrip.ContentType, Equals, CONTENT_TYPE_CONNECTIVITY_WB)
+	c.Check(roundTrip.ContentTypeParams, DeepEquals, pdu.ContentTypeParams)
+	c.Check(roundTrip.Headers["X-Wap-Application-Id"], Equals, uint64(PUSH_APPLICATION_ANY))
+	c.Check(roundTrip.Data, DeepEquals, pdu.Data)
+}
+
+func (s *PushEncodeTestSuite) TestRoundTripTextualApplicationId(c *C) {
+	pdu := &PushPDU{
+		ContentType: mms.VND_WAP_MMS_MESSAGE,
+		Headers:     map[string]interface{}{"X-Wap-Application-Id": "x-wap-application:mms.ua"},
+	}
+	var buf bytes.Buffer
+	c.Assert(NewEncoder(&buf).Encode(pdu), IsNil)
+
+	roundTrip := new(PushPDU)
+	c.Assert(NewDecoder(buf.Bytes()).Decode(roundTrip), IsNil)
+	c.Check(roundTrip.ApplicationId, Equals, uint64(PUSH_APPLICATION_MMS_UA))
+	c.Check(roundTrip.Headers["X-Wap-Application-Id"], Equals, "x-wap-application:mms.ua")
+}
+
+func (s *PushEncodeTestSuite) TestRoundTripLargeApplicationId(c *C) {
+	pdu := &PushPDU{
+		ContentType:   mms.VND_WAP_MMS_MESSAGE,
+		ApplicationId: 0x8004,
+	}
+	var buf bytes.Buffer
+	c.Assert(NewEncoder(&buf).Encode(pdu), IsNil)
+
+	roundTrip := new(PushPDU)
+	c.Assert(NewDecoder(buf.Bytes()).Decode(roundTrip), IsNil)
+	c.Check(roundTrip.ApplicationId, Equals, uint64(0x8004))
+}
+
+func (s *PushEncodeTestSuite) TestEncodeBadHeaderValue(c *C) {
+	pdu := &PushPDU{
+		ContentType: mms.VND_WAP_MMS_MESSAGE,
+		Headers:     map[string]interface{}{"X-Wap-Content-URI": 42},
+	}
+	var buf bytes.Buffer
+	c.Check(NewEncoder(&buf).Encode(pdu), NotNil)
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of nuntium.
+ *
+ * nuntium is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * nuntium is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package ofono
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ubuntu-phonedations/nuntium/wsp"
+)
+
+//pushFields are the PushPDU members written as well known headers, in the
+//order they are written.
+var pushFields = []string{"InitiatorURI", "ApplicationId", "Date", "ContentURI", "PushFlag", "EncodingVersion", "ContentLength"}
+
+//PushPDUEncoder writes a PushPDU as a WSP Push PDU, it is the inverse of
+//PushPDUDecoder.
+type PushPDUEncoder struct {
+	*wsp.Encoder
+}
+
+func NewEncoder(w io.Writer) *PushPDUEncoder {
+	return &PushPDUEncoder{wsp.NewEncoder(w)}
+}
+
+//Encode writes pdu as a connectionless push, or a confirmed one if pdu.Type
+//is wsp.CONFIRMED_PUSH; pdu.HeaderLength is set to the length of the
+//written header block.
+//
+//The non zero members that map to well known headers are written first,
+//followed by the entries in pdu.Headers that are not already covered by a
+//member: registered header names are written with their codec, unregistered
+//well known headers use the "%#x" form of their code with the raw value as
+//kept by the decoder and any other name is written as an application
+//header.
+func (enc *PushPDUEncoder) Encode(pdu *PushPDU) error {
+	pduType := pdu.Type
+	if pduType == 0 {
+		pduType = wsp.PUSH
+	}
+	if pduType != wsp.PUSH && pduType != wsp.CONFIRMED_PUSH {
+		return fmt.Errorf("%x is not a push PDU", pduType)
+	}
+
+	var headers bytes.Buffer
+	hdrEnc := &PushPDUEncoder{wsp.NewEncoder(&headers)}
+	if err := hdrEnc.WriteContentType(pdu.ContentType, pdu.ContentTypeParams); err != nil {
+		return err
+	}
+	if err := hdrEnc.encodeHeaders(pdu); err != nil {
+		return err
+	}
+	pdu.HeaderLength = uint64(headers.Len())
+
+	if err := enc.WriteByte(pdu.TransactionId); err != nil {
+		return err
+	}
+	if err := enc.WriteByte(byte(pduType)); err != nil {
+		return err
+	}
+	if err := enc.WriteUintVar(pdu.HeaderLength); err != nil {
+		return err
+	}
+	if err := enc.WriteBytes(headers.Bytes(), headers.Len()); err != nil {
+		return err
+	}
+	return enc.WriteBytes(pdu.Data, len(pdu.Data))
+}
+
+func (enc *PushPDUEncoder) encodeHeaders(pdu *PushPDU) error {
+	rValue := reflect.ValueOf(pdu).Elem()
+	written := make(map[string]bool)
+	for _, field := range pushFields {
+		hdr, ok := wsp.Headers.LookupField(field)
+		if !ok {
+			return fmt.Errorf("no header registered for %s", field)
+		}
+		f := rValue.FieldByName(field)
+		if isZero(f) {
+			continue
+		}
+		var v interface{}
+		switch f.Kind() {
+		case reflect.String:
+			v = f.String()
+		default:
+			v = f.Uint()
+		}
+		if err := enc.writeHeader(hdr, v); err != nil {
+			return err
+		}
+		written[hdr.Name] = true
+	}
+
+	names := make([]string, 0, len(pdu.Headers))
+	for name := range pdu.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if written[name] {
+			continue
+		}
+		v := pdu.Headers[name]
+		if hdr, ok := wsp.Headers.LookupName(name); ok {
+			if err := enc.writeHeader(hdr, v); err != nil {
+				return err
+			}
+			continue
+		}
+		if strings.HasPrefix(name, "0x") {
+			code, err := strconv.ParseUint(name, 0, 7)
+			raw, ok := v.([]byte)
+			if err != nil || !ok {
+				return fmt.Errorf("cannot encode header %s with value %v", name, v)
+			}
+			if err := enc.SetParam(byte(code)); err != nil {
+				return err
+			}
+			if err := enc.WriteBytes(raw, len(raw)); err != nil {
+				return err
+			}
+			continue
+		}
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("application header %s must be a string, not %T", name, v)
+		}
+		if err := enc.WriteString(name); err != nil {
+			return err
+		}
+		if err := enc.WriteString(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (enc *PushPDUEncoder) writeHeader(hdr *wsp.Header, v interface{}) (err error) {
+	defer func() {
+		// the codecs assert the type of the value they encode
+		if r := recover(); r != nil {
+			err = fmt.Errorf("cannot encode %s with value %v of type %T", hdr.Name, v, v)
+		}
+	}()
+	if err := enc.SetParam(hdr.Code); err != nil {
+		return err
+	}
+	return hdr.Codec.Encode(enc.Encoder, v)
+}
+
+func isZero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String() == ""
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	}
+	return false
+}
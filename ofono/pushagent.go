@@ -27,7 +27,6 @@ import (
 	"log"
 	"sync"
 
-	"github.com/ubuntu-phonedations/nuntium/mms"
 	"launchpad.net/go-dbus/v1"
 )
 
@@ -39,7 +38,10 @@ type OfonoPushNotification struct {
 	Info map[string]*dbus.Variant
 }
 
+//PushAgent receives push notifications from oFono and dispatches them to
+//the subscribed push applications, Push is the subscription for MMS.
 type PushAgent struct {
+	*PushDispatcher
 	conn           *dbus.Connection
 	modem          dbus.ObjectPath
 	Push           chan *PushPDU
@@ -49,7 +51,9 @@ type PushAgent struct {
 }
 
 func NewPushAgent(modem dbus.ObjectPath) *PushAgent {
-	return &PushAgent{modem: modem}
+	agent := &PushAgent{modem: modem, PushDispatcher: NewPushDispatcher()}
+	agent.Push = agent.Subscribe(MMSPushApplication)
+	return agent
 }
 
 func (agent *PushAgent) Register() (err error) {
@@ -71,7 +75,6 @@ func (agent *PushAgent) Register() (err error) {
 	if err != nil {
 		return fmt.Errorf("Cannot register agent for %s: %s", agent.modem, err)
 	}
-	agent.Push = make(chan *PushPDU)
 	agent.messageChannel = make(chan *dbus.Message)
 	go agent.watchDBusMethodCalls()
 	agent.conn.RegisterObjectPath(AGENT_TAG, agent.messageChannel)
@@ -102,8 +105,6 @@ func (agent *PushAgent) release() {
 	agent.Registered = false
 	//BUG this seems to not return, but I can't close the channel or panic
 	agent.conn.UnregisterObjectPath(AGENT_TAG)
-	close(agent.Push)
-	agent.Push = nil
 	close(agent.messageChannel)
 	agent.messageChannel = nil
 }
@@ -142,11 +143,12 @@ func (agent *PushAgent) notificationReceived(msg *dbus.Message) (reply *dbus.Mes
 			log.Print("Error ", err)
 			return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error", "DecodeError")
 		}
-		// TODO later switch on ApplicationId and ContentType to different channels
-		if pdu.ApplicationId == mms.PUSH_APPLICATION_ID && pdu.ContentType == mms.VND_WAP_MMS_MESSAGE {
-			agent.Push <- pdu
-		} else {
-			log.Print("Unhandled push pdu", pdu)
+		handled, err := agent.Dispatch(pdu)
+		if err != nil {
+			return dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.LimitsExceeded", err.Error())
+		}
+		if !handled {
+			log.Printf("Unhandled push pdu for application %#x with content type %s", pdu.ApplicationId, pdu.ContentType)
 		}
 		return dbus.NewMethodReturnMessage(msg)
 	}
@@ -0,0 +1,316 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of provisioning.
+ *
+ * provisioning is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * provisioning is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+//Package provisioning parses OMA Client Provisioning documents as described
+//in OMA-WAP-ProvCont and validates them according to OMA-WAP-ProvBoot.
+package provisioning
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ubuntu-phonedations/nuntium/wbxml"
+)
+
+//APPID_MMS is the application id for MMS settings as registered by OMNA
+const APPID_MMS = "w4"
+
+//Security methods as used in the SEC content type parameter
+const (
+	SEC_NETWPIN     = "NETWPIN"
+	SEC_USERPIN     = "USERPIN"
+	SEC_USERNETWPIN = "USERNETWPIN"
+	SEC_USERPINMAC  = "USERPINMAC"
+)
+
+var (
+	ErrNoSecurity    = errors.New("provisioning document is not authenticated")
+	ErrMACMismatch   = errors.New("provisioning document MAC does not match")
+	ErrNoMMSSettings = errors.New("provisioning document has no MMS application")
+)
+
+type Parm struct {
+	Name, Value string
+}
+
+type Characteristic struct {
+	Type            string
+	Parms           []Parm
+	Characteristics []*Characteristic
+}
+
+//Get returns the value of the first parm called name.
+func (c *Characteristic) Get(name string) string {
+	for _, parm := range c.Parms {
+		if parm.Name == name {
+			return parm.Value
+		}
+	}
+	return ""
+}
+
+//GetAll returns the values of all the parms called name.
+func (c *Characteristic) GetAll(name string) (values []string) {
+	for _, parm := range c.Parms {
+		if parm.Name == name {
+			values = append(values, parm.Value)
+		}
+	}
+	return values
+}
+
+//Find returns the nested characteristics of type charType.
+func (c *Characteristic) Find(charType string) (found []*Characteristic) {
+	for _, child := range c.Characteristics {
+		if child.Type == charType {
+			found = append(found, child)
+		}
+	}
+	return found
+}
+
+//ProvisioningDoc is the wap-provisioningdoc root, its characteristics are
+//held in the embedded Characteristic.
+type ProvisioningDoc struct {
+	Version string
+	Characteristic
+}
+
+//MMSSettings holds the MMS related settings found in a provisioning
+//document.
+type MMSSettings struct {
+	MessageCenter string
+	Proxy         string
+	ProxyPort     uint64
+	ProxyUsername string
+	ProxyPassword string
+	APN           string
+	Username      string
+	Password      string
+}
+
+//Parse decodes a WBXML encoded provisioning document.
+func Parse(data []byte) (*ProvisioningDoc, error) {
+	doc, err := wbxml.Decode(data, Language)
+	if err != nil {
+		return nil, err
+	}
+	if doc.Root.Name != "wap-provisioningdoc" {
+		return nil, fmt.Errorf("unexpected root element %s in provisioning document", doc.Root.Name)
+	}
+	provDoc := new(ProvisioningDoc)
+	provDoc.Version, _ = doc.Root.Attr("version")
+	if err := parseCharacteristic(doc.Root, &provDoc.Characteristic); err != nil {
+		return nil, err
+	}
+	return provDoc, nil
+}
+
+func parseCharacteristic(elem *wbxml.Element, c *Characteristic) error {
+	for _, child := range elem.Children {
+		switch child.Name {
+		case "characteristic":
+			nested := new(Characteristic)
+			nested.Type, _ = child.Attr("type")
+			if err := parseCharacteristic(child, nested); err != nil {
+				return err
+			}
+			c.Characteristics = append(c.Characteristics, nested)
+		case "parm":
+			name, ok := child.Attr("name")
+			if !ok {
+				return errors.New("parm without a name in provisioning document")
+			}
+			value, _ := child.Attr("value")
+			c.Parms = append(c.Parms, Parm{Name: name, Value: value})
+		default:
+			return fmt.Errorf("unexpected element %s in provisioning document", child.Name)
+		}
+	}
+	return nil
+}
+
+//MMSSettings returns the settings for the MMS application (APPID w4) and
+//the proxy and network access point it refers to.
+func (doc *ProvisioningDoc) MMSSettings() (*MMSSettings, error) {
+	var app *Characteristic
+	for _, c := range doc.Find("APPLICATION") {
+		if c.Get("APPID") == APPID_MMS {
+			app = c
+			break
+		}
+	}
+	if app == nil {
+		return nil, ErrNoMMSSettings
+	}
+
+	settings := &MMSSettings{MessageCenter: app.Get("ADDR")}
+	if settings.MessageCenter == "" {
+		for _, appAddr := range app.Find("APPADDR") {
+			if settings.MessageCenter = appAddr.Get("ADDR"); settings.MessageCenter != "" {
+				break
+			}
+		}
+	}
+	if settings.MessageCenter == "" {
+		return nil, errors.New("MMS application in provisioning document has no message center")
+	}
+
+	napIds := app.GetAll("TO-NAPID")
+	if proxyId := app.Get("TO-PROXY"); proxyId != "" {
+		proxy := doc.findProxy(proxyId)
+		if proxy == nil {
+			return nil, fmt.Errorf("proxy %s referenced by the MMS application is not defined", proxyId)
+		}
+		for _, auth := range proxy.Find("PXAUTHINFO") {
+			settings.ProxyUsername = auth.Get("PXAUTH-ID")
+			settings.ProxyPassword = auth.Get("PXAUTH-PW")
+		}
+		for _, physical := range proxy.Find("PXPHYSICAL") {
+			settings.Proxy = physical.Get("PXADDR")
+			settings.ProxyPort = proxyPort(physical, proxy)
+			napIds = append(physical.GetAll("TO-NAPID"), napIds...)
+			break
+		}
+	}
+
+	for _, napId := range napIds {
+		if nap := doc.findNAP(napId); nap != nil {
+			settings.APN = nap.Get("NAP-ADDRESS")
+			for _, auth := range nap.Find("NAPAUTHINFO") {
+				settings.Username = auth.Get("AUTHNAME")
+				settings.Password = auth.Get("AUTHSECRET")
+			}
+			break
+		}
+	}
+	return settings, nil
+}
+
+func (doc *ProvisioningDoc) findProxy(proxyId string) *Characteristic {
+	for _, proxy := range doc.Find("PXLOGICAL") {
+		if proxy.Get("PROXY-ID") == proxyId {
+			return proxy
+		}
+	}
+	return nil
+}
+
+func (doc *ProvisioningDoc) findNAP(napId string) *Characteristic {
+	for _, nap := range doc.Find("NAPDEF") {
+		if nap.Get("NAPID") == napId {
+			return nap
+		}
+	}
+	return nil
+}
+
+//proxyPort returns the first PORTNBR defined for the physical proxy or
+//its logical proxy, defaulting to 80.
+func proxyPort(proxies ...*Characteristic) uint64 {
+	for _, proxy := range proxies {
+		for _, port := range proxy.Find("PORT") {
+			if n, err := strconv.ParseUint(port.Get("PORTNBR"), 10, 16); err == nil {
+				return n
+			}
+		}
+	}
+	return 80
+}
+
+//Validate checks the MAC of a provisioning document as described in
+//section 5.3 of OMA-WAP-ProvBoot. sec and mac are the content type
+//parameters the document was delivered with, imsi is used for the network
+//shared secret and pin is the one entered by the user.
+func Validate(data []byte, sec, mac, imsi, pin string) error {
+	var key []byte
+	switch strings.ToUpper(sec) {
+	case "":
+		return ErrNoSecurity
+	case SEC_NETWPIN:
+		imsiKey, err := imsiToKey(imsi)
+		if err != nil {
+			return err
+		}
+		key = imsiKey
+	case SEC_USERPIN:
+		if pin == "" {
+			return errors.New("provisioning document requires a user PIN")
+		}
+		key = []byte(pin)
+	case SEC_USERNETWPIN:
+		if pin == "" {
+			return errors.New("provisioning document requires a user PIN")
+		}
+		imsiKey, err := imsiToKey(imsi)
+		if err != nil {
+			return err
+		}
+		key = append(imsiKey, []byte(pin)...)
+	default:
+		return fmt.Errorf("unsupported provisioning security method %s", sec)
+	}
+
+	expected, err := hex.DecodeString(mac)
+	if err != nil {
+		return fmt.Errorf("invalid provisioning MAC %s: %s", mac, err)
+	}
+	h := hmac.New(sha1.New, key)
+	h.Write(data)
+	if !hmac.Equal(h.Sum(nil), expected) {
+		return ErrMACMismatch
+	}
+	return nil
+}
+
+//imsiToKey converts the IMSI to the semi-octet representation used for
+//EF_IMSI in 3GPP TS 31.102 without the length byte, the first nibble holds
+//the parity and the result is padded with 0xF.
+func imsiToKey(imsi string) ([]byte, error) {
+	if imsi == "" {
+		return nil, errors.New("cannot validate a NETWPIN provisioning document without an IMSI")
+	}
+	nibbles := make([]byte, 0, len(imsi)+2)
+	if len(imsi)%2 == 0 {
+		nibbles = append(nibbles, 0x1)
+	} else {
+		nibbles = append(nibbles, 0x9)
+	}
+	for _, r := range imsi {
+		if r < '0' || r > '9' {
+			return nil, fmt.Errorf("invalid IMSI %s", imsi)
+		}
+		nibbles = append(nibbles, byte(r-'0'))
+	}
+	if len(nibbles)%2 != 0 {
+		nibbles = append(nibbles, 0xF)
+	}
+	key := make([]byte, len(nibbles)/2)
+	for i := range key {
+		key[i] = nibbles[2*i] | nibbles[2*i+1]<<4
+	}
+	return key, nil
+}
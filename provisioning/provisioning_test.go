@@ -0,0 +1,186 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of provisioning.
+ *
+ * provisioning is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * provisioning is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package provisioning
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	. "launchpad.net/gocheck"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type ProvisioningTestSuite struct{}
+
+var _ = Suite(&ProvisioningTestSuite{})
+
+func inline(s string) []byte {
+	return append(append([]byte{0x03}, s...), 0x00)
+}
+
+func parm(attrStart byte, value string) []byte {
+	return append(append([]byte{0x87, attrStart, 0x06}, inline(value)...), 0x01)
+}
+
+func concat(parts ...[]byte) (b []byte) {
+	for _, part := range parts {
+		b = append(b, part...)
+	}
+	return b
+}
+
+//mmsProvisioningDoc is the WBXML encoding of a provisioning document with a
+//NAPDEF, a proxy referring to it and the MMS application using the proxy.
+var mmsProvisioningDoc = concat(
+	[]byte{0x03, PUBLIC_ID, 0x6A, 0x00},
+	[]byte{0xC5, 0x46, 0x01},
+	[]byte{0xC6, 0x55, 0x01},
+	parm(0x11, "NAP1"),
+	parm(0x08, "mms.apn"),
+	[]byte{0x01},
+	[]byte{0xC6, 0x51, 0x01},
+	parm(0x15, "PX1"),
+	[]byte{0xC6, 0x52, 0x01},
+	parm(0x20, "10.0.0.1"),
+	parm(0x22, "NAP1"),
+	[]byte{0xC6, 0x53, 0x01},
+	parm(0x23, "8080"),
+	[]byte{0x01, 0x01, 0x01},
+	[]byte{0xC6, 0x00, 0x01, 0x55, 0x01},
+	parm(0x36, APPID_MMS),
+	parm(0x39, "PX1"),
+	parm(0x34, "http://mms.example.com"),
+	[]byte{0x01},
+	[]byte{0x01},
+)
+
+func (s *ProvisioningTestSuite) TestParse(c *C) {
+	doc, err := Parse(mmsProvisioningDoc)
+	c.Assert(err, IsNil)
+	c.Check(doc.Version, Equals, "1.0")
+	c.Assert(doc.Characteristics, HasLen, 3)
+	c.Check(doc.Characteristics[0].Type, Equals, "NAPDEF")
+	c.Check(doc.Characteristics[0].Get("NAP-ADDRESS"), Equals, "mms.apn")
+	physical := doc.Characteristics[1].Find("PXPHYSICAL")
+	c.Assert(physical, HasLen, 1)
+	c.Check(physical[0].Get("PXADDR"), Equals, "10.0.0.1")
+	c.Check(doc.Characteristics[2].Type, Equals, "APPLICATION")
+	c.Check(doc.Characteristics[2].Get("APPID"), Equals, APPID_MMS)
+}
+
+func (s *ProvisioningTestSuite) TestMMSSettings(c *C) {
+	doc, err := Parse(mmsProvisioningDoc)
+	c.Assert(err, IsNil)
+	settings, err := doc.MMSSettings()
+	c.Assert(err, IsNil)
+	c.Check(*settings, DeepEquals, MMSSettings{
+		MessageCenter: "http://mms.example.com",
+		Proxy:         "10.0.0.1",
+		ProxyPort:     8080,
+		APN:           "mms.apn",
+	})
+}
+
+func (s *ProvisioningTestSuite) TestMMSSettingsProxyAuth(c *C) {
+	doc, err := Parse(concat(
+		[]byte{0x03, PUBLIC_ID, 0x6A, 0x00},
+		[]byte{0xC5, 0x46, 0x01},
+		[]byte{0xC6, 0x51, 0x01},
+		parm(0x15, "PX1"),
+		[]byte{0xC6, 0x59, 0x01},
+		parm(0x1A, "mms"),
+		parm(0x1B, "secret"),
+		[]byte{0x01},
+		[]byte{0xC6, 0x52, 0x01},
+		parm(0x20, "2001:db8::1"),
+		[]byte{0x01, 0x01},
+		[]byte{0xC6, 0x00, 0x01, 0x55, 0x01},
+		parm(0x36, APPID_MMS),
+		parm(0x39, "PX1"),
+		parm(0x34, "http://mms.example.com"),
+		[]byte{0x01},
+		[]byte{0x01},
+	))
+	c.Assert(err, IsNil)
+	settings, err := doc.MMSSettings()
+	c.Assert(err, IsNil)
+	c.Check(*settings, DeepEquals, MMSSettings{
+		MessageCenter: "http://mms.example.com",
+		Proxy:         "2001:db8::1",
+		ProxyPort:     80,
+		ProxyUsername: "mms",
+		ProxyPassword: "secret",
+	})
+}
+
+func (s *ProvisioningTestSuite) TestMMSSettingsNoApplication(c *C) {
+	doc, err := Parse(concat(
+		[]byte{0x03, PUBLIC_ID, 0x6A, 0x00},
+		[]byte{0xC5, 0x46, 0x01},
+		[]byte{0xC6, 0x55, 0x01},
+		parm(0x11, "NAP1"),
+		[]byte{0x01, 0x01},
+	))
+	c.Assert(err, IsNil)
+	_, err = doc.MMSSettings()
+	c.Check(err, Equals, ErrNoMMSSettings)
+}
+
+func (s *ProvisioningTestSuite) TestParseWrongRoot(c *C) {
+	_, err := Parse([]byte{0x03, PUBLIC_ID, 0x6A, 0x00, 0x06})
+	c.Check(err, NotNil)
+}
+
+func (s *ProvisioningTestSuite) TestIMSIToKey(c *C) {
+	key, err := imsiToKey("234150123456789")
+	c.Assert(err, IsNil)
+	c.Check(key, DeepEquals, []byte{0x29, 0x43, 0x51, 0x10, 0x32, 0x54, 0x76, 0x98})
+	key, err = imsiToKey("23415012345678")
+	c.Assert(err, IsNil)
+	c.Check(key, DeepEquals, []byte{0x21, 0x43, 0x51, 0x10, 0x32, 0x54, 0x76, 0xF8})
+}
+
+func mac(key []byte, data []byte) string {
+	h := hmac.New(sha1.New, key)
+	h.Write(data)
+	return strings.ToUpper(hex.EncodeToString(h.Sum(nil)))
+}
+
+func (s *ProvisioningTestSuite) TestValidateNETWPIN(c *C) {
+	key, _ := imsiToKey("234150123456789")
+	c.Check(Validate(mmsProvisioningDoc, SEC_NETWPIN, mac(key, mmsProvisioningDoc), "234150123456789", ""), IsNil)
+	c.Check(Validate(mmsProvisioningDoc, SEC_NETWPIN, mac(key, mmsProvisioningDoc), "234150123456780", ""), Equals, ErrMACMismatch)
+}
+
+func (s *ProvisioningTestSuite) TestValidateUSERPIN(c *C) {
+	sum := mac([]byte("1234"), mmsProvisioningDoc)
+	c.Check(Validate(mmsProvisioningDoc, SEC_USERPIN, sum, "", "1234"), IsNil)
+	c.Check(Validate(mmsProvisioningDoc, SEC_USERPIN, sum, "", ""), NotNil)
+}
+
+func (s *ProvisioningTestSuite) TestValidateUnauthenticated(c *C) {
+	c.Check(Validate(mmsProvisioningDoc, "", "", "234150123456789", ""), Equals, ErrNoSecurity)
+}
@@ -38,4 +38,5 @@ const (
 	RECEIVED     = "received"
 	DRAFT        = "draft"
 	SENT         = "sent"
+	CANCELED     = "canceled"
 )
@@ -21,6 +21,8 @@
 
 package storage
 
+import "time"
+
 //SendInfo is a map where every key is a destination and the value can be any of:
 //
 // - "none": no report has been received yet.
@@ -43,12 +45,36 @@ type SendInfo map[string]string
 // - "received": m-Retrieve.Conf PDU downloaded and successfully acknowledged.
 // - "draft": m-Send.Req PDU ready for sending.
 // - "sent": m-Send.Req PDU successfully sent.
+// - "canceled": m-Send.Req PDU not sent as the user canceled it.
+//
+// Identity is the modem identity the message belongs to, it is empty for
+// messages stored before it was recorded
 //
 // SendState contains the sent state for each delivered message associated to
 // a particular MMS
+//
+// Received and Size track the download of a notification, Size is -1 when
+// the message center did not tell the length of the content
+//
+// Attempts holds the outcome of every transfer attempt, NextAttempt is when
+// the transfer is retried and Expires when the message expires on the message
+// center, they are nil if unset
 type MMSState struct {
 	Id              string
+	Identity        string `json:",omitempty"`
 	State           string
 	ContentLocation string
 	SendState       SendInfo
+	Received        int64      `json:",omitempty"`
+	Size            int64      `json:",omitempty"`
+	Attempts        []Attempt  `json:",omitempty"`
+	NextAttempt     *time.Time `json:",omitempty"`
+	Expires         *time.Time `json:",omitempty"`
+}
+
+//Attempt is the outcome of a transfer attempt, Error is empty if it
+//succeeded.
+type Attempt struct {
+	Time  time.Time
+	Error string `json:",omitempty"`
 }
@@ -0,0 +1,107 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of telepathy.
+ *
+ * mms is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * mms is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"launchpad.net/go-xdg/v0"
+)
+
+var provisioningPath string = filepath.Join(filepath.Base(os.Args[0]), "provisioning")
+
+var provisioningMutex sync.Mutex
+
+//ProvisionedSettings are the MMS settings received over the air with OMA
+//Client Provisioning for a given identity.
+type ProvisionedSettings struct {
+	MessageCenter string
+	Proxy         string
+	ProxyPort     uint64
+	ProxyUsername string `json:",omitempty"`
+	ProxyPassword string `json:",omitempty"`
+	APN           string
+}
+
+type provisioningMap map[string]ProvisionedSettings
+
+func SetProvisionedSettings(identity string, settings ProvisionedSettings) error {
+	provisioningMutex.Lock()
+	defer provisioningMutex.Unlock()
+
+	storePath, err := xdg.Data.Ensure(provisioningPath)
+	if err != nil {
+		return err
+	}
+	ps, err := readProvisioning(storePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	ps[identity] = settings
+
+	file, err := os.Create(storePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	if err := json.NewEncoder(w).Encode(ps); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func GetProvisionedSettings(identity string) (settings ProvisionedSettings, err error) {
+	provisioningMutex.Lock()
+	defer provisioningMutex.Unlock()
+
+	storePath, err := xdg.Data.Find(provisioningPath)
+	if err != nil {
+		return settings, err
+	}
+	ps, err := readProvisioning(storePath)
+	if err != nil {
+		return settings, err
+	}
+	if s, ok := ps[identity]; ok {
+		return s, nil
+	}
+	return settings, errors.New("no provisioned settings for identity")
+}
+
+func readProvisioning(storePath string) (provisioningMap, error) {
+	ps := make(provisioningMap)
+	file, err := os.Open(storePath)
+	if err != nil {
+		return ps, err
+	}
+	defer file.Close()
+	if err := json.NewDecoder(file).Decode(&ps); err != nil {
+		return make(provisioningMap), err
+	}
+	return ps, nil
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of nuntium.
+ *
+ * nuntium is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * nuntium is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"launchpad.net/go-xdg/v0"
+)
+
+var settingsPath string = filepath.Join(filepath.Base(os.Args[0]), "settings")
+
+var settingsMutex sync.Mutex
+
+//Settings are the user configured settings for a given identity, or for
+//all the identities of an operator when stored under its MCC and MNC.
+type Settings struct {
+	//MaxMessageSize is the largest m-send.req the operator accepts, image
+	//attachments are adapted to fit it when set
+	MaxMessageSize uint64
+	//ContentClass is the OMA MMS content class outgoing messages must
+	//conform to, one of Text, ImageBasic, ImageRich, VideoBasic or VideoRich
+	ContentClass string
+	//MaxRecipients is the largest number of recipients per message
+	MaxRecipients int
+	//DownloadTimeout and UploadTimeout are the seconds a whole transfer may
+	//take and InactivityTimeout the seconds it may go without any data, the
+	//defaults scaled to the access technology are used when unset
+	DownloadTimeout   uint64
+	UploadTimeout     uint64
+	InactivityTimeout uint64
+	//UserAgent and UAProf are sent as the User-Agent and x-wap-profile
+	//headers of MMS transfers and Headers as additional ones, the defaults
+	//are used when unset
+	UserAgent string
+	UAProf    string
+	Headers   map[string]string
+	//ProxyUsername and ProxyPassword authenticate with the MMS proxy,
+	//replacing any credentials from the context or provisioning
+	ProxyUsername string
+	ProxyPassword string
+	//TLSCAFile is a PEM bundle of certificates trusted for HTTPS MMSCs
+	//besides the system ones, TLSPins base64 encoded SHA-256 hashes of
+	//subject public key infos the MMSC chain must hold one of and
+	//TLSMinVersion the lowest TLS version accepted, such as "1.2"
+	TLSCAFile     string
+	TLSPins       []string
+	TLSMinVersion string
+}
+
+type settingsMap map[string]Settings
+
+func SetSettings(identity string, settings Settings) error {
+	settingsMutex.Lock()
+	defer settingsMutex.Unlock()
+
+	storePath, err := xdg.Config.Ensure(settingsPath)
+	if err != nil {
+		return err
+	}
+	ss, err := readSettings(storePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	ss[identity] = settings
+
+	file, err := os.Create(storePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	if err := json.NewEncoder(w).Encode(ss); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func GetSettings(identity string) (settings Settings, err error) {
+	settingsMutex.Lock()
+	defer settingsMutex.Unlock()
+
+	storePath, err := xdg.Config.Find(settingsPath)
+	if err != nil {
+		return settings, err
+	}
+	ss, err := readSettings(storePath)
+	if err != nil {
+		return settings, err
+	}
+	for _, key := range settingsKeys(identity) {
+		if s, ok := ss[key]; ok {
+			return s, nil
+		}
+	}
+	return settings, errors.New("no settings for identity")
+}
+
+//settingsKeys returns the keys settings for identity are looked up with,
+//the identity followed by the MCC and MNC it starts with so settings can be
+//stored for a whole operator. MNCs are 2 or 3 digits long.
+func settingsKeys(identity string) []string {
+	keys := []string{identity}
+	if len(identity) > 6 {
+		keys = append(keys, identity[:6], identity[:5])
+	}
+	return keys
+}
+
+func readSettings(storePath string) (settingsMap, error) {
+	ss := make(settingsMap)
+	file, err := os.Open(storePath)
+	if err != nil {
+		return ss, err
+	}
+	defer file.Close()
+	if err := json.NewDecoder(file).Decode(&ss); err != nil {
+		return make(settingsMap), err
+	}
+	return ss, nil
+}
@@ -22,41 +22,55 @@
 package storage
 
 import (
-	"bufio"
-	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"time"
 
+	"github.com/ubuntu-phonedations/nuntium/mms"
+	bolt "go.etcd.io/bbolt"
 	"launchpad.net/go-xdg/v0"
 )
 
 const SUBPATH = "nuntium/store"
 
-func Create(uuid, contentLocation string) error {
+//Create stores a new notification for the modem identity, expires is when
+//the message expires on the message center and is not stored if zero.
+func Create(identity, uuid, contentLocation string, expires time.Time) error {
 	state := MMSState{
+		Identity:        identity,
 		State:           NOTIFICATION,
 		ContentLocation: contentLocation,
 	}
-	storePath, err := xdg.Data.Ensure(path.Join(SUBPATH, uuid+".db"))
-	if err != nil {
-		return err
+	if !expires.IsZero() {
+		state.Expires = &expires
 	}
-	return writeState(state, storePath)
+	return updateDB(func(tx *bolt.Tx) error {
+		return putState(tx, uuid, state)
+	})
 }
 
 func Destroy(uuid string) error {
-	if storePath, err := xdg.Data.Ensure(path.Join(SUBPATH, uuid+".db")); err == nil {
-		if err := os.Remove(storePath); err != nil {
+	if err := updateDB(func(tx *bolt.Tx) error {
+		return deleteState(tx, uuid)
+	}); err != nil {
+		return err
+	}
+	if partPath, err := DownloadFile(uuid); err == nil {
+		if err := os.Remove(partPath); err != nil && !os.IsNotExist(err) {
 			return err
 		}
 	} else {
 		return err
 	}
+	// notifications that were never downloaded have no mms file
 	if mmsPath, err := GetMMS(uuid); err == nil {
 		if err := os.Remove(mmsPath); err != nil {
 			return err
 		}
-	} else {
+	} else if !os.IsNotExist(err) {
 		return err
 	}
 	return nil
@@ -70,46 +84,112 @@ func CreateResponseFile(uuid string) (*os.File, error) {
 	return os.Create(filePath)
 }
 
-func UpdateDownloaded(uuid, filePath string) error {
-	mmsPath, err := xdg.Data.Ensure(path.Join(SUBPATH, uuid+".mms"))
+func CreateCancelConfFile(uuid string) (*os.File, error) {
+	filePath, err := xdg.Cache.Ensure(path.Join(SUBPATH, uuid+".m-cancel.conf"))
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return os.Create(filePath)
+}
+
+//DownloadFile returns the path the content of a notification is downloaded
+//to, a partial download is kept there so it can be resumed.
+func DownloadFile(uuid string) (string, error) {
+	return xdg.Cache.Ensure(path.Join(SUBPATH, uuid+".m-retrieve.conf.part"))
+}
+
+//UpdateProgress records how much of the content of a notification has been
+//downloaded, or of a message has been sent, so far.
+func UpdateProgress(uuid string, received, size int64) error {
+	_, err := updateState(uuid, func(state *MMSState) error {
+		state.Received = received
+		state.Size = size
+		return nil
+	})
+	return err
+}
+
+//RecordAttempt adds the outcome of a transfer attempt, a nil outcome being
+//a success, and clears any scheduled retry. It returns the updated state.
+func RecordAttempt(uuid string, outcome error) (MMSState, error) {
+	attempt := Attempt{Time: time.Now()}
+	if outcome != nil {
+		attempt.Error = outcome.Error()
 	}
-	if err := os.Rename(filePath, mmsPath); err != nil {
-		//TODO delete file
+	return updateState(uuid, func(state *MMSState) error {
+		state.Attempts = append(state.Attempts, attempt)
+		state.NextAttempt = nil
+		return nil
+	})
+}
+
+//ScheduleRetry records when the transfer for uuid is attempted again.
+func ScheduleRetry(uuid string, next time.Time) error {
+	_, err := updateState(uuid, func(state *MMSState) error {
+		state.NextAttempt = &next
+		return nil
+	})
+	return err
+}
+
+//UpdateDownloaded records the notification for uuid as downloaded and then
+//moves the content downloaded to filePath into the store, RecoverDownload
+//completes the move if it does not happen.
+func UpdateDownloaded(uuid, filePath string) error {
+	if err := transition(uuid, NOTIFICATION, DOWNLOADED, nil); err != nil {
 		return err
 	}
-	state := MMSState{
-		State: DOWNLOADED,
+	return storeDownload(uuid, filePath)
+}
+
+//RecoverDownload moves the content downloaded for uuid into the store if
+//UpdateDownloaded left it behind.
+func RecoverDownload(uuid string) error {
+	if _, err := GetMMS(uuid); err == nil {
+		return nil
 	}
-	storePath, err := xdg.Data.Find(path.Join(SUBPATH, uuid+".db"))
+	filePath, err := DownloadFile(uuid)
 	if err != nil {
 		return err
 	}
-	return writeState(state, storePath)
+	return storeDownload(uuid, filePath)
 }
 
-func UpdateRetrieved(uuid string) error {
-	state := MMSState{
-		State: RETRIEVED,
-	}
-	storePath, err := xdg.Data.Find(path.Join(SUBPATH, uuid+".db"))
+func storeDownload(uuid, filePath string) error {
+	mmsPath, err := xdg.Data.Ensure(path.Join(SUBPATH, uuid+".mms"))
 	if err != nil {
 		return err
 	}
-	return writeState(state, storePath)
+	return os.Rename(filePath, mmsPath)
+}
+
+func UpdateRetrieved(uuid string) error {
+	return transition(uuid, DOWNLOADED, RETRIEVED, nil)
+}
+
+//UpdateReceived records that the retrieval of the message for uuid was
+//acknowledged to the message center.
+func UpdateReceived(uuid string) error {
+	return transition(uuid, RETRIEVED, RECEIVED, nil)
+}
+
+//UpdateCanceled records that sending the message for uuid was canceled by
+//the user.
+func UpdateCanceled(uuid string) error {
+	return transition(uuid, DRAFT, CANCELED, func(state *MMSState) error {
+		state.NextAttempt = nil
+		return nil
+	})
 }
 
-func CreateSendFile(uuid string) (*os.File, error) {
+func CreateSendFile(identity, uuid string) (*os.File, error) {
 	state := MMSState{
-		State: DRAFT,
-	}
-	storePath, err := xdg.Data.Ensure(path.Join(SUBPATH, uuid+".db"))
-	if err != nil {
-		return nil, err
+		Identity: identity,
+		State:    DRAFT,
 	}
-	if err := writeState(state, storePath); err != nil {
-		os.Remove(storePath)
+	if err := updateDB(func(tx *bolt.Tx) error {
+		return putState(tx, uuid, state)
+	}); err != nil {
 		return nil, err
 	}
 	filePath, err := xdg.Cache.Ensure(path.Join(SUBPATH, uuid+".m-send.req"))
@@ -119,26 +199,61 @@ func CreateSendFile(uuid string) (*os.File, error) {
 	return os.Create(filePath)
 }
 
+//GetSendFile returns the path of the m-send.req for uuid, it only exists
+//until the message is done being sent.
+func GetSendFile(uuid string) (string, error) {
+	return xdg.Cache.Find(path.Join(SUBPATH, uuid+".m-send.req"))
+}
+
+//List returns the state of the messages of the modem identity by uuid.
+//The messages stored before identities were recorded are assigned to the
+//first identity they are listed for.
+func List(identity string) (map[string]MMSState, error) {
+	states := make(map[string]MMSState)
+	err := updateDB(func(tx *bolt.Tx) error {
+		legacy := make(map[string]MMSState)
+		if err := listStates(tx, "", legacy); err != nil {
+			return err
+		}
+		for uuid, state := range legacy {
+			state.Identity = identity
+			if err := putState(tx, uuid, state); err != nil {
+				return err
+			}
+		}
+		return listStates(tx, identity, states)
+	})
+	return states, err
+}
+
+//TransferDir returns the directory transfers in progress are written to,
+//it is on the same file system as the cache files.
+func TransferDir() (string, error) {
+	filePath, err := xdg.Cache.Ensure(path.Join(SUBPATH, "transfer"))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Dir(filePath), nil
+}
+
 func GetMMS(uuid string) (string, error) {
 	return xdg.Data.Find(path.Join(SUBPATH, uuid+".mms"))
 }
 
-func writeState(state MMSState, storePath string) error {
-	file, err := os.Create(storePath)
+//LoadMRetrieveConf reads and decodes the m-retrieve.conf stored for uuid.
+func LoadMRetrieveConf(uuid string) (*mms.MRetrieveConf, error) {
+	filePath, err := GetMMS(uuid)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("unable to retrieve MMS: %s", err)
 	}
-	defer func() {
-		file.Close()
-		if err != nil {
-			os.Remove(storePath)
-		}
-	}()
-	w := bufio.NewWriter(file)
-	defer w.Flush()
-	jsonWriter := json.NewEncoder(w)
-	if err := jsonWriter.Encode(state); err != nil {
-		return err
+	mmsData, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("issues while reading from downloaded file: %s", err)
 	}
-	return nil
+	mRetrieveConf := mms.NewMRetrieveConf(uuid)
+	dec := mms.NewDecoder(mmsData)
+	if err := dec.Decode(mRetrieveConf); err != nil {
+		return nil, fmt.Errorf("unable to decode m-retrieve.conf: %s with log %s", err, dec.GetLog())
+	}
+	return mRetrieveConf, nil
 }
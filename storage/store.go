@@ -0,0 +1,275 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of telepathy.
+ *
+ * mms is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * mms is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"launchpad.net/go-xdg/v0"
+)
+
+//messagesPath is the database holding the state of every message, next to
+//the directory holding their content.
+var messagesPath string = path.Join(path.Dir(SUBPATH), "messages.db")
+
+var (
+	metaBucket       = []byte("meta")
+	messagesBucket   = []byte("messages")
+	identitiesBucket = []byte("identities")
+	versionKey       = []byte("version")
+)
+
+//migrations bring the schema of the database from the version at their
+//index to the next one, returning the files made obsolete which are removed
+//once the migration is committed. The schema version is len(migrations).
+var migrations = []func(tx *bolt.Tx) ([]string, error){
+	migrateJSONStates,
+}
+
+var (
+	dbMutex sync.Mutex
+	db      *bolt.DB
+)
+
+//openDB returns the message database, opening it and migrating its schema
+//on first use.
+func openDB() (*bolt.DB, error) {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+	if db != nil {
+		return db, nil
+	}
+	dbPath, err := xdg.Data.Ensure(messagesPath)
+	if err != nil {
+		return nil, err
+	}
+	d, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	if err := migrate(d); err != nil {
+		d.Close()
+		return nil, err
+	}
+	db = d
+	return db, nil
+}
+
+func migrate(d *bolt.DB) error {
+	var obsolete []string
+	err := d.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		var version uint64
+		if v := meta.Get(versionKey); v != nil {
+			version = binary.BigEndian.Uint64(v)
+		}
+		if version > uint64(len(migrations)) {
+			return fmt.Errorf("message database schema version %d is newer than %d", version, len(migrations))
+		}
+		for ; version < uint64(len(migrations)); version++ {
+			files, err := migrations[version](tx)
+			if err != nil {
+				return fmt.Errorf("cannot migrate message database to schema version %d: %s", version+1, err)
+			}
+			obsolete = append(obsolete, files...)
+			log.Print("Migrated message database to schema version ", version+1)
+		}
+		v := make([]byte, 8)
+		binary.BigEndian.PutUint64(v, version)
+		return meta.Put(versionKey, v)
+	})
+	if err != nil {
+		return err
+	}
+	for _, file := range obsolete {
+		if err := os.Remove(file); err != nil {
+			log.Print("Cannot remove migrated file: ", err)
+		}
+	}
+	return nil
+}
+
+//migrateJSONStates creates the buckets of the first schema version and
+//imports the <uuid>.db JSON files messages were stored in before.
+func migrateJSONStates(tx *bolt.Tx) ([]string, error) {
+	if _, err := tx.CreateBucketIfNotExists(messagesBucket); err != nil {
+		return nil, err
+	}
+	if _, err := tx.CreateBucketIfNotExists(identitiesBucket); err != nil {
+		return nil, err
+	}
+	storePath, err := xdg.Data.Ensure(path.Join(SUBPATH, "migrate"))
+	if err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(filepath.Dir(storePath), "*.db"))
+	if err != nil {
+		return nil, err
+	}
+	var migrated []string
+	for _, p := range paths {
+		state, err := readState(p)
+		if err != nil {
+			log.Print("Skipping unreadable message state ", p, ": ", err)
+			continue
+		}
+		if err := putState(tx, strings.TrimSuffix(filepath.Base(p), ".db"), state); err != nil {
+			return nil, err
+		}
+		migrated = append(migrated, p)
+	}
+	return migrated, nil
+}
+
+//readState reads a message state stored as JSON before the database.
+func readState(storePath string) (state MMSState, err error) {
+	file, err := os.Open(storePath)
+	if err != nil {
+		return state, err
+	}
+	defer file.Close()
+	err = json.NewDecoder(file).Decode(&state)
+	return state, err
+}
+
+func viewDB(fn func(tx *bolt.Tx) error) error {
+	d, err := openDB()
+	if err != nil {
+		return err
+	}
+	return d.View(fn)
+}
+
+func updateDB(fn func(tx *bolt.Tx) error) error {
+	d, err := openDB()
+	if err != nil {
+		return err
+	}
+	return d.Update(fn)
+}
+
+//identityKey is the key of uuid in the identity index, the identities of
+//messages are prefixes of their keys so they can be listed with a cursor.
+func identityKey(identity, uuid string) []byte {
+	return []byte(identity + "\x00" + uuid)
+}
+
+func getState(tx *bolt.Tx, uuid string) (MMSState, error) {
+	var state MMSState
+	data := tx.Bucket(messagesBucket).Get([]byte(uuid))
+	if data == nil {
+		return state, fmt.Errorf("no stored message %s", uuid)
+	}
+	err := json.Unmarshal(data, &state)
+	return state, err
+}
+
+//putState stores state for uuid and indexes it under its identity, removing
+//it from the index of the identity it had before.
+func putState(tx *bolt.Tx, uuid string, state MMSState) error {
+	if previous, err := getState(tx, uuid); err == nil && previous.Identity != state.Identity {
+		if err := tx.Bucket(identitiesBucket).Delete(identityKey(previous.Identity, uuid)); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(messagesBucket).Put([]byte(uuid), data); err != nil {
+		return err
+	}
+	return tx.Bucket(identitiesBucket).Put(identityKey(state.Identity, uuid), nil)
+}
+
+func deleteState(tx *bolt.Tx, uuid string) error {
+	state, err := getState(tx, uuid)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(identitiesBucket).Delete(identityKey(state.Identity, uuid)); err != nil {
+		return err
+	}
+	return tx.Bucket(messagesBucket).Delete([]byte(uuid))
+}
+
+//listStates adds the states of the messages of identity to states.
+func listStates(tx *bolt.Tx, identity string, states map[string]MMSState) error {
+	prefix := identityKey(identity, "")
+	c := tx.Bucket(identitiesBucket).Cursor()
+	for k, _ := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), string(prefix)); k, _ = c.Next() {
+		uuid := string(k[len(prefix):])
+		state, err := getState(tx, uuid)
+		if err != nil {
+			return err
+		}
+		states[uuid] = state
+	}
+	return nil
+}
+
+//updateState applies update to the stored state of uuid in a single
+//transaction, the fields it does not change are kept and nothing is stored
+//if it fails.
+func updateState(uuid string, update func(*MMSState) error) (MMSState, error) {
+	var state MMSState
+	err := updateDB(func(tx *bolt.Tx) error {
+		var err error
+		if state, err = getState(tx, uuid); err != nil {
+			return err
+		}
+		if err := update(&state); err != nil {
+			return err
+		}
+		return putState(tx, uuid, state)
+	})
+	return state, err
+}
+
+//transition moves the message for uuid to state to if it is in state from,
+//then applies update in the same transaction.
+func transition(uuid, from, to string, update func(*MMSState) error) error {
+	_, err := updateState(uuid, func(state *MMSState) error {
+		if state.State != from {
+			return fmt.Errorf("cannot move message %s from %s to %s", uuid, state.State, to)
+		}
+		state.State = to
+		if update != nil {
+			return update(state)
+		}
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,219 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of telepathy.
+ *
+ * mms is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * mms is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	//not dot imported as its List clashes with the one of storage
+	"launchpad.net/gocheck"
+)
+
+func Test(t *testing.T) { gocheck.TestingT(t) }
+
+type StoreTestSuite struct {
+	storeDir string
+	env      map[string]string
+}
+
+var _ = gocheck.Suite(&StoreTestSuite{})
+
+func (s *StoreTestSuite) SetUpTest(c *gocheck.C) {
+	dir := c.MkDir()
+	s.env = make(map[string]string)
+	for _, key := range []string{"XDG_DATA_HOME", "XDG_CACHE_HOME", "XDG_CONFIG_HOME"} {
+		s.env[key] = os.Getenv(key)
+		c.Assert(os.Setenv(key, filepath.Join(dir, key)), gocheck.IsNil)
+	}
+	s.storeDir = filepath.Join(dir, "XDG_DATA_HOME", SUBPATH)
+	c.Assert(os.MkdirAll(s.storeDir, 0700), gocheck.IsNil)
+}
+
+func (s *StoreTestSuite) TearDownTest(c *gocheck.C) {
+	closeTestDB(c)
+	for key, value := range s.env {
+		os.Setenv(key, value)
+	}
+}
+
+//closeTestDB closes the message database so the next use opens it again.
+func closeTestDB(c *gocheck.C) {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+	if db != nil {
+		c.Check(db.Close(), gocheck.IsNil)
+		db = nil
+	}
+}
+
+func (s *StoreTestSuite) writeJSONState(c *gocheck.C, uuid string, state MMSState) string {
+	data, err := json.Marshal(state)
+	c.Assert(err, gocheck.IsNil)
+	storePath := filepath.Join(s.storeDir, uuid+".db")
+	c.Assert(ioutil.WriteFile(storePath, data, 0600), gocheck.IsNil)
+	return storePath
+}
+
+func (s *StoreTestSuite) TestMigrateJSONStates(c *gocheck.C) {
+	received := s.writeJSONState(c, "uuid1", MMSState{Identity: "id", State: RECEIVED, ContentLocation: "http://mmsc/1"})
+	legacy := s.writeJSONState(c, "uuid2", MMSState{State: NOTIFICATION, ContentLocation: "http://mmsc/2"})
+
+	states, err := List("id")
+	c.Assert(err, gocheck.IsNil)
+	c.Check(states, gocheck.DeepEquals, map[string]MMSState{
+		"uuid1": {Identity: "id", State: RECEIVED, ContentLocation: "http://mmsc/1"},
+		"uuid2": {Identity: "id", State: NOTIFICATION, ContentLocation: "http://mmsc/2"},
+	})
+	for _, p := range []string{received, legacy} {
+		_, err := os.Stat(p)
+		c.Check(os.IsNotExist(err), gocheck.Equals, true, gocheck.Commentf("%s was not removed", p))
+	}
+}
+
+func (s *StoreTestSuite) TestMigrateKeepsFilesUntilCommitted(c *gocheck.C) {
+	jsonState := s.writeJSONState(c, "uuid1", MMSState{Identity: "id", State: RECEIVED})
+	defer func(m []func(tx *bolt.Tx) ([]string, error)) { migrations = m }(migrations)
+	migrations = append(migrations, func(tx *bolt.Tx) ([]string, error) {
+		return nil, errors.New("failed migration")
+	})
+
+	_, err := List("id")
+	c.Assert(err, gocheck.NotNil)
+	_, err = os.Stat(jsonState)
+	c.Check(err, gocheck.IsNil)
+
+	migrations = migrations[:len(migrations)-1]
+	states, err := List("id")
+	c.Assert(err, gocheck.IsNil)
+	c.Check(states["uuid1"].State, gocheck.Equals, RECEIVED)
+	_, err = os.Stat(jsonState)
+	c.Check(os.IsNotExist(err), gocheck.Equals, true)
+}
+
+func (s *StoreTestSuite) TestMigrateOnce(c *gocheck.C) {
+	_, err := List("id")
+	c.Assert(err, gocheck.IsNil)
+	closeTestDB(c)
+
+	//a state file showing up later is not imported again
+	jsonState := s.writeJSONState(c, "uuid1", MMSState{Identity: "id", State: RECEIVED})
+	states, err := List("id")
+	c.Assert(err, gocheck.IsNil)
+	c.Check(states, gocheck.HasLen, 0)
+	_, err = os.Stat(jsonState)
+	c.Check(err, gocheck.IsNil)
+}
+
+func (s *StoreTestSuite) TestTransitions(c *gocheck.C) {
+	c.Assert(Create("id", "uuid1", "http://mmsc/1", time.Time{}), gocheck.IsNil)
+	c.Check(UpdateReceived("uuid1"), gocheck.NotNil)
+
+	filePath, err := DownloadFile("uuid1")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(ioutil.WriteFile(filePath, []byte("m-retrieve.conf"), 0600), gocheck.IsNil)
+	c.Assert(UpdateDownloaded("uuid1", filePath), gocheck.IsNil)
+	mmsPath, err := GetMMS("uuid1")
+	c.Assert(err, gocheck.IsNil)
+	data, err := ioutil.ReadFile(mmsPath)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(string(data), gocheck.Equals, "m-retrieve.conf")
+
+	c.Assert(UpdateRetrieved("uuid1"), gocheck.IsNil)
+	c.Assert(UpdateReceived("uuid1"), gocheck.IsNil)
+
+	//a refused transition leaves the state and the content alone
+	c.Assert(ioutil.WriteFile(filePath, []byte("another download"), 0600), gocheck.IsNil)
+	c.Check(UpdateDownloaded("uuid1", filePath), gocheck.NotNil)
+	states, err := List("id")
+	c.Assert(err, gocheck.IsNil)
+	c.Check(states["uuid1"].State, gocheck.Equals, RECEIVED)
+	data, err = ioutil.ReadFile(mmsPath)
+	c.Assert(err, gocheck.IsNil)
+	c.Check(string(data), gocheck.Equals, "m-retrieve.conf")
+}
+
+func (s *StoreTestSuite) TestRecoverDownload(c *gocheck.C) {
+	c.Assert(Create("id", "uuid1", "http://mmsc/1", time.Time{}), gocheck.IsNil)
+	filePath, err := DownloadFile("uuid1")
+	c.Assert(err, gocheck.IsNil)
+	//the state is stored but the content is not moved
+	c.Check(UpdateDownloaded("uuid1", filePath), gocheck.NotNil)
+	states, err := List("id")
+	c.Assert(err, gocheck.IsNil)
+	c.Check(states["uuid1"].State, gocheck.Equals, DOWNLOADED)
+
+	c.Assert(ioutil.WriteFile(filePath, []byte("m-retrieve.conf"), 0600), gocheck.IsNil)
+	c.Assert(RecoverDownload("uuid1"), gocheck.IsNil)
+	_, err = GetMMS("uuid1")
+	c.Check(err, gocheck.IsNil)
+	c.Check(RecoverDownload("uuid1"), gocheck.IsNil)
+}
+
+func (s *StoreTestSuite) TestListByIdentity(c *gocheck.C) {
+	c.Assert(Create("id1", "uuid1", "http://mmsc/1", time.Time{}), gocheck.IsNil)
+	c.Assert(Create("id2", "uuid2", "http://mmsc/2", time.Time{}), gocheck.IsNil)
+	c.Assert(Create("", "uuid3", "http://mmsc/3", time.Time{}), gocheck.IsNil)
+	f, err := CreateSendFile("id1", "uuid4")
+	c.Assert(err, gocheck.IsNil)
+	f.Close()
+
+	states, err := List("id1")
+	c.Assert(err, gocheck.IsNil)
+	c.Check(states, gocheck.HasLen, 3)
+	c.Check(states["uuid1"].State, gocheck.Equals, NOTIFICATION)
+	c.Check(states["uuid3"].Identity, gocheck.Equals, "id1")
+	c.Check(states["uuid4"].State, gocheck.Equals, DRAFT)
+	//the message stored without identity is only indexed under id1 now
+	c.Check(indexKeys(c), gocheck.DeepEquals, []string{"id1\x00uuid1", "id1\x00uuid3", "id1\x00uuid4", "id2\x00uuid2"})
+
+	c.Assert(Destroy("uuid1"), gocheck.IsNil)
+	states, err = List("id1")
+	c.Assert(err, gocheck.IsNil)
+	c.Check(states, gocheck.HasLen, 2)
+	_, ok := states["uuid1"]
+	c.Check(ok, gocheck.Equals, false)
+
+	states, err = List("id2")
+	c.Assert(err, gocheck.IsNil)
+	c.Check(states, gocheck.HasLen, 1)
+	c.Check(states["uuid2"].ContentLocation, gocheck.Equals, "http://mmsc/2")
+}
+
+//indexKeys returns the keys of the identity index in order.
+func indexKeys(c *gocheck.C) []string {
+	var keys []string
+	c.Assert(viewDB(func(tx *bolt.Tx) error {
+		return tx.Bucket(identitiesBucket).ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	}), gocheck.IsNil)
+	return keys
+}
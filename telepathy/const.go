@@ -27,6 +27,9 @@ const (
 	MMS_MESSAGE_DBUS_IFACE = "org.ofono.mms.Message"
 	MMS_SERVICE_DBUS_IFACE = "org.ofono.mms.Service"
 	MMS_MANAGER_DBUS_IFACE = "org.ofono.mms.Manager"
+	//MMS_WAP_PUSH_DBUS_IFACE is implemented by the service object to expose
+	//Service Indication and Service Loading pushes
+	MMS_WAP_PUSH_DBUS_IFACE = "org.ofono.mms.WapPush"
 )
 
 const (
@@ -40,12 +43,40 @@ const (
 	preferredContextProperty   string = "PreferredContext"
 	propertyChangedSignal      string = "PropertyChanged"
 	statusProperty             string = "Status"
+	errorProperty              string = "Error"
+	progressProperty           string = "Progress"
+	sizeProperty               string = "Size"
+)
+
+const (
+	serviceIndicationAddedSignal   string = "ServiceIndicationAdded"
+	serviceIndicationRemovedSignal string = "ServiceIndicationRemoved"
+	serviceLoadingReceivedSignal   string = "ServiceLoadingReceived"
+)
+
+//D-Bus errors SendMessage is rejected with
+const (
+	errorUnsupportedMediaType = "org.ofono.mms.Error.UnsupportedMediaType"
+	errorTooManyRecipients    = "org.ofono.mms.Error.TooManyRecipients"
+	errorMessageTooLarge      = "org.ofono.mms.Error.MessageTooLarge"
+	errorFailed               = "org.ofono.mms.Error.Failed"
+)
+
+//D-Bus errors a message fails with, set as its Error property
+const (
+	errorCertificate = "org.ofono.mms.Error.Certificate"
+)
+
+//D-Bus errors Cancel is rejected with
+const (
+	errorNotCancelable = "org.ofono.mms.Error.NotCancelable"
 )
 
 const (
 	PERMANENT_ERROR = "PermanentError"
 	SENT            = "Sent"
 	TRANSIENT_ERROR = "TransientError"
+	CANCELED        = "Canceled"
 )
 
 const (
@@ -24,7 +24,9 @@ package telepathy
 import (
 	"fmt"
 	"log"
+	"path"
 	"sort"
+	"sync"
 
 	"launchpad.net/go-dbus/v1"
 )
@@ -32,23 +34,31 @@ import (
 var validStatus sort.StringSlice
 
 func init() {
-	validStatus = sort.StringSlice{SENT, PERMANENT_ERROR, TRANSIENT_ERROR}
+	validStatus = sort.StringSlice{SENT, PERMANENT_ERROR, TRANSIENT_ERROR, CANCELED}
 	sort.Strings(validStatus)
 }
 
 type MessageInterface struct {
-	conn       *dbus.Connection
+	conn       connection
 	objectPath dbus.ObjectPath
 	msgChan    chan *dbus.Message
 	deleteChan chan dbus.ObjectPath
 	status     string
+	error      string
+	//cancelChan receives the uuid of the message when Cancel is called,
+	//it is nil for messages that are not being sent
+	cancelChan  chan<- string
+	lock        sync.Mutex
+	transferred int64
+	size        int64
 }
 
-func NewMessageInterface(conn *dbus.Connection, objectPath dbus.ObjectPath, deleteChan chan dbus.ObjectPath) *MessageInterface {
+func NewMessageInterface(conn connection, objectPath dbus.ObjectPath, deleteChan chan dbus.ObjectPath, cancelChan chan<- string) *MessageInterface {
 	msgInterface := MessageInterface{
 		conn:       conn,
 		objectPath: objectPath,
 		deleteChan: deleteChan,
+		cancelChan: cancelChan,
 		msgChan:    make(chan *dbus.Message),
 		status:     "draft",
 	}
@@ -80,6 +90,21 @@ func (msgInterface *MessageInterface) watchDBusMethodCalls() {
 				log.Println("Could not send reply:", err)
 			}
 			msgInterface.deleteChan <- msgInterface.objectPath
+		case "Cancel":
+			msgInterface.lock.Lock()
+			cancelChan := msgInterface.cancelChan
+			msgInterface.lock.Unlock()
+			if cancelChan == nil {
+				reply = dbus.NewErrorMessage(msg, errorNotCancelable, "Message is not being sent")
+			} else {
+				reply = dbus.NewMethodReturnMessage(msg)
+			}
+			if err := msgInterface.conn.Send(reply); err != nil {
+				log.Println("Could not send reply:", err)
+			}
+			if cancelChan != nil {
+				cancelChan <- path.Base(string(msgInterface.objectPath))
+			}
 		default:
 			log.Println("Received unkown method call on", msg.Interface, msg.Member)
 			reply = dbus.NewErrorMessage(msg, "org.freedesktop.DBus.Error.UnknownMethod", "Unknown method")
@@ -93,6 +118,10 @@ func (msgInterface *MessageInterface) watchDBusMethodCalls() {
 func (msgInterface *MessageInterface) StatusChanged(status string) error {
 	i := validStatus.Search(status)
 	if i < validStatus.Len() && validStatus[i] == status {
+		msgInterface.lock.Lock()
+		//a message with a final status cannot be canceled anymore
+		msgInterface.cancelChan = nil
+		msgInterface.lock.Unlock()
 		msgInterface.status = status
 		signal := dbus.NewSignalMessage(msgInterface.objectPath, MMS_MESSAGE_DBUS_IFACE, propertyChangedSignal)
 		if err := signal.AppendArgs(statusProperty, dbus.Variant{status}); err != nil {
@@ -107,9 +136,49 @@ func (msgInterface *MessageInterface) StatusChanged(status string) error {
 	return fmt.Errorf("status %s is not a valid status", status)
 }
 
+//ErrorChanged sets the Error property to the D-Bus error name describing
+//why the message failed.
+func (msgInterface *MessageInterface) ErrorChanged(name string) error {
+	msgInterface.error = name
+	return msgInterface.propertyChanged(errorProperty, name)
+}
+
+//ProgressChanged updates the Progress property to the bytes transferred so
+//far and the Size property to the bytes to transfer, emitting
+//PropertyChanged for those that changed.
+func (msgInterface *MessageInterface) ProgressChanged(transferred, size int64) error {
+	msgInterface.lock.Lock()
+	sizeChanged := size != msgInterface.size
+	msgInterface.transferred, msgInterface.size = transferred, size
+	msgInterface.lock.Unlock()
+	if sizeChanged {
+		if err := msgInterface.propertyChanged(sizeProperty, uint64(size)); err != nil {
+			return err
+		}
+	}
+	return msgInterface.propertyChanged(progressProperty, uint64(transferred))
+}
+
+func (msgInterface *MessageInterface) propertyChanged(name string, value interface{}) error {
+	signal := dbus.NewSignalMessage(msgInterface.objectPath, MMS_MESSAGE_DBUS_IFACE, propertyChangedSignal)
+	if err := signal.AppendArgs(name, dbus.Variant{value}); err != nil {
+		return err
+	}
+	return msgInterface.conn.Send(signal)
+}
+
 func (msgInterface *MessageInterface) GetPayload() *Payload {
 	properties := make(map[string]dbus.Variant)
 	properties["Status"] = dbus.Variant{msgInterface.status}
+	if msgInterface.error != "" {
+		properties[errorProperty] = dbus.Variant{msgInterface.error}
+	}
+	msgInterface.lock.Lock()
+	if msgInterface.size > 0 {
+		properties[progressProperty] = dbus.Variant{uint64(msgInterface.transferred)}
+		properties[sizeProperty] = dbus.Variant{uint64(msgInterface.size)}
+	}
+	msgInterface.lock.Unlock()
 	return &Payload{
 		Path:       msgInterface.objectPath,
 		Properties: properties,
@@ -27,11 +27,14 @@ import (
 	"log"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ubuntu-phonedations/nuntium/mms"
 	"github.com/ubuntu-phonedations/nuntium/storage"
+	"github.com/ubuntu-phonedations/nuntium/wappush"
 	"launchpad.net/go-dbus/v1"
 )
 
@@ -42,15 +45,26 @@ type Payload struct {
 	Properties map[string]dbus.Variant
 }
 
+//connection is the part of the D-Bus connection used by the service and its
+//message objects.
+type connection interface {
+	Send(msg *dbus.Message) error
+	RegisterObjectPath(path dbus.ObjectPath, handler chan<- *dbus.Message)
+	UnregisterObjectPath(path dbus.ObjectPath)
+}
+
 type MMSService struct {
 	payload         Payload
 	Properties      map[string]dbus.Variant
-	conn            *dbus.Connection
+	conn            connection
 	msgChan         chan *dbus.Message
 	messageHandlers map[dbus.ObjectPath]*MessageInterface
+	handlersLock    sync.Mutex //guards messageHandlers
 	msgDeleteChan   chan dbus.ObjectPath
 	identity        string
 	outMessage      chan *OutgoingMessage
+	cancelMessage   chan string
+	indications     *wappush.IndicationStore
 }
 
 type Attachment struct {
@@ -71,9 +85,10 @@ type OutgoingMessage struct {
 	Recipients  []string
 	Attachments []OutAttachment
 	Reply       *dbus.Message
+	call        *dbus.Message
 }
 
-func NewMMSService(conn *dbus.Connection, modemObjPath dbus.ObjectPath, identity string, outgoingChannel chan *OutgoingMessage, useDeliveryReports bool) *MMSService {
+func NewMMSService(conn connection, modemObjPath dbus.ObjectPath, identity string, outgoingChannel chan *OutgoingMessage, cancelChannel chan string, useDeliveryReports bool) *MMSService {
 	properties := make(map[string]dbus.Variant)
 	properties[identityProperty] = dbus.Variant{identity}
 	serviceProperties := make(map[string]dbus.Variant)
@@ -91,7 +106,9 @@ func NewMMSService(conn *dbus.Connection, modemObjPath dbus.ObjectPath, identity
 		msgDeleteChan:   make(chan dbus.ObjectPath),
 		messageHandlers: make(map[dbus.ObjectPath]*MessageInterface),
 		outMessage:      outgoingChannel,
+		cancelMessage:   cancelChannel,
 		identity:        identity,
+		indications:     wappush.NewIndicationStore(),
 	}
 	go service.watchDBusMethodCalls()
 	go service.watchMessageDeleteCalls()
@@ -110,6 +127,10 @@ func (service *MMSService) watchMessageDeleteCalls() {
 func (service *MMSService) watchDBusMethodCalls() {
 	for msg := range service.msgChan {
 		var reply *dbus.Message
+		if msg.Interface == MMS_WAP_PUSH_DBUS_IFACE {
+			service.handleWapPushCall(msg)
+			continue
+		}
 		if msg.Interface != MMS_SERVICE_DBUS_IFACE {
 			log.Println("Received unkown method call on", msg.Interface, msg.Member)
 			reply = dbus.NewErrorMessage(
@@ -121,8 +142,10 @@ func (service *MMSService) watchDBusMethodCalls() {
 		switch msg.Member {
 		case "GetMessages":
 			reply = dbus.NewMethodReturnMessage(msg)
-			//TODO implement store and forward
-			var payload []Payload
+			payload, err := service.storedMessages()
+			if err != nil {
+				log.Print("Cannot list stored messages: ", err)
+			}
 			if err := reply.AppendArgs(payload); err != nil {
 				log.Print("Cannot parse payload data from services")
 				reply = dbus.NewErrorMessage(msg, "Error.InvalidArguments", "Cannot parse services")
@@ -158,6 +181,7 @@ func (service *MMSService) watchDBusMethodCalls() {
 		case "SendMessage":
 			var outMessage OutgoingMessage
 			outMessage.Reply = dbus.NewMethodReturnMessage(msg)
+			outMessage.call = msg
 			if err := msg.Args(&outMessage.Recipients, &outMessage.Attachments); err != nil {
 				log.Print("Cannot parse payload data from services")
 				reply = dbus.NewErrorMessage(msg, "Error.InvalidArguments", "Cannot parse New Message")
@@ -180,6 +204,86 @@ func (service *MMSService) watchDBusMethodCalls() {
 	}
 }
 
+//storedMessages returns the messages received for the identity of the
+//service that no client deleted yet, rebuilt from storage, followed by the
+//messages being sent.
+func (service *MMSService) storedMessages() ([]Payload, error) {
+	states, err := storage.List(service.identity)
+	if err != nil {
+		return nil, err
+	}
+	uuids := make([]string, 0, len(states))
+	for uuid, state := range states {
+		switch state.State {
+		case storage.DOWNLOADED, storage.RETRIEVED, storage.RECEIVED:
+			uuids = append(uuids, uuid)
+		}
+	}
+	sort.Strings(uuids)
+	var payloads []Payload
+	stored := make(map[dbus.ObjectPath]bool)
+	for _, uuid := range uuids {
+		mRetConf, err := storage.LoadMRetrieveConf(uuid)
+		if err != nil {
+			log.Print("Cannot load stored message ", uuid, ": ", err)
+			continue
+		}
+		payload, err := service.parseMessage(mRetConf)
+		if err != nil {
+			log.Print("Cannot parse stored message ", uuid, ": ", err)
+			continue
+		}
+		//the message object is needed for clients to delete it
+		service.addIncomingHandler(payload.Path)
+		stored[payload.Path] = true
+		payloads = append(payloads, payload)
+	}
+	service.handlersLock.Lock()
+	defer service.handlersLock.Unlock()
+	var outgoing []string
+	for msgObjectPath := range service.messageHandlers {
+		if !stored[msgObjectPath] {
+			outgoing = append(outgoing, string(msgObjectPath))
+		}
+	}
+	sort.Strings(outgoing)
+	for _, msgObjectPath := range outgoing {
+		payloads = append(payloads, *service.messageHandlers[dbus.ObjectPath(msgObjectPath)].GetPayload())
+	}
+	return payloads, nil
+}
+
+//addIncomingHandler creates the object for the received message at
+//msgObjectPath unless it already exists.
+func (service *MMSService) addIncomingHandler(msgObjectPath dbus.ObjectPath) {
+	service.handlersLock.Lock()
+	defer service.handlersLock.Unlock()
+	if _, ok := service.messageHandlers[msgObjectPath]; !ok {
+		service.messageHandlers[msgObjectPath] = NewMessageInterface(service.conn, msgObjectPath, service.msgDeleteChan, nil)
+	}
+}
+
+//messageHandler returns the object for the message at msgObjectPath.
+func (service *MMSService) messageHandler(msgObjectPath dbus.ObjectPath) (*MessageInterface, bool) {
+	service.handlersLock.Lock()
+	defer service.handlersLock.Unlock()
+	msgInterface, ok := service.messageHandlers[msgObjectPath]
+	return msgInterface, ok
+}
+
+//removeHandler closes and forgets the object for the message at
+//msgObjectPath, returning false if there is none.
+func (service *MMSService) removeHandler(msgObjectPath dbus.ObjectPath) bool {
+	service.handlersLock.Lock()
+	msgInterface, ok := service.messageHandlers[msgObjectPath]
+	delete(service.messageHandlers, msgObjectPath)
+	service.handlersLock.Unlock()
+	if ok {
+		msgInterface.Close()
+	}
+	return ok
+}
+
 func getUUIDFromObjectPath(objectPath dbus.ObjectPath) (string, error) {
 	str := string(objectPath)
 	defaultError := fmt.Errorf("%s is not a proper object path for a Message", str)
@@ -235,8 +339,7 @@ func (service *MMSService) setProperty(msg *dbus.Message) error {
 //message.
 //It also actually removes the message from storage.
 func (service *MMSService) MessageRemoved(objectPath dbus.ObjectPath) error {
-	service.messageHandlers[objectPath].Close()
-	delete(service.messageHandlers, objectPath)
+	service.removeHandler(objectPath)
 
 	uuid, err := getUUIDFromObjectPath(objectPath)
 	if err != nil {
@@ -256,6 +359,17 @@ func (service *MMSService) MessageRemoved(objectPath dbus.ObjectPath) error {
 	return nil
 }
 
+//MessageCanceled removes the message for uuid, which was canceled by the
+//MMSC before being retrieved. MessageRemoved is only emitted if the message
+//was announced to clients.
+func (service *MMSService) MessageCanceled(uuid string) error {
+	msgObjectPath := service.genMessagePath(uuid)
+	if !service.removeHandler(msgObjectPath) {
+		return storage.Destroy(uuid)
+	}
+	return service.MessageRemoved(msgObjectPath)
+}
+
 //IncomingMessageAdded emits a MessageAdded with the path to the added message which
 //is taken as a parameter and creates an object path on the message interface.
 func (service *MMSService) IncomingMessageAdded(mRetConf *mms.MRetrieveConf) error {
@@ -263,10 +377,35 @@ func (service *MMSService) IncomingMessageAdded(mRetConf *mms.MRetrieveConf) err
 	if err != nil {
 		return err
 	}
-	service.messageHandlers[payload.Path] = NewMessageInterface(service.conn, payload.Path, service.msgDeleteChan)
+	service.addIncomingHandler(payload.Path)
+	return service.MessageAdded(&payload)
+}
+
+//IncomingMessageFailed emits a MessageAdded for the message notified by
+//mNotificationInd with status, either TRANSIENT_ERROR or PERMANENT_ERROR,
+//as it could not be retrieved with err. The message is not kept so no
+//object is created for it.
+func (service *MMSService) IncomingMessageFailed(mNotificationInd *mms.MNotificationInd, status string, err error) error {
+	payload := service.parseFailedMessage(mNotificationInd, status, err)
 	return service.MessageAdded(&payload)
 }
 
+func (service *MMSService) parseFailedMessage(mNotificationInd *mms.MNotificationInd, status string, err error) Payload {
+	params := make(map[string]dbus.Variant)
+	params[statusProperty] = dbus.Variant{status}
+	if _, ok := err.(*mms.CertificateError); ok {
+		params[errorProperty] = dbus.Variant{errorCertificate}
+	}
+	params["Date"] = dbus.Variant{time.Now().Format(time.RFC3339)}
+	if mNotificationInd.Subject != "" {
+		params["Subject"] = dbus.Variant{mNotificationInd.Subject}
+	}
+	if sender := mNotificationInd.From; strings.HasSuffix(sender, PLMN) {
+		params["Sender"] = dbus.Variant{sender[:len(sender)-len(PLMN)]}
+	}
+	return Payload{Path: service.genMessagePath(mNotificationInd.UUID), Properties: params}
+}
+
 //MessageAdded emits a MessageAdded with the path to the added message which
 //is taken as a parameter
 func (service *MMSService) MessageAdded(msgPayload *Payload) error {
@@ -309,7 +448,13 @@ func (service *MMSService) parseMessage(mRetConf *mms.MRetrieveConf) (Payload, e
 	if smil, err := mRetConf.GetSmil(); err == nil {
 		params["Smil"] = dbus.Variant{smil}
 	}
+	if text, fallback := mRetConf.Text(); text != "" {
+		params["Text"] = dbus.Variant{text}
+		//set when a part was not in its declared charset
+		params["TextCharsetFallback"] = dbus.Variant{fallback}
+	}
 	var attachments []Attachment
+	summaries := make(map[string]string)
 	dataParts := mRetConf.GetDataParts()
 	for i := range dataParts {
 		var filePath string
@@ -326,8 +471,15 @@ func (service *MMSService) parseMessage(mRetConf *mms.MRetrieveConf) (Payload, e
 			Length:    uint64(len(dataParts[i].Data)),
 		}
 		attachments = append(attachments, attachment)
+		if summary, ok := dataParts[i].Summary(); ok {
+			summaries[dataParts[i].ContentId] = summary
+		}
 	}
 	params["Attachments"] = dbus.Variant{attachments}
+	//contact and event summaries for vCard and vCalendar attachments
+	if len(summaries) > 0 {
+		params["Summaries"] = dbus.Variant{summaries}
+	}
 	payload := Payload{Path: service.genMessagePath(mRetConf.UUID), Properties: params}
 	return payload, nil
 }
@@ -350,33 +502,86 @@ func parseRecipients(to string) []string {
 
 func (service *MMSService) MessageDestroy(uuid string) error {
 	msgObjectPath := service.genMessagePath(uuid)
-	if msgInterface, ok := service.messageHandlers[msgObjectPath]; ok {
-		msgInterface.Close()
-		delete(service.messageHandlers, msgObjectPath)
+	if service.removeHandler(msgObjectPath) {
+		return nil
 	}
 	return fmt.Errorf("no message interface handler for object path %s", msgObjectPath)
 }
 
 func (service *MMSService) MessageStatusChanged(uuid, status string) error {
 	msgObjectPath := service.genMessagePath(uuid)
-	if msgInterface, ok := service.messageHandlers[msgObjectPath]; ok {
+	if msgInterface, ok := service.messageHandler(msgObjectPath); ok {
 		return msgInterface.StatusChanged(status)
 	}
 	return fmt.Errorf("no message interface handler for object path %s", msgObjectPath)
 }
 
+//MessageProgressChanged updates the progress of the message for uuid while
+//it is being sent.
+func (service *MMSService) MessageProgressChanged(uuid string, transferred, size int64) error {
+	msgObjectPath := service.genMessagePath(uuid)
+	if msgInterface, ok := service.messageHandler(msgObjectPath); ok {
+		return msgInterface.ProgressChanged(transferred, size)
+	}
+	return fmt.Errorf("no message interface handler for object path %s", msgObjectPath)
+}
+
+//MessageFailed marks the message for uuid as permanently failed, setting
+//its Error property when err has a distinct D-Bus error name.
+func (service *MMSService) MessageFailed(uuid string, err error) error {
+	msgObjectPath := service.genMessagePath(uuid)
+	msgInterface, ok := service.messageHandler(msgObjectPath)
+	if !ok {
+		return fmt.Errorf("no message interface handler for object path %s", msgObjectPath)
+	}
+	if _, ok := err.(*mms.CertificateError); ok {
+		if err := msgInterface.ErrorChanged(errorCertificate); err != nil {
+			return err
+		}
+	}
+	return msgInterface.StatusChanged(PERMANENT_ERROR)
+}
+
 func (service *MMSService) ReplySendMessage(reply *dbus.Message, uuid string) (dbus.ObjectPath, error) {
 	msgObjectPath := service.genMessagePath(uuid)
 	reply.AppendArgs(msgObjectPath)
 	if err := service.conn.Send(reply); err != nil {
 		return "", err
 	}
-	msg := NewMessageInterface(service.conn, msgObjectPath, service.msgDeleteChan)
-	service.messageHandlers[msgObjectPath] = msg
-	service.MessageAdded(msg.GetPayload())
+	if err := service.OutgoingMessageAdded(uuid); err != nil {
+		log.Print("Cannot announce outgoing message ", uuid, ": ", err)
+	}
 	return msgObjectPath, nil
 }
 
+//OutgoingMessageAdded creates the object for the message being sent for
+//uuid and emits MessageAdded for it.
+func (service *MMSService) OutgoingMessageAdded(uuid string) error {
+	msgObjectPath := service.genMessagePath(uuid)
+	msg := NewMessageInterface(service.conn, msgObjectPath, service.msgDeleteChan, service.cancelMessage)
+	service.handlersLock.Lock()
+	service.messageHandlers[msgObjectPath] = msg
+	service.handlersLock.Unlock()
+	return service.MessageAdded(msg.GetPayload())
+}
+
+//RejectSendMessage replies to the SendMessage call for msg with a D-Bus
+//error describing err instead of a message object path.
+func (service *MMSService) RejectSendMessage(msg *OutgoingMessage, err error) error {
+	name := errorFailed
+	if vErr, ok := err.(*mms.ValidationError); ok {
+		switch vErr.Kind {
+		case mms.ErrUnsupportedMediaType:
+			name = errorUnsupportedMediaType
+		case mms.ErrTooManyRecipients:
+			name = errorTooManyRecipients
+		case mms.ErrMessageTooLarge:
+			name = errorMessageTooLarge
+		}
+	}
+	return service.conn.Send(dbus.NewErrorMessage(msg.call, name, err.Error()))
+}
+
 //TODO randomly creating a uuid until the download manager does this for us
 func (service *MMSService) genMessagePath(uuid string) dbus.ObjectPath {
 	return dbus.ObjectPath(MMS_DBUS_PATH + "/" + service.identity + "/" + uuid)
@@ -0,0 +1,268 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of telepathy.
+ *
+ * mms is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * mms is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package telepathy
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ubuntu-phonedations/nuntium/mms"
+	"github.com/ubuntu-phonedations/nuntium/storage"
+	"launchpad.net/go-dbus/v1"
+	. "launchpad.net/gocheck"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+//fakeConnection records what the service sends and hands out the channels
+//registered for object paths.
+type fakeConnection struct {
+	lock     sync.Mutex
+	sent     chan *dbus.Message
+	handlers map[dbus.ObjectPath]chan<- *dbus.Message
+}
+
+func newFakeConnection() *fakeConnection {
+	return &fakeConnection{
+		sent:     make(chan *dbus.Message, 10),
+		handlers: make(map[dbus.ObjectPath]chan<- *dbus.Message),
+	}
+}
+
+func (conn *fakeConnection) Send(msg *dbus.Message) error {
+	conn.sent <- msg
+	return nil
+}
+
+func (conn *fakeConnection) RegisterObjectPath(path dbus.ObjectPath, handler chan<- *dbus.Message) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	conn.handlers[path] = handler
+}
+
+func (conn *fakeConnection) UnregisterObjectPath(path dbus.ObjectPath) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	delete(conn.handlers, path)
+}
+
+func (conn *fakeConnection) handler(path dbus.ObjectPath) (chan<- *dbus.Message, bool) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	handler, ok := conn.handlers[path]
+	return handler, ok
+}
+
+//waitSignal returns the first signal named member sent within a second.
+func (conn *fakeConnection) waitSignal(c *C, member string) *dbus.Message {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case msg := <-conn.sent:
+			if msg.Type == dbus.TypeSignal && msg.Member == member {
+				return msg
+			}
+		case <-timeout:
+			c.Fatalf("no %s signal sent", member)
+		}
+	}
+}
+
+type ServiceTestSuite struct {
+	conn    *fakeConnection
+	service *MMSService
+	env     map[string]string
+}
+
+var _ = Suite(&ServiceTestSuite{})
+
+const testIdentity = "330000000000000"
+
+//SetUpSuite points the store to a temporary directory for the whole suite
+//as the message database stays open once used.
+func (s *ServiceTestSuite) SetUpSuite(c *C) {
+	dir := c.MkDir()
+	s.env = make(map[string]string)
+	for _, key := range []string{"XDG_DATA_HOME", "XDG_CACHE_HOME", "XDG_CONFIG_HOME"} {
+		s.env[key] = os.Getenv(key)
+		c.Assert(os.Setenv(key, filepath.Join(dir, key)), IsNil)
+	}
+	c.Assert(os.MkdirAll(filepath.Join(dir, "XDG_DATA_HOME", storage.SUBPATH), 0700), IsNil)
+}
+
+func (s *ServiceTestSuite) TearDownSuite(c *C) {
+	for key, value := range s.env {
+		os.Setenv(key, value)
+	}
+}
+
+func (s *ServiceTestSuite) SetUpTest(c *C) {
+	s.conn = newFakeConnection()
+	s.service = NewMMSService(s.conn, "/ril_0", testIdentity, nil, nil, false)
+}
+
+func (s *ServiceTestSuite) TearDownTest(c *C) {
+	s.service.Close()
+}
+
+//storeReceived stores mRetrieveConf as a message received for the identity
+//of the service.
+func storeReceived(c *C, mRetrieveConf *mms.MRetrieveConf) {
+	c.Assert(storage.Create(testIdentity, mRetrieveConf.UUID, "http://localhost:9191/mms", time.Time{}), IsNil)
+	var buf bytes.Buffer
+	c.Assert(mms.NewEncoder(&buf).Encode(mRetrieveConf), IsNil)
+	filePath, err := storage.DownloadFile(mRetrieveConf.UUID)
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(filePath, buf.Bytes(), 0600), IsNil)
+	c.Assert(storage.UpdateDownloaded(mRetrieveConf.UUID, filePath), IsNil)
+	c.Assert(storage.UpdateRetrieved(mRetrieveConf.UUID), IsNil)
+	c.Assert(storage.UpdateReceived(mRetrieveConf.UUID), IsNil)
+}
+
+func (s *ServiceTestSuite) TestGetMessagesReturnsReceived(c *C) {
+	smil := "<smil><body><par><text src=\"text0.txt\"/></par></body></smil>"
+	mRetrieveConf := &mms.MRetrieveConf{
+		UUID:    "received1",
+		Type:    mms.TYPE_RETRIEVE_CONF,
+		Version: mms.MMS_MESSAGE_VERSION_1_3,
+		From:    "+12345/TYPE=PLMN",
+		To:      []string{"+54321/TYPE=PLMN"},
+		Subject: "Hi",
+		Date:    1400000000,
+		Content: mms.Attachment{
+			MediaType: "application/vnd.wap.multipart.related",
+			Start:     "<smil>",
+			Type:      "application/smil",
+		},
+		Attachments: []mms.Attachment{
+			mms.Attachment{
+				MediaType:       "application/smil",
+				ContentId:       "<smil>",
+				ContentLocation: "smil.xml",
+				Data:            []byte(smil),
+			},
+			mms.Attachment{
+				MediaType:       "text/plain;charset=utf-8",
+				Charset:         "utf-8",
+				Name:            "text0.txt",
+				ContentId:       "<text0>",
+				ContentLocation: "text0.txt",
+				Data:            []byte("Hello world"),
+			},
+		},
+	}
+	storeReceived(c, mRetrieveConf)
+	filePath, err := storage.GetMMS(mRetrieveConf.UUID)
+	c.Assert(err, IsNil)
+
+	payloads, err := s.service.storedMessages()
+	c.Assert(err, IsNil)
+	c.Assert(payloads, HasLen, 1)
+	msgObjectPath := dbus.ObjectPath(MMS_DBUS_PATH + "/" + testIdentity + "/received1")
+	c.Check(payloads[0].Path, Equals, msgObjectPath)
+	properties := payloads[0].Properties
+	c.Check(properties["Status"].Value, Equals, "received")
+	c.Check(properties["Date"].Value, Equals, parseDate(1400000000))
+	c.Check(properties["Subject"].Value, Equals, "Hi")
+	c.Check(properties["Sender"].Value, Equals, "+12345")
+	c.Check(properties["Recipients"].Value, DeepEquals, []string{"+54321"})
+	c.Check(properties["Smil"].Value, Equals, smil)
+	c.Check(properties["Text"].Value, Equals, "Hello world")
+	attachments, ok := properties["Attachments"].Value.([]Attachment)
+	c.Assert(ok, Equals, true)
+	c.Assert(attachments, HasLen, 1)
+	c.Check(attachments[0].Id, Equals, "<text0>")
+	c.Check(attachments[0].MediaType, Equals, "text/plain;charset=utf-8")
+	c.Check(attachments[0].FilePath, Equals, filePath)
+	c.Check(attachments[0].Length, Equals, uint64(len("Hello world")))
+
+	//clients delete the message through its object
+	handler, ok := s.conn.handler(msgObjectPath)
+	c.Assert(ok, Equals, true)
+	handler <- dbus.NewMethodCallMessage("", msgObjectPath, MMS_MESSAGE_DBUS_IFACE, "Delete")
+	signal := s.conn.waitSignal(c, messageRemovedSignal)
+	c.Check(signal.Path, Equals, s.service.payload.Path)
+
+	_, ok = s.conn.handler(msgObjectPath)
+	c.Check(ok, Equals, false)
+	_, err = storage.GetMMS(mRetrieveConf.UUID)
+	c.Check(err, NotNil)
+	states, err := storage.List(testIdentity)
+	c.Assert(err, IsNil)
+	c.Check(states, HasLen, 0)
+	payloads, err = s.service.storedMessages()
+	c.Assert(err, IsNil)
+	c.Check(payloads, HasLen, 0)
+}
+
+func (s *ServiceTestSuite) TestGetMessagesSkipsPending(c *C) {
+	c.Assert(storage.Create(testIdentity, "pending1", "http://localhost:9191/mms", time.Time{}), IsNil)
+	defer storage.Destroy("pending1")
+
+	payloads, err := s.service.storedMessages()
+	c.Assert(err, IsNil)
+	c.Check(payloads, HasLen, 0)
+}
+
+func (s *ServiceTestSuite) TestIncomingMessageFailed(c *C) {
+	mNotificationInd := &mms.MNotificationInd{
+		UUID:    "failed1",
+		From:    "+12345/TYPE=PLMN",
+		Subject: "Hi",
+	}
+	payload := s.service.parseFailedMessage(mNotificationInd, PERMANENT_ERROR, &mms.CertificateError{})
+	c.Check(payload.Path, Equals, dbus.ObjectPath(MMS_DBUS_PATH+"/"+testIdentity+"/failed1"))
+	c.Check(payload.Properties["Status"].Value, Equals, PERMANENT_ERROR)
+	c.Check(payload.Properties["Error"].Value, Equals, errorCertificate)
+	c.Check(payload.Properties["Subject"].Value, Equals, "Hi")
+	c.Check(payload.Properties["Sender"].Value, Equals, "+12345")
+
+	payload = s.service.parseFailedMessage(mNotificationInd, TRANSIENT_ERROR, errors.New("timeout"))
+	c.Check(payload.Properties["Status"].Value, Equals, TRANSIENT_ERROR)
+	_, ok := payload.Properties["Error"]
+	c.Check(ok, Equals, false)
+
+	c.Assert(s.service.IncomingMessageFailed(mNotificationInd, TRANSIENT_ERROR, errors.New("timeout")), IsNil)
+	s.conn.waitSignal(c, messageAddedSignal)
+	_, ok = s.conn.handler(payload.Path)
+	c.Check(ok, Equals, false)
+}
+
+func (s *ServiceTestSuite) TestMessageCanceledNotAnnounced(c *C) {
+	c.Assert(storage.Create(testIdentity, "canceled1", "http://localhost:9191/mms", time.Time{}), IsNil)
+
+	c.Assert(s.service.MessageCanceled("canceled1"), IsNil)
+	select {
+	case msg := <-s.conn.sent:
+		c.Errorf("unexpected %s sent for a message never announced", msg.Member)
+	default:
+	}
+	states, err := storage.List(testIdentity)
+	c.Assert(err, IsNil)
+	c.Check(states, HasLen, 0)
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of telepathy.
+ *
+ * mms is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * mms is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package telepathy
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ubuntu-phonedations/nuntium/wappush"
+	"launchpad.net/go-dbus/v1"
+)
+
+//ServiceIndicationReceived stores si and signals it on the WapPush interface
+//unless it is a duplicate of one already signaled; a delete action signals
+//the removal of the indication it refers to.
+func (service *MMSService) ServiceIndicationReceived(si *wappush.ServiceIndication) error {
+	stored, deleted := service.indications.Update(si, time.Now())
+	switch {
+	case stored:
+		signal := dbus.NewSignalMessage(service.payload.Path, MMS_WAP_PUSH_DBUS_IFACE, serviceIndicationAddedSignal)
+		if err := signal.AppendArgs(serviceIndicationProperties(si)); err != nil {
+			return err
+		}
+		return service.conn.Send(signal)
+	case deleted:
+		signal := dbus.NewSignalMessage(service.payload.Path, MMS_WAP_PUSH_DBUS_IFACE, serviceIndicationRemovedSignal)
+		if err := signal.AppendArgs(si.Key()); err != nil {
+			return err
+		}
+		return service.conn.Send(signal)
+	}
+	log.Print("Discarding duplicate or expired service indication ", si.Key())
+	return nil
+}
+
+//ServiceLoadingReceived signals sl on the WapPush interface, it is up to the
+//UI to decide if the content is loaded.
+func (service *MMSService) ServiceLoadingReceived(sl *wappush.ServiceLoading) error {
+	signal := dbus.NewSignalMessage(service.payload.Path, MMS_WAP_PUSH_DBUS_IFACE, serviceLoadingReceivedSignal)
+	properties := map[string]dbus.Variant{
+		"Href":   dbus.Variant{sl.Href},
+		"Action": dbus.Variant{sl.Action},
+	}
+	if err := signal.AppendArgs(properties); err != nil {
+		return err
+	}
+	return service.conn.Send(signal)
+}
+
+func (service *MMSService) handleWapPushCall(msg *dbus.Message) {
+	var reply *dbus.Message
+	switch msg.Member {
+	case "GetServiceIndications":
+		reply = dbus.NewMethodReturnMessage(msg)
+		var indications []map[string]dbus.Variant
+		for _, si := range service.indications.List(time.Now()) {
+			indications = append(indications, serviceIndicationProperties(si))
+		}
+		if err := reply.AppendArgs(indications); err != nil {
+			log.Print("Cannot parse service indications")
+			reply = dbus.NewErrorMessage(msg, "Error.InvalidArguments", "Cannot parse service indications")
+		}
+	default:
+		log.Println("Received unkown method call on", msg.Interface, msg.Member)
+		reply = dbus.NewErrorMessage(
+			msg,
+			"org.freedesktop.DBus.Error.UnknownMethod",
+			fmt.Sprintf("No such method '%s' at object path '%s'", msg.Member, msg.Path))
+	}
+	if err := service.conn.Send(reply); err != nil {
+		log.Println("Could not send reply:", err)
+	}
+}
+
+func serviceIndicationProperties(si *wappush.ServiceIndication) map[string]dbus.Variant {
+	properties := map[string]dbus.Variant{
+		"Id":     dbus.Variant{si.Key()},
+		"Href":   dbus.Variant{si.Href},
+		"Action": dbus.Variant{si.Action},
+		"Text":   dbus.Variant{si.Text},
+	}
+	if si.Class != "" {
+		properties["Class"] = dbus.Variant{si.Class}
+	}
+	if !si.Created.IsZero() {
+		properties["Created"] = dbus.Variant{si.Created.Format(time.RFC3339)}
+	}
+	if !si.Expires.IsZero() {
+		properties["Expires"] = dbus.Variant{si.Expires.Format(time.RFC3339)}
+	}
+	return properties
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of wappush.
+ *
+ * wappush is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * wappush is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package wappush
+
+import "github.com/ubuntu-phonedations/nuntium/wbxml"
+
+//WBXML public identifiers for -//WAPFORUM//DTD SI 1.0//EN and
+//-//WAPFORUM//DTD SL 1.0//EN
+const (
+	SI_PUBLIC_ID = 0x05
+	SL_PUBLIC_ID = 0x06
+)
+
+//SILanguage holds the WBXML tokens from section 9 of WAP-167-ServiceInd
+var SILanguage = &wbxml.Language{
+	Name:     "-//WAPFORUM//DTD SI 1.0//EN",
+	PublicId: SI_PUBLIC_ID,
+	Pages: map[byte]wbxml.CodePage{
+		0: {
+			Tags: map[byte]string{
+				0x05: "si",
+				0x06: "indication",
+				0x07: "info",
+				0x08: "item",
+			},
+			AttrStarts: map[byte]string{
+				0x05: "action=signal-none",
+				0x06: "action=signal-low",
+				0x07: "action=signal-medium",
+				0x08: "action=signal-high",
+				0x09: "action=delete",
+				0x0A: "created",
+				0x0B: "href",
+				0x0C: "href=http://",
+				0x0D: "href=http://www.",
+				0x0E: "href=https://",
+				0x0F: "href=https://www.",
+				0x10: "si-expires",
+				0x11: "si-id",
+				0x12: "class",
+			},
+			AttrValues: urlValues,
+		},
+	},
+}
+
+//SLLanguage holds the WBXML tokens from section 9 of WAP-168-ServiceLoad
+var SLLanguage = &wbxml.Language{
+	Name:     "-//WAPFORUM//DTD SL 1.0//EN",
+	PublicId: SL_PUBLIC_ID,
+	Pages: map[byte]wbxml.CodePage{
+		0: {
+			Tags: map[byte]string{
+				0x05: "sl",
+			},
+			AttrStarts: map[byte]string{
+				0x05: "action=execute-low",
+				0x06: "action=execute-high",
+				0x07: "action=cache",
+				0x08: "href",
+				0x09: "href=http://",
+				0x0A: "href=http://www.",
+				0x0B: "href=https://",
+				0x0C: "href=https://www.",
+			},
+			AttrValues: urlValues,
+		},
+	},
+}
+
+var urlValues = map[byte]string{
+	0x85: ".com/",
+	0x86: ".edu/",
+	0x87: ".net/",
+	0x88: ".org/",
+}
@@ -0,0 +1,237 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of wappush.
+ *
+ * wappush is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * wappush is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+//Package wappush decodes the WAP Service Indication and Service Loading
+//content types as described in WAP-167-ServiceInd-20010731-a and
+//WAP-168-ServiceLoad-20010731-a.
+package wappush
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ubuntu-phonedations/nuntium/wbxml"
+)
+
+//Service Indication actions, signal-medium is the default
+const (
+	ACTION_SIGNAL_NONE   = "signal-none"
+	ACTION_SIGNAL_LOW    = "signal-low"
+	ACTION_SIGNAL_MEDIUM = "signal-medium"
+	ACTION_SIGNAL_HIGH   = "signal-high"
+	ACTION_DELETE        = "delete"
+)
+
+//Service Loading actions, execute-low is the default
+const (
+	ACTION_EXECUTE_LOW  = "execute-low"
+	ACTION_EXECUTE_HIGH = "execute-high"
+	ACTION_CACHE        = "cache"
+)
+
+const dateLayout = "20060102150405"
+
+type ServiceIndication struct {
+	Id      string
+	Href    string
+	Action  string
+	Class   string
+	Created time.Time
+	Expires time.Time
+	Text    string
+}
+
+type ServiceLoading struct {
+	Href   string
+	Action string
+}
+
+//Key returns the si-id, which defaults to the href when it is not set.
+func (si *ServiceIndication) Key() string {
+	if si.Id != "" {
+		return si.Id
+	}
+	return si.Href
+}
+
+//Expired checks if the si-expires date has passed at now.
+func (si *ServiceIndication) Expired(now time.Time) bool {
+	return !si.Expires.IsZero() && !now.Before(si.Expires)
+}
+
+//DecodeSI decodes a WBXML encoded Service Indication
+//(application/vnd.wap.sic).
+func DecodeSI(data []byte) (*ServiceIndication, error) {
+	doc, err := wbxml.Decode(data, SILanguage)
+	if err != nil {
+		return nil, err
+	}
+	if doc.Root.Name != "si" {
+		return nil, fmt.Errorf("unexpected root element %s in service indication", doc.Root.Name)
+	}
+	var indication *wbxml.Element
+	for _, child := range doc.Root.Children {
+		if child.Name == "indication" {
+			indication = child
+			break
+		}
+	}
+	if indication == nil {
+		return nil, errors.New("service indication without an indication element")
+	}
+
+	si := &ServiceIndication{Action: ACTION_SIGNAL_MEDIUM, Text: indication.Text}
+	si.Href, _ = indication.Attr("href")
+	si.Id, _ = indication.Attr("si-id")
+	si.Class, _ = indication.Attr("class")
+	if action, ok := indication.Attr("action"); ok {
+		si.Action = action
+	}
+	if si.Created, err = dateAttr(indication, "created"); err != nil {
+		return nil, err
+	}
+	if si.Expires, err = dateAttr(indication, "si-expires"); err != nil {
+		return nil, err
+	}
+	if si.Href == "" && si.Action != ACTION_DELETE {
+		return nil, errors.New("service indication without an href")
+	}
+	return si, nil
+}
+
+//DecodeSL decodes a WBXML encoded Service Loading
+//(application/vnd.wap.slc).
+func DecodeSL(data []byte) (*ServiceLoading, error) {
+	doc, err := wbxml.Decode(data, SLLanguage)
+	if err != nil {
+		return nil, err
+	}
+	if doc.Root.Name != "sl" {
+		return nil, fmt.Errorf("unexpected root element %s in service loading", doc.Root.Name)
+	}
+	sl := &ServiceLoading{Action: ACTION_EXECUTE_LOW}
+	if sl.Href, _ = doc.Root.Attr("href"); sl.Href == "" {
+		return nil, errors.New("service loading without an href")
+	}
+	if action, ok := doc.Root.Attr("action"); ok {
+		sl.Action = action
+	}
+	return sl, nil
+}
+
+//dateAttr decodes a date attribute, which is sent as opaque data holding
+//the BCD digits of the date with the trailing zero octets removed or as
+//text in the XML form.
+func dateAttr(elem *wbxml.Element, name string) (t time.Time, err error) {
+	if opaque, ok := elem.OpaqueAttr(name); ok {
+		if len(opaque) > 7 {
+			return t, fmt.Errorf("%s date is too long with %d octets", name, len(opaque))
+		}
+		digits := make([]byte, 0, 14)
+		for _, b := range opaque {
+			digits = append(digits, '0'+b>>4, '0'+b&0x0F)
+		}
+		for len(digits) < 14 {
+			digits = append(digits, '0')
+		}
+		if t, err = time.Parse(dateLayout, string(digits)); err != nil {
+			return t, fmt.Errorf("invalid %s date: %s", name, err)
+		}
+		return t, nil
+	}
+	if value, ok := elem.Attr(name); ok && value != "" {
+		if t, err = time.Parse(time.RFC3339, value); err != nil {
+			return t, fmt.Errorf("invalid %s date: %s", name, err)
+		}
+	}
+	return t, nil
+}
+
+//IndicationStore keeps the current Service Indications applying the
+//replacement rules from section 6.2 of WAP-167-ServiceInd.
+type IndicationStore struct {
+	lock        sync.Mutex
+	indications map[string]*ServiceIndication
+}
+
+func NewIndicationStore() *IndicationStore {
+	return &IndicationStore{indications: make(map[string]*ServiceIndication)}
+}
+
+//Update adds si to the store. It returns stored if si is new or replaced an
+//older one with the same si-id and deleted if an indication was removed
+//because of an action=delete; duplicates, out of date and expired
+//indications are discarded.
+func (store *IndicationStore) Update(si *ServiceIndication, now time.Time) (stored, deleted bool) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	key := si.Key()
+	if current, ok := store.indications[key]; ok {
+		if current.Expired(now) {
+			delete(store.indications, key)
+		} else if !si.Created.IsZero() && !current.Created.IsZero() && !si.Created.After(current.Created) {
+			return false, false
+		} else if si.Created.IsZero() && current.Href == si.Href && current.Text == si.Text {
+			return false, false
+		}
+	}
+	if si.Action == ACTION_DELETE {
+		_, deleted = store.indications[key]
+		delete(store.indications, key)
+		return false, deleted
+	}
+	if si.Expired(now) {
+		return false, false
+	}
+	store.indications[key] = si
+	return true, false
+}
+
+type byCreated []*ServiceIndication
+
+func (s byCreated) Len() int      { return len(s) }
+func (s byCreated) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byCreated) Less(i, j int) bool {
+	if s[i].Created.Equal(s[j].Created) {
+		return s[i].Key() < s[j].Key()
+	}
+	return s[i].Created.Before(s[j].Created)
+}
+
+//List returns the indications that have not expired at now, oldest first.
+func (store *IndicationStore) List(now time.Time) (indications []*ServiceIndication) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	for key, si := range store.indications {
+		if si.Expired(now) {
+			delete(store.indications, key)
+			continue
+		}
+		indications = append(indications, si)
+	}
+	sort.Sort(byCreated(indications))
+	return indications
+}
@@ -0,0 +1,153 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of wappush.
+ *
+ * wappush is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * wappush is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package wappush
+
+import (
+	"testing"
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type WapPushTestSuite struct{}
+
+var _ = Suite(&WapPushTestSuite{})
+
+func str(s string) []byte {
+	return append(append([]byte{0x03}, s...), 0x00)
+}
+
+func concat(parts ...[]byte) (b []byte) {
+	for _, part := range parts {
+		b = append(b, part...)
+	}
+	return b
+}
+
+//siExample is the WBXML encoded example from WAP-167-ServiceInd appendix B
+var siExample = concat(
+	[]byte{0x02, SI_PUBLIC_ID, 0x6A, 0x00, 0x45, 0xC6, 0x0D},
+	str("xyz"),
+	[]byte{0x85},
+	str("email/123/abc.wml"),
+	[]byte{0x0A, 0xC3, 0x07, 0x19, 0x99, 0x06, 0x25, 0x15, 0x23, 0x15},
+	[]byte{0x10, 0xC3, 0x04, 0x19, 0x99, 0x06, 0x30},
+	[]byte{0x01},
+	str("You have 4 new emails"),
+	[]byte{0x01, 0x01},
+)
+
+func (s *WapPushTestSuite) TestDecodeSI(c *C) {
+	si, err := DecodeSI(siExample)
+	c.Assert(err, IsNil)
+	c.Check(si.Href, Equals, "http://www.xyz.com/email/123/abc.wml")
+	c.Check(si.Action, Equals, ACTION_SIGNAL_MEDIUM)
+	c.Check(si.Text, Equals, "You have 4 new emails")
+	c.Check(si.Created, Equals, time.Date(1999, 6, 25, 15, 23, 15, 0, time.UTC))
+	c.Check(si.Expires, Equals, time.Date(1999, 6, 30, 0, 0, 0, 0, time.UTC))
+	c.Check(si.Key(), Equals, si.Href)
+}
+
+func (s *WapPushTestSuite) TestDecodeSIDelete(c *C) {
+	data := concat(
+		[]byte{0x02, SI_PUBLIC_ID, 0x6A, 0x00, 0x45, 0x86, 0x09, 0x11},
+		str("alert-1"),
+		[]byte{0x01, 0x01},
+	)
+	si, err := DecodeSI(data)
+	c.Assert(err, IsNil)
+	c.Check(si.Action, Equals, ACTION_DELETE)
+	c.Check(si.Key(), Equals, "alert-1")
+}
+
+func (s *WapPushTestSuite) TestDecodeSINoIndication(c *C) {
+	_, err := DecodeSI([]byte{0x02, SI_PUBLIC_ID, 0x6A, 0x00, 0x05})
+	c.Check(err, NotNil)
+}
+
+func (s *WapPushTestSuite) TestDecodeSL(c *C) {
+	data := concat(
+		[]byte{0x02, SL_PUBLIC_ID, 0x6A, 0x00, 0x85, 0x06, 0x0A},
+		str("operator"),
+		[]byte{0x85},
+		str("offers"),
+		[]byte{0x01},
+	)
+	sl, err := DecodeSL(data)
+	c.Assert(err, IsNil)
+	c.Check(sl.Href, Equals, "http://www.operator.com/offers")
+	c.Check(sl.Action, Equals, ACTION_EXECUTE_HIGH)
+}
+
+func (s *WapPushTestSuite) TestStoreDeduplicates(c *C) {
+	now := time.Date(2014, 6, 1, 0, 0, 0, 0, time.UTC)
+	store := NewIndicationStore()
+	first := &ServiceIndication{Id: "alert-1", Href: "http://a", Action: ACTION_SIGNAL_HIGH, Created: now.Add(-time.Hour)}
+	stored, _ := store.Update(first, now)
+	c.Check(stored, Equals, true)
+
+	duplicate := *first
+	stored, _ = store.Update(&duplicate, now)
+	c.Check(stored, Equals, false)
+
+	older := *first
+	older.Created = now.Add(-2 * time.Hour)
+	stored, _ = store.Update(&older, now)
+	c.Check(stored, Equals, false)
+
+	newer := *first
+	newer.Href = "http://b"
+	newer.Created = now
+	stored, _ = store.Update(&newer, now)
+	c.Check(stored, Equals, true)
+
+	list := store.List(now)
+	c.Assert(list, HasLen, 1)
+	c.Check(list[0].Href, Equals, "http://b")
+}
+
+func (s *WapPushTestSuite) TestStoreDelete(c *C) {
+	now := time.Date(2014, 6, 1, 0, 0, 0, 0, time.UTC)
+	store := NewIndicationStore()
+	store.Update(&ServiceIndication{Id: "alert-1", Href: "http://a", Action: ACTION_SIGNAL_LOW}, now)
+	store.Update(&ServiceIndication{Id: "alert-2", Href: "http://b", Action: ACTION_SIGNAL_LOW}, now)
+
+	stored, deleted := store.Update(&ServiceIndication{Id: "alert-1", Action: ACTION_DELETE}, now)
+	c.Check(stored, Equals, false)
+	c.Check(deleted, Equals, true)
+	list := store.List(now)
+	c.Assert(list, HasLen, 1)
+	c.Check(list[0].Id, Equals, "alert-2")
+}
+
+func (s *WapPushTestSuite) TestStoreExpired(c *C) {
+	now := time.Date(2014, 6, 1, 0, 0, 0, 0, time.UTC)
+	store := NewIndicationStore()
+	stored, _ := store.Update(&ServiceIndication{Href: "http://a", Expires: now.Add(-time.Minute)}, now)
+	c.Check(stored, Equals, false)
+
+	store.Update(&ServiceIndication{Href: "http://b", Expires: now.Add(time.Minute)}, now)
+	c.Check(store.List(now), HasLen, 1)
+	c.Check(store.List(now.Add(time.Hour)), HasLen, 0)
+}
@@ -0,0 +1,447 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of wbxml.
+ *
+ * wbxml is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * wbxml is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+//Package wbxml decodes WAP Binary XML documents as described in
+//WAP-192-WBXML-20010725-a into a tree of elements.
+package wbxml
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+//Global tokens from section 7.1 of WAP-192-WBXML
+const (
+	SWITCH_PAGE = 0x00
+	END         = 0x01
+	ENTITY      = 0x02
+	STR_I       = 0x03
+	LITERAL     = 0x04
+	EXT_I_0     = 0x40
+	EXT_I_1     = 0x41
+	EXT_I_2     = 0x42
+	PI          = 0x43
+	LITERAL_C   = 0x44
+	EXT_T_0     = 0x80
+	EXT_T_1     = 0x81
+	EXT_T_2     = 0x82
+	STR_T       = 0x83
+	LITERAL_A   = 0x84
+	EXT_0       = 0xC0
+	EXT_1       = 0xC1
+	EXT_2       = 0xC2
+	OPAQUE      = 0xC3
+	LITERAL_AC  = 0xC4
+)
+
+const (
+	TAG_HAS_ATTRIBUTES = 0x80
+	TAG_HAS_CONTENT    = 0x40
+	TAG_ID_MASK        = 0x3F
+)
+
+//Character sets as IANA MIBenum that are converted to UTF-8
+const (
+	CHARSET_UNKNOWN    = 0
+	CHARSET_US_ASCII   = 3
+	CHARSET_ISO_8859_1 = 4
+	CHARSET_UTF_8      = 106
+)
+
+//CodePage holds the tokens of a code page for a document type. Attribute
+//start tokens are either an attribute name or name=value-prefix.
+type CodePage struct {
+	Tags       map[byte]string
+	AttrStarts map[byte]string
+	AttrValues map[byte]string
+}
+
+//Language describes the tokens of a document type, the code pages are
+//used for both tag and attribute tokens as they are switched independently.
+type Language struct {
+	Name     string
+	PublicId uint32
+	Pages    map[byte]CodePage
+}
+
+type Attr struct {
+	Name, Value string
+	//Opaque holds the data of OPAQUE tokens in the value, which are not
+	//added to Value as their meaning depends on the document type
+	Opaque []byte
+}
+
+type Element struct {
+	Name     string
+	Attrs    []Attr
+	Children []*Element
+	Text     string
+	Opaque   []byte
+}
+
+//Attr returns the value of the named attribute.
+func (e *Element) Attr(name string) (string, bool) {
+	for _, attr := range e.Attrs {
+		if attr.Name == name {
+			return attr.Value, true
+		}
+	}
+	return "", false
+}
+
+//OpaqueAttr returns the opaque data of the named attribute.
+func (e *Element) OpaqueAttr(name string) ([]byte, bool) {
+	for _, attr := range e.Attrs {
+		if attr.Name == name && attr.Opaque != nil {
+			return attr.Opaque, true
+		}
+	}
+	return nil, false
+}
+
+type Document struct {
+	Version  byte
+	PublicId uint32
+	Charset  uint32
+	Root     *Element
+}
+
+var ErrUnexpectedEnd = errors.New("unexpected end of wbxml data")
+
+type decoder struct {
+	data     []byte
+	offset   int
+	charset  uint32
+	strtbl   []byte
+	lang     *Language
+	tagPage  byte
+	attrPage byte
+}
+
+//Decode decodes data with the tokens defined in lang. Unknown tag and
+//attribute tokens are named after their hexadecimal value so documents
+//with extensions can still be decoded.
+func Decode(data []byte, lang *Language) (*Document, error) {
+	dec := &decoder{data: data, lang: lang}
+	doc := new(Document)
+	var err error
+	if doc.Version, err = dec.readByte(); err != nil {
+		return nil, err
+	}
+	if doc.PublicId, err = dec.readMbUint32(); err != nil {
+		return nil, err
+	}
+	if doc.PublicId == 0 {
+		// the public identifier is a string table reference
+		if _, err = dec.readMbUint32(); err != nil {
+			return nil, err
+		}
+	}
+	if doc.Charset, err = dec.readMbUint32(); err != nil {
+		return nil, err
+	}
+	dec.charset = doc.Charset
+	strtblLen, err := dec.readMbUint32()
+	if err != nil {
+		return nil, err
+	}
+	if dec.strtbl, err = dec.readBytes(int(strtblLen)); err != nil {
+		return nil, err
+	}
+
+	for doc.Root == nil {
+		tok, err := dec.readByte()
+		if err != nil {
+			return nil, err
+		}
+		switch tok {
+		case SWITCH_PAGE:
+			if dec.tagPage, err = dec.readByte(); err != nil {
+				return nil, err
+			}
+		case PI:
+			return nil, errors.New("processing instructions are not supported")
+		default:
+			if doc.Root, err = dec.readElement(tok); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return doc, nil
+}
+
+func (dec *decoder) readByte() (byte, error) {
+	if dec.offset >= len(dec.data) {
+		return 0, ErrUnexpectedEnd
+	}
+	b := dec.data[dec.offset]
+	dec.offset++
+	return b, nil
+}
+
+func (dec *decoder) readBytes(n int) ([]byte, error) {
+	if n < 0 || dec.offset+n > len(dec.data) {
+		return nil, ErrUnexpectedEnd
+	}
+	b := dec.data[dec.offset : dec.offset+n]
+	dec.offset += n
+	return b, nil
+}
+
+//readMbUint32 reads a multi-byte integer as described in section 5.1 of
+//WAP-192-WBXML, which has the same encoding as a WSP uintvar.
+func (dec *decoder) readMbUint32() (uint32, error) {
+	var v uint32
+	for i := 0; i < 5; i++ {
+		b, err := dec.readByte()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<7 | uint32(b&0x7F)
+		if b&0x80 == 0 {
+			return v, nil
+		}
+	}
+	return 0, errors.New("multi-byte integer is longer than 32 bits")
+}
+
+func (dec *decoder) readInlineString() (string, error) {
+	for i := dec.offset; i < len(dec.data); i++ {
+		if dec.data[i] == 0 {
+			s := dec.toUTF8(dec.data[dec.offset:i])
+			dec.offset = i + 1
+			return s, nil
+		}
+	}
+	return "", ErrUnexpectedEnd
+}
+
+func (dec *decoder) readTableString() (string, error) {
+	index, err := dec.readMbUint32()
+	if err != nil {
+		return "", err
+	}
+	if int(index) >= len(dec.strtbl) {
+		return "", fmt.Errorf("string table reference %d out of bounds", index)
+	}
+	end := strings.IndexByte(string(dec.strtbl[index:]), 0)
+	if end == -1 {
+		return "", fmt.Errorf("string table reference %d is not terminated", index)
+	}
+	return dec.toUTF8(dec.strtbl[index : int(index)+end]), nil
+}
+
+func (dec *decoder) readEntity() (string, error) {
+	r, err := dec.readMbUint32()
+	if err != nil {
+		return "", err
+	}
+	return string(rune(r)), nil
+}
+
+func (dec *decoder) readOpaque() ([]byte, error) {
+	length, err := dec.readMbUint32()
+	if err != nil {
+		return nil, err
+	}
+	return dec.readBytes(int(length))
+}
+
+func (dec *decoder) toUTF8(b []byte) string {
+	if dec.charset == CHARSET_ISO_8859_1 || (dec.charset != CHARSET_UTF_8 && !utf8.Valid(b)) {
+		runes := make([]rune, len(b))
+		for i := range b {
+			runes[i] = rune(b[i])
+		}
+		return string(runes)
+	}
+	return string(b)
+}
+
+func (dec *decoder) page(index byte) CodePage {
+	if dec.lang == nil {
+		return CodePage{}
+	}
+	return dec.lang.Pages[index]
+}
+
+func (dec *decoder) readElement(tok byte) (*Element, error) {
+	elem := new(Element)
+	var err error
+	switch tok &^ (TAG_HAS_ATTRIBUTES | TAG_HAS_CONTENT) {
+	case LITERAL:
+		if elem.Name, err = dec.readTableString(); err != nil {
+			return nil, err
+		}
+	default:
+		id := tok & TAG_ID_MASK
+		var ok bool
+		if elem.Name, ok = dec.page(dec.tagPage).Tags[id]; !ok {
+			elem.Name = fmt.Sprintf("%#x:%#x", dec.tagPage, id)
+		}
+	}
+	if tok&TAG_HAS_ATTRIBUTES != 0 {
+		if err := dec.readAttributes(elem); err != nil {
+			return nil, err
+		}
+	}
+	if tok&TAG_HAS_CONTENT != 0 {
+		if err := dec.readContent(elem); err != nil {
+			return nil, err
+		}
+	}
+	return elem, nil
+}
+
+func (dec *decoder) readContent(elem *Element) error {
+	for {
+		tok, err := dec.readByte()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case END:
+			return nil
+		case SWITCH_PAGE:
+			if dec.tagPage, err = dec.readByte(); err != nil {
+				return err
+			}
+		case STR_I:
+			s, err := dec.readInlineString()
+			if err != nil {
+				return err
+			}
+			elem.Text += s
+		case STR_T:
+			s, err := dec.readTableString()
+			if err != nil {
+				return err
+			}
+			elem.Text += s
+		case ENTITY:
+			s, err := dec.readEntity()
+			if err != nil {
+				return err
+			}
+			elem.Text += s
+		case OPAQUE:
+			b, err := dec.readOpaque()
+			if err != nil {
+				return err
+			}
+			elem.Opaque = append(elem.Opaque, b...)
+		case PI, EXT_I_0, EXT_I_1, EXT_I_2, EXT_T_0, EXT_T_1, EXT_T_2, EXT_0, EXT_1, EXT_2:
+			return fmt.Errorf("unsupported wbxml token %#x at %d", tok, dec.offset-1)
+		default:
+			child, err := dec.readElement(tok)
+			if err != nil {
+				return err
+			}
+			elem.Children = append(elem.Children, child)
+		}
+	}
+}
+
+func (dec *decoder) readAttributes(elem *Element) error {
+	var attr *Attr
+	for {
+		tok, err := dec.readByte()
+		if err != nil {
+			return err
+		}
+		switch {
+		case tok == END:
+			return nil
+		case tok == SWITCH_PAGE:
+			if dec.attrPage, err = dec.readByte(); err != nil {
+				return err
+			}
+		case tok == LITERAL:
+			name, err := dec.readTableString()
+			if err != nil {
+				return err
+			}
+			elem.Attrs = append(elem.Attrs, Attr{Name: name})
+			attr = &elem.Attrs[len(elem.Attrs)-1]
+		case tok >= EXT_I_0 && tok <= PI:
+			return fmt.Errorf("unsupported wbxml attribute token %#x at %d", tok, dec.offset-1)
+		case tok < 0x80 && tok != STR_I && tok != ENTITY:
+			start, ok := dec.page(dec.attrPage).AttrStarts[tok]
+			if !ok {
+				start = fmt.Sprintf("%#x:%#x", dec.attrPage, tok)
+			}
+			newAttr := Attr{Name: start}
+			if i := strings.Index(start, "="); i != -1 {
+				newAttr.Name = start[:i]
+				newAttr.Value = start[i+1:]
+			}
+			elem.Attrs = append(elem.Attrs, newAttr)
+			attr = &elem.Attrs[len(elem.Attrs)-1]
+		default:
+			if attr == nil {
+				return fmt.Errorf("attribute value token %#x without attribute at %d", tok, dec.offset-1)
+			}
+			if err := dec.readAttrValue(tok, attr); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (dec *decoder) readAttrValue(tok byte, attr *Attr) error {
+	switch tok {
+	case STR_I:
+		s, err := dec.readInlineString()
+		if err != nil {
+			return err
+		}
+		attr.Value += s
+	case STR_T:
+		s, err := dec.readTableString()
+		if err != nil {
+			return err
+		}
+		attr.Value += s
+	case ENTITY:
+		s, err := dec.readEntity()
+		if err != nil {
+			return err
+		}
+		attr.Value += s
+	case OPAQUE:
+		b, err := dec.readOpaque()
+		if err != nil {
+			return err
+		}
+		attr.Opaque = append(attr.Opaque, b...)
+	case EXT_T_0, EXT_T_1, EXT_T_2, EXT_0, EXT_1, EXT_2, LITERAL_A, LITERAL_AC:
+		return fmt.Errorf("unsupported wbxml attribute token %#x at %d", tok, dec.offset-1)
+	default:
+		value, ok := dec.page(dec.attrPage).AttrValues[tok]
+		if !ok {
+			return fmt.Errorf("unknown attribute value token %#x in page %d", tok, dec.attrPage)
+		}
+		attr.Value += value
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of wbxml.
+ *
+ * wbxml is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * wbxml is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package wbxml
+
+import (
+	"testing"
+
+	. "launchpad.net/gocheck"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type WBXMLTestSuite struct{}
+
+var _ = Suite(&WBXMLTestSuite{})
+
+var testLanguage = &Language{
+	Name: "-//TEST//DTD",
+	Pages: map[byte]CodePage{
+		0: {
+			Tags:       map[byte]string{0x05: "root", 0x06: "item"},
+			AttrStarts: map[byte]string{0x05: "name", 0x06: "kind=BIG"},
+			AttrValues: map[byte]string{0x85: ".com"},
+		},
+		1: {
+			Tags: map[byte]string{0x05: "other"},
+		},
+	},
+}
+
+func (s *WBXMLTestSuite) TestDecode(c *C) {
+	data := []byte{
+		0x03, 0x01, 0x6A, 0x04, 't', 'x', 't', 0x00,
+		0x45,
+		0xC6, 0x05, 0x03, 'a', 0x00, 0x85, 0x06, 0x01,
+		0x83, 0x00,
+		0x01,
+		0x00, 0x01, 0x45, 0xC3, 0x02, 0xCA, 0xFE, 0x01,
+		0x06,
+		0x01,
+	}
+	doc, err := Decode(data, testLanguage)
+	c.Assert(err, IsNil)
+	c.Check(doc.Version, Equals, byte(0x03))
+	c.Check(doc.Charset, Equals, uint32(CHARSET_UTF_8))
+	c.Assert(doc.Root.Name, Equals, "root")
+	c.Assert(doc.Root.Children, HasLen, 3)
+
+	item := doc.Root.Children[0]
+	c.Check(item.Name, Equals, "item")
+	name, ok := item.Attr("name")
+	c.Check(ok, Equals, true)
+	c.Check(name, Equals, "a.com")
+	kind, _ := item.Attr("kind")
+	c.Check(kind, Equals, "BIG")
+	c.Check(item.Text, Equals, "txt")
+
+	other := doc.Root.Children[1]
+	c.Check(other.Name, Equals, "other")
+	c.Check(other.Opaque, DeepEquals, []byte{0xCA, 0xFE})
+
+	c.Check(doc.Root.Children[2].Name, Equals, "0x1:0x6")
+}
+
+func (s *WBXMLTestSuite) TestDecodeTruncated(c *C) {
+	_, err := Decode([]byte{0x03, 0x01, 0x6A, 0x00, 0xC5}, testLanguage)
+	c.Check(err, Equals, ErrUnexpectedEnd)
+}
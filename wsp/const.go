@@ -0,0 +1,253 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of wsp.
+ *
+ * wsp is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * wsp is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package wsp
+
+//PDU is a WSP PDU type as assigned in Table 34. PDU Type Assignments -
+//Appendix A Assigned Numbers in WAP-230-WSP
+type PDU byte
+
+const (
+	CONNECT        PDU = 0x01
+	CONNECT_REPLY  PDU = 0x02
+	REDIRECT       PDU = 0x03
+	REPLY          PDU = 0x04
+	DISCONNECT     PDU = 0x05
+	PUSH           PDU = 0x06
+	CONFIRMED_PUSH PDU = 0x07
+	SUSPEND        PDU = 0x08
+	RESUME         PDU = 0x09
+	GET            PDU = 0x40
+	POST           PDU = 0x60
+)
+
+//These are the WSP assigned numbers from Table 38 . Well-Known Parameter
+//Assignments - Appendix A Assigned Numbers in WAP-230-WSP
+const (
+	PARAMETER_TYPE_Q                  = 0x00 // Version 1.1 Q-value
+	PARAMETER_TYPE_CHARSET            = 0x01 // Version 1.1 Well-known-charset
+	PARAMETER_TYPE_LEVEL              = 0x02 // Version 1.1 Version-value
+	PARAMETER_TYPE_TYPE               = 0x03 // Version 1.1 Integer-value
+	PARAMETER_TYPE_NAME_DEFUNCT       = 0x05 // Version 1.1 Text-string
+	PARAMETER_TYPE_FILENAME_DEFUNCT   = 0x06 // Version 1.1 Text-string
+	PARAMETER_TYPE_DIFFERENCES        = 0x07 // Version 1.1 Field-name
+	PARAMETER_TYPE_PADDING            = 0x08 // Version 1.1 Short-integer
+	PARAMETER_TYPE_CONTENT_TYPE       = 0x09 // Version 1.2 Constrained-encoding
+	PARAMETER_TYPE_START_DEFUNCT      = 0x0A // Version 1.2 Text-string
+	PARAMETER_TYPE_START_INFO_DEFUNCT = 0x0B // Version 1.2 Text-string
+	PARAMETER_TYPE_COMMENT_DEFUNCT    = 0x0C // Version 1.3 Text-string
+	PARAMETER_TYPE_DOMAIN_DEFUNCT     = 0x0D // Version 1.3 Text-string
+	PARAMETER_TYPE_MAX_AGE            = 0x0E // Version 1.3 Delta-seconds-value
+	PARAMETER_TYPE_PATH_DEFUNCT       = 0x0F // Version 1.3 Text-string
+	PARAMETER_TYPE_SECURE             = 0x10 // Version 1.3 No-value
+	PARAMETER_TYPE_SEC                = 0x11 // Version 1.4 Short-integer
+	PARAMETER_TYPE_MAC                = 0x12 // Version 1.4 Text-value
+	PARAMETER_TYPE_CREATION_DATE      = 0x13 // Version 1.4 Date-value
+	PARAMETER_TYPE_MODIFICATION_DATE  = 0x14 // Version 1.4 Date-value
+	PARAMETER_TYPE_READ_DATE          = 0x15 // Version 1.4 Date-value
+	PARAMETER_TYPE_SIZE               = 0x16 // Version 1.4 Integer-value
+	PARAMETER_TYPE_NAME               = 0x17 // Version 1.4 Text-value
+	PARAMETER_TYPE_FILENAME           = 0x18 // Version 1.4 Text-value
+	PARAMETER_TYPE_START              = 0x19 // Version 1.4 Text-value
+	PARAMETER_TYPE_START_INFO         = 0x1A // Version 1.4 Text-value
+	PARAMETER_TYPE_COMMENT            = 0x1B // Version 1.4 Text-value
+	PARAMETER_TYPE_DOMAIN             = 0x1C // Version 1.4 Text-value
+	PARAMETER_TYPE_PATH               = 0x1D // Version 1.4 Text-value
+	PARAMETER_TYPE_UNTYPED            = 0xFF // Version 1.4 Text-value
+)
+
+//These are the WSP assigned numbers from Table 39 . Header Field Name
+//Assignments - Appendix A Assigned Numbers in WAP-230-WSP
+const (
+	ACCEPT                = 0x00
+	ACCEPT_CHARSET_1      = 0x01
+	ACCEPT_ENCODING_1     = 0x02
+	ACCEPT_LANGUAGE       = 0x03
+	ACCEPT_RANGES         = 0x04
+	AGE                   = 0x05
+	ALLOW                 = 0x06
+	AUTHORIZATION         = 0x07
+	CACHE_CONTROL_1       = 0x08
+	CONNECTION            = 0x09
+	CONTENT_BASE          = 0x0A
+	CONTENT_ENCODING      = 0x0B
+	CONTENT_LANGUAGE      = 0x0C
+	CONTENT_LENGTH        = 0x0D
+	CONTENT_LOCATION      = 0x0E
+	CONTENT_MD5           = 0x0F
+	CONTENT_RANGE_1       = 0x10
+	CONTENT_TYPE          = 0x11
+	DATE                  = 0x12
+	ETAG                  = 0x13
+	EXPIRES               = 0x14
+	FROM                  = 0x15
+	HOST                  = 0x16
+	IF_MODIFIED_SINCE     = 0x17
+	IF_MATCH              = 0x18
+	IF_NONE_MATCH         = 0x19
+	IF_RANGE              = 0x1A
+	IF_UNMODIFIED_SINCE   = 0x1B
+	LOCATION              = 0x1C
+	LAST_MODIFIED         = 0x1D
+	MAX_FORWARDS          = 0x1E
+	PRAGMA                = 0x1F
+	PROXY_AUTHENTICATE    = 0x20
+	PROXY_AUTHORIZATION   = 0x21
+	PUBLIC                = 0x22
+	RANGE                 = 0x23
+	REFERER               = 0x24
+	RETRY_AFTER           = 0x25
+	SERVER                = 0x26
+	TRANSFER_ENCODING     = 0x27
+	UPGRADE               = 0x28
+	USER_AGENT            = 0x29
+	VARY                  = 0x2A
+	VIA                   = 0x2B
+	WARNING               = 0x2C
+	WWW_AUTHENTICATE      = 0x2D
+	CONTENT_DISPOSITION_1 = 0x2E
+	X_WAP_APPLICATION_ID  = 0x2F
+	X_WAP_CONTENT_URI     = 0x30
+	X_WAP_INITIATOR_URI   = 0x31
+	ACCEPT_APPLICATION    = 0x32
+	BEARER_INDICATION     = 0x33
+	PUSH_FLAG             = 0x34
+	PROFILE               = 0x35
+	PROFILE_DIFF          = 0x36
+	PROFILE_WARNING_1     = 0x37
+	EXPECT                = 0x38
+	TE                    = 0x39
+	TRAILER               = 0x3A
+	ACCEPT_CHARSET        = 0x3B
+	ACCEPT_ENCODING       = 0x3C
+	CACHE_CONTROL_2       = 0x3D
+	CONTENT_RANGE         = 0x3E
+	X_WAP_TOD             = 0x3F
+	CONTENT_ID            = 0x40
+	SET_COOKIE            = 0x41
+	COOKIE                = 0x42
+	ENCODING_VERSION      = 0x43
+	PROFILE_WARNING       = 0x44
+	CONTENT_DISPOSITION   = 0x45
+	X_WAP_SECURITY        = 0x46
+	CACHE_CONTROL         = 0x47
+)
+
+//WSP encoding versions as used by the Encoding-Version header and by the
+//version column of the assigned numbers tables
+const (
+	VERSION_1_1 = 0x11
+	VERSION_1_2 = 0x12
+	VERSION_1_3 = 0x13
+	VERSION_1_4 = 0x14
+)
+
+const (
+	TEXT_MAX         = 127
+	TEXT_MIN         = 32
+	SHORT_LENGTH_MAX = 30
+	LENGTH_QUOTE     = 31
+	STRING_QUOTE     = 34
+	SHORT_FILTER     = 0x80
+)
+
+const (
+	ANY_CHARSET = 128
+)
+
+var CONTENT_TYPES []string = []string{
+	"*/*", "text/*", "text/html", "text/plain",
+	"text/x-hdml", "text/x-ttml", "text/x-vCalendar",
+	"text/x-vCard", "text/vnd.wap.wml",
+	"text/vnd.wap.wmlscript", "text/vnd.wap.wta-event",
+	"multipart/*", "multipart/mixed", "multipart/form-data",
+	"multipart/byterantes", "multipart/alternative",
+	"application/*", "application/java-vm",
+	"application/x-www-form-urlencoded",
+	"application/x-hdmlc", "application/vnd.wap.wmlc",
+	"application/vnd.wap.wmlscriptc",
+	"application/vnd.wap.wta-eventc",
+	"application/vnd.wap.uaprof",
+	"application/vnd.wap.wtls-ca-certificate",
+	"application/vnd.wap.wtls-user-certificate",
+	"application/x-x509-ca-cert",
+	"application/x-x509-user-cert",
+	"image/*", "image/gif", "image/jpeg", "image/tiff",
+	"image/png", "image/vnd.wap.wbmp",
+	"application/vnd.wap.multipart.*",
+	"application/vnd.wap.multipart.mixed",
+	"application/vnd.wap.multipart.form-data",
+	"application/vnd.wap.multipart.byteranges",
+	"application/vnd.wap.multipart.alternative",
+	"application/xml", "text/xml",
+	"application/vnd.wap.wbxml",
+	"application/x-x968-cross-cert",
+	"application/x-x968-ca-cert",
+	"application/x-x968-user-cert",
+	"text/vnd.wap.si",
+	"application/vnd.wap.sic",
+	"text/vnd.wap.sl",
+	"application/vnd.wap.slc",
+	"text/vnd.wap.co",
+	"application/vnd.wap.coc",
+	"application/vnd.wap.multipart.related",
+	"application/vnd.wap.sia",
+	"text/vnd.wap.connectivity-xml",
+	"application/vnd.wap.connectivity-wbxml",
+	"application/pkcs7-mime",
+	"application/vnd.wap.hashed-certificate",
+	"application/vnd.wap.signed-certificate",
+	"application/vnd.wap.cert-response",
+	"application/xhtml+xml",
+	"application/wml+xml",
+	"text/css",
+	"application/vnd.wap.mms-message",
+	"application/vnd.wap.rollover-certificate",
+	"application/vnd.wap.locc+wbxml",
+	"application/vnd.wap.loc+xml",
+	"application/vnd.syncml.dm+wbxml",
+	"application/vnd.syncml.dm+xml",
+	"application/vnd.syncml.notification",
+	"application/vnd.wap.xhtml+xml",
+	"application/vnd.wv.csp.cir",
+	"application/vnd.oma.dd+xml",
+	"application/vnd.oma.drm.message",
+	"application/vnd.oma.drm.content",
+	"application/vnd.oma.drm.rights+xml",
+	"application/vnd.oma.drm.rights+wbxml",
+}
+
+var CHARSETS map[uint64]string = map[uint64]string{
+	0x07EA: "big5",
+	0x03E8: "iso-10646-ucs-2",
+	0x04:   "iso-8859-1",
+	0x05:   "iso-8859-2",
+	0x06:   "iso-8859-3",
+	0x07:   "iso-8859-4",
+	0x08:   "iso-8859-5",
+	0x09:   "iso-8859-6",
+	0x0A:   "iso-8859-7",
+	0x0B:   "iso-8859-8",
+	0x0C:   "iso-8859-9",
+	0x11:   "shift_JIS",
+	0x03:   "us-ascii",
+	0x6A:   "utf-8",
+}
@@ -0,0 +1,499 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of wsp.
+ *
+ * wsp is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * wsp is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package wsp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//Decoder reads values encoded with the Basic Rules described in section
+//8.4.2 of WAP-230-WSP-20010705-a.
+//
+//Offset always points to the last byte consumed, so every read starts at
+//Offset+1.
+type Decoder struct {
+	Data   []byte
+	Offset int
+	log    string
+}
+
+func NewDecoder(data []byte) *Decoder {
+	return &Decoder{Data: data}
+}
+
+func (dec *Decoder) Logf(format string, a ...interface{}) {
+	dec.log = dec.log + fmt.Sprintf(format, a...)
+}
+
+func (dec *Decoder) GetLog() string {
+	return dec.log
+}
+
+//Peek returns the next byte to be read without consuming it
+func (dec *Decoder) Peek() (byte, error) {
+	if dec.Offset+1 >= len(dec.Data) {
+		return 0, fmt.Errorf("reached end of data at offset %d", dec.Offset)
+	}
+	return dec.Data[dec.Offset+1], nil
+}
+
+func (dec *Decoder) ReadString() (string, error) {
+	dec.Offset++
+	if dec.Offset >= len(dec.Data) {
+		return "", fmt.Errorf("reached end of data while trying to read string")
+	}
+	if dec.Data[dec.Offset] == STRING_QUOTE { // Skip the quote char(34) == "
+		dec.Offset++
+	}
+	begin := dec.Offset
+	for ; len(dec.Data) > dec.Offset; dec.Offset++ {
+		if dec.Data[dec.Offset] == 0 {
+			break
+		}
+	}
+	if len(dec.Data) == dec.Offset {
+		return "", fmt.Errorf("reached end of data while trying to read string: %s", dec.Data[begin:])
+	}
+	return string(dec.Data[begin:dec.Offset]), nil
+}
+
+func (dec *Decoder) ReadShortInteger() (byte, error) {
+	dec.Offset++
+	if dec.Offset >= len(dec.Data) {
+		return 0, fmt.Errorf("reached end of data while trying to read short integer")
+	}
+	/*
+		TODO fix use of short when not short
+		if dec.Data[dec.Offset] & 0x80 == 0 {
+			return 0, fmt.Errorf("Data on offset %d with value %#x is not a short integer", dec.Offset, dec.Data[dec.Offset])
+		}
+	*/
+	return dec.Data[dec.Offset] & 0x7F, nil
+}
+
+func (dec *Decoder) ReadByte() (byte, error) {
+	dec.Offset++
+	if dec.Offset >= len(dec.Data) {
+		return 0, fmt.Errorf("reached end of data while trying to read byte")
+	}
+	return dec.Data[dec.Offset], nil
+}
+
+//ReadBoundedBytes returns the bytes from the current position up to end,
+//leaving Offset on the last byte read.
+func (dec *Decoder) ReadBoundedBytes(end int) ([]byte, error) {
+	if end > len(dec.Data) || end < dec.Offset {
+		return nil, fmt.Errorf("cannot read bytes up to %d with data length %d", end, len(dec.Data))
+	}
+	v := []byte(dec.Data[dec.Offset:end])
+	dec.Offset = end - 1
+	return v, nil
+}
+
+// A UintVar is a variable lenght uint of up to 5 octects long where
+// more octects available are indicated with the most significant bit
+// set to 1
+func (dec *Decoder) ReadUintVar() (value uint64, err error) {
+	dec.Offset++
+	for ; dec.Offset < len(dec.Data) && dec.Data[dec.Offset]>>7 == 0x01; dec.Offset++ {
+		value = value << 7
+		value |= uint64(dec.Data[dec.Offset] & 0x7F)
+	}
+	if dec.Offset >= len(dec.Data) {
+		return 0, fmt.Errorf("reached end of data while trying to read uintvar")
+	}
+	value = value << 7
+	value |= uint64(dec.Data[dec.Offset] & 0x7F)
+	return value, nil
+}
+
+func (dec *Decoder) ReadInteger() (uint64, error) {
+	param, err := dec.Peek()
+	if err != nil {
+		return 0, err
+	}
+	if param&0x80 != 0 {
+		v, err := dec.ReadShortInteger()
+		return uint64(v), err
+	}
+	return dec.ReadLongInteger()
+}
+
+func (dec *Decoder) ReadLongInteger() (uint64, error) {
+	dec.Offset++
+	if dec.Offset >= len(dec.Data) {
+		return 0, fmt.Errorf("reached end of data while trying to read long integer")
+	}
+	size := int(dec.Data[dec.Offset])
+	if size > SHORT_LENGTH_MAX {
+		return 0, fmt.Errorf("cannot encode long integer, lenght was %d but expected %d", size, SHORT_LENGTH_MAX)
+	}
+	dec.Offset++
+	end := dec.Offset + size
+	if end > len(dec.Data) {
+		return 0, fmt.Errorf("reached end of data while trying to read long integer")
+	}
+	var v uint64
+	for ; dec.Offset < end; dec.Offset++ {
+		v = v << 8
+		v |= uint64(dec.Data[dec.Offset])
+	}
+	dec.Offset--
+	return v, nil
+}
+
+// ReadLength reads the length from the next position according to section
+// 8.4.2.2 of WAP-230-WSP-20010705-a.
+//
+// Value-length = Short-length | (Length-quote Length)
+// ; Value length is used to indicate the length of the value to follow
+// Short-length = <Any octet 0-30> (0x7f to check for short)
+// Length-quote = <Octet 31>
+// Length = Uintvar-integer
+func (dec *Decoder) ReadLength() (length uint64, err error) {
+	next, err := dec.Peek()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case next&0x7f <= SHORT_LENGTH_MAX:
+		l, err := dec.ReadShortInteger()
+		return uint64(l), err
+	case next == LENGTH_QUOTE:
+		dec.Offset++
+		return dec.ReadUintVar()
+	}
+	return 0, fmt.Errorf("Unhandled length %#x @%d", next, dec.Offset+1)
+}
+
+func (dec *Decoder) ReadCharset() (string, error) {
+	if dec.Offset >= len(dec.Data) {
+		return "", fmt.Errorf("reached end of data while trying to read charset")
+	}
+	if dec.Data[dec.Offset] == ANY_CHARSET {
+		dec.Offset++
+		return "*", nil
+	}
+	charCode, err := dec.ReadInteger()
+	if err != nil {
+		return "", err
+	}
+	charset, ok := CHARSETS[charCode]
+	if !ok {
+		return "", fmt.Errorf("Cannot find matching charset for %#x == %d", charCode, charCode)
+	}
+	return charset, nil
+}
+
+//ReadEncodedString reads an Encoded-string-value discarding the charset
+//information.
+func (dec *Decoder) ReadEncodedString() (string, error) {
+	str, _, err := dec.ReadEncodedStringCharset()
+	return str, err
+}
+
+//ReadEncodedStringCharset reads an Encoded-string-value as defined in
+//OMA-WAP-MMS-ENC-v1.1 section 7.2.9
+//
+//Encoded-string-value = Text-string | Value-length Char-set Text-string
+func (dec *Decoder) ReadEncodedStringCharset() (string, string, error) {
+	next, err := dec.Peek()
+	if err != nil {
+		return "", "", err
+	}
+	var length uint64
+	switch {
+	case next < SHORT_LENGTH_MAX:
+		var l byte
+		l, err = dec.ReadShortInteger()
+		length = uint64(l)
+	case next == LENGTH_QUOTE:
+		dec.Offset++
+		length, err = dec.ReadUintVar()
+	}
+	if err != nil {
+		return "", "", err
+	}
+	var charset string
+	if length != 0 {
+		if charset, err = dec.ReadCharset(); err != nil {
+			return "", "", err
+		}
+		dec.Logf("Next string encoded with: %s\n", charset)
+	}
+	str, err := dec.ReadString()
+	if err != nil {
+		return "", "", err
+	}
+	return str, charset, nil
+}
+
+//ReadMediaType reads a Content-type-value skipping any parameters.
+func (dec *Decoder) ReadMediaType() (mediaType string, err error) {
+	var endOffset int
+	origOffset := dec.Offset
+
+	next, err := dec.Peek()
+	if err != nil {
+		return "", err
+	}
+	if next <= SHORT_LENGTH_MAX || next == LENGTH_QUOTE {
+		if length, err := dec.ReadLength(); err != nil {
+			return "", err
+		} else {
+			endOffset = int(length) + dec.Offset
+		}
+		if next, err = dec.Peek(); err != nil {
+			return "", err
+		}
+	}
+
+	if next >= TEXT_MIN && next <= TEXT_MAX {
+		if mediaType, err = dec.ReadString(); err != nil {
+			return "", err
+		}
+	} else if mt, err := dec.ReadInteger(); err == nil && len(CONTENT_TYPES) > int(mt) {
+		mediaType = CONTENT_TYPES[mt]
+	} else {
+		return "", fmt.Errorf("cannot decode media type for field beginning with %#x@%d", dec.Data[origOffset], origOffset)
+	}
+
+	// skip the rest of the content type params
+	if endOffset > 0 {
+		dec.Offset = endOffset
+	}
+	return mediaType, nil
+}
+
+//ReadQ reads a Q-value as defined in section 8.4.2.3 of
+//WAP-230-WSP-20010705-a.
+func (dec *Decoder) ReadQ() (float64, error) {
+	v, err := dec.ReadUintVar()
+	if err != nil {
+		return 0, err
+	}
+	q := float64(v)
+	if q > 100 {
+		q = (q - 100) / 1000
+	} else {
+		q = (q - 1) / 100
+	}
+	return q, nil
+}
+
+//SkipFieldValue skips over the next field value without interpreting it.
+func (dec *Decoder) SkipFieldValue() error {
+	next, err := dec.Peek()
+	if err != nil {
+		return err
+	}
+	switch {
+	case next < LENGTH_QUOTE:
+		l, err := dec.ReadByte()
+		if err != nil {
+			return err
+		}
+		length := int(l)
+		if dec.Offset+length >= len(dec.Data) {
+			return fmt.Errorf("Bad field value length")
+		}
+		dec.Offset += length
+		return nil
+	case next == LENGTH_QUOTE:
+		dec.Offset++
+		if dec.Offset+1 >= len(dec.Data) {
+			return fmt.Errorf("Bad uintvar")
+		}
+		l, err := dec.ReadUintVar()
+		if err != nil {
+			return err
+		}
+		length := int(l)
+		if dec.Offset+length >= len(dec.Data) {
+			return fmt.Errorf("Bad field value length")
+		}
+		dec.Offset += length
+		return nil
+	case next <= TEXT_MAX:
+		_, err := dec.ReadString()
+		return err
+	}
+	// case next > TEXT_MAX
+	_, err = dec.ReadShortInteger()
+	return err
+}
+
+//Values for the SEC content type parameter as defined in section 5.3 of
+//OMA-WAP-ProvBoot
+var SEC_METHODS = map[byte]string{
+	0: "NETWPIN",
+	1: "USERPIN",
+	2: "USERNETWPIN",
+	3: "USERPINMAC",
+}
+
+//ReadContentType reads a Content-type-value as described in section 8.4.2.24
+//of WAP-230-WSP-20010705-a returning the media type and its parameters keyed
+//by their lower case name. Parameters that cannot be decoded are skipped.
+func (dec *Decoder) ReadContentType() (mediaType string, params map[string]string, err error) {
+	params = make(map[string]string)
+	next, err := dec.Peek()
+	if err != nil {
+		return "", nil, err
+	}
+	if next > SHORT_LENGTH_MAX && next != LENGTH_QUOTE {
+		mediaType, err = dec.ReadMediaType()
+		return mediaType, params, err
+	}
+
+	length, err := dec.ReadLength()
+	if err != nil {
+		return "", nil, err
+	}
+	endOffset := int(length) + dec.Offset
+	if endOffset >= len(dec.Data) {
+		return "", nil, fmt.Errorf("content type length %d exceeds data length", length)
+	}
+	if next, err = dec.Peek(); err != nil {
+		return "", nil, err
+	}
+	if next >= TEXT_MIN && next <= TEXT_MAX {
+		if mediaType, err = dec.ReadString(); err != nil {
+			return "", nil, err
+		}
+	} else if mt, err := dec.ReadInteger(); err == nil && len(CONTENT_TYPES) > int(mt) {
+		mediaType = CONTENT_TYPES[mt]
+	} else {
+		return "", nil, fmt.Errorf("cannot decode media type for field beginning with %#x@%d", next, dec.Offset)
+	}
+
+	for dec.Offset < endOffset {
+		if err := dec.readParameter(params); err != nil {
+			dec.Logf("Skipping content type parameters: %s\n", err)
+			break
+		}
+	}
+	dec.Offset = endOffset
+	return mediaType, params, nil
+}
+
+func (dec *Decoder) readParameter(params map[string]string) error {
+	next, err := dec.Peek()
+	if err != nil {
+		return err
+	}
+	if next >= TEXT_MIN && next <= TEXT_MAX {
+		// Untyped-parameter = Token-text Untyped-value
+		name, err := dec.ReadString()
+		if err != nil {
+			return err
+		}
+		if next, err = dec.Peek(); err != nil {
+			return err
+		}
+		if next >= TEXT_MIN && next <= TEXT_MAX || next == 0 {
+			params[strings.ToLower(name)], err = dec.ReadString()
+			return err
+		}
+		v, err := dec.ReadInteger()
+		params[strings.ToLower(name)] = strconv.FormatUint(v, 10)
+		return err
+	}
+
+	code, err := dec.ReadInteger()
+	if err != nil {
+		return err
+	}
+	switch code {
+	case PARAMETER_TYPE_Q:
+		q, err := dec.ReadQ()
+		params["q"] = strconv.FormatFloat(q, 'f', -1, 64)
+		return err
+	case PARAMETER_TYPE_CHARSET:
+		v, err := dec.ReadInteger()
+		if err != nil {
+			return err
+		}
+		if charset, ok := CHARSETS[v]; ok {
+			params["charset"] = charset
+		} else {
+			params["charset"] = strconv.FormatUint(v, 10)
+		}
+	case PARAMETER_TYPE_TYPE, PARAMETER_TYPE_SIZE:
+		v, err := dec.ReadInteger()
+		if err != nil {
+			return err
+		}
+		params[parameterNames[code]] = strconv.FormatUint(v, 10)
+	case PARAMETER_TYPE_PADDING:
+		v, err := dec.ReadShortInteger()
+		params["padding"] = strconv.FormatUint(uint64(v), 10)
+		return err
+	case PARAMETER_TYPE_SEC:
+		v, err := dec.ReadShortInteger()
+		if err != nil {
+			return err
+		}
+		if sec, ok := SEC_METHODS[v]; ok {
+			params["sec"] = sec
+		} else {
+			params["sec"] = strconv.FormatUint(uint64(v), 10)
+		}
+	case PARAMETER_TYPE_CONTENT_TYPE:
+		v, err := dec.ReadMediaType()
+		params["type"] = v
+		return err
+	default:
+		name, ok := parameterNames[code]
+		if !ok {
+			return fmt.Errorf("unhandled parameter %#x", code)
+		}
+		v, err := dec.ReadString()
+		params[name] = v
+		return err
+	}
+	return nil
+}
+
+//parameterNames holds the names of the parameters with a Text-string or
+//Text-value, along with the few numeric ones that need no special handling
+var parameterNames = map[uint64]string{
+	PARAMETER_TYPE_TYPE:               "type",
+	PARAMETER_TYPE_NAME_DEFUNCT:       "name",
+	PARAMETER_TYPE_FILENAME_DEFUNCT:   "filename",
+	PARAMETER_TYPE_START_DEFUNCT:      "start",
+	PARAMETER_TYPE_START_INFO_DEFUNCT: "start-info",
+	PARAMETER_TYPE_COMMENT_DEFUNCT:    "comment",
+	PARAMETER_TYPE_DOMAIN_DEFUNCT:     "domain",
+	PARAMETER_TYPE_PATH_DEFUNCT:       "path",
+	PARAMETER_TYPE_MAC:                "mac",
+	PARAMETER_TYPE_SIZE:               "size",
+	PARAMETER_TYPE_NAME:               "name",
+	PARAMETER_TYPE_FILENAME:           "filename",
+	PARAMETER_TYPE_START:              "start",
+	PARAMETER_TYPE_START_INFO:         "start-info",
+	PARAMETER_TYPE_COMMENT:            "comment",
+	PARAMETER_TYPE_DOMAIN:             "domain",
+	PARAMETER_TYPE_PATH:               "path",
+}
@@ -0,0 +1,273 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of wsp.
+ *
+ * wsp is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * wsp is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package wsp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+//Encoder writes values encoded with the Basic Rules described in section
+//8.4.2 of WAP-230-WSP-20010705-a.
+type Encoder struct {
+	w io.Writer
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+//SetParam writes a well known field name or parameter code as a short
+//integer.
+func (enc *Encoder) SetParam(param byte) error {
+	return enc.WriteByte(param | SHORT_FILTER)
+}
+
+func (enc *Encoder) WriteString(s string) error {
+	bytes := []byte(s)
+	bytes = append(bytes, 0)
+	_, err := enc.w.Write(bytes)
+	return err
+}
+
+//WriteQuotedString writes s as a Quoted-string, used for instance by the
+//Content-ID header.
+func (enc *Encoder) WriteQuotedString(s string) error {
+	if err := enc.WriteByte(STRING_QUOTE); err != nil {
+		return err
+	}
+	return enc.WriteString(s)
+}
+
+func (enc *Encoder) WriteBytes(b []byte, count int) error {
+	if n, err := enc.w.Write(b); n != count {
+		return fmt.Errorf("expected to write %d byte[s] but wrote %d", count, n)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+//WriteReader copies count bytes from r, it is meant for payloads that are
+//too big to be held in memory.
+func (enc *Encoder) WriteReader(r io.Reader, count int64) error {
+	if n, err := io.CopyN(enc.w, r, count); err != nil {
+		return fmt.Errorf("expected to write %d byte[s] but wrote %d: %s", count, n, err)
+	}
+	return nil
+}
+
+func (enc *Encoder) WriteByte(b byte) error {
+	return enc.WriteBytes([]byte{b}, 1)
+}
+
+// WriteShortInteger encodes i according to the Basic Rules described in section
+// 8.4.2.2 of WAP-230-WSP-20010705-a.
+//
+// Integers in range 0-127 (< 0x80) shall be encoded as a one octet value
+// with the most significant bit set to one (1xxx xxxx == |0x80) and with
+// the value in the remaining least significant bits.
+func (enc *Encoder) WriteShortInteger(i uint64) error {
+	return enc.WriteByte(byte(i | SHORT_FILTER))
+}
+
+// WriteLongInteger encodes i according to the Basic Rules described in section
+// 8.4.2.2 of WAP-230-WSP-20010705-a.
+//
+// Long-integer = Short-length Multi-octet-integer
+// The Short-length indicates the length of the Multi-octet-integer
+//
+// Multi-octet-integer = 1*30 OCTET
+// The content octets shall be an unsigned integer value
+// with the most significant octet encoded first (big-endian representation).
+// The minimum number of octets must be used to encode the value.
+func (enc *Encoder) WriteLongInteger(i uint64) error {
+	encodedLong := EncodeLong(i)
+	encLength := uint64(len(encodedLong))
+	if encLength > SHORT_LENGTH_MAX {
+		return fmt.Errorf("cannot encode long integer, lenght was %d but expected %d", encLength, SHORT_LENGTH_MAX)
+	}
+	if err := enc.WriteByte(byte(encLength)); err != nil {
+		return err
+	}
+
+	return enc.WriteBytes(encodedLong, len(encodedLong))
+}
+
+//EncodeLong returns the Multi-octet-integer representation of i.
+func EncodeLong(i uint64) (encodedLong []byte) {
+	for i > 0 {
+		b := byte(0xff & i)
+		encodedLong = append([]byte{b}, encodedLong...)
+		i = i >> 8
+	}
+	return encodedLong
+}
+
+// WriteInteger encodes i according to the Basic Rules described in section
+// 8.4.2.2 of WAP-230-WSP-20010705-a.
+//
+// It encodes as a Short-integer when i < 128 (=0x80) or as a Long-Integer
+// otherwise
+func (enc *Encoder) WriteInteger(i uint64) error {
+	if i < 0x80 {
+		return enc.WriteShortInteger(i)
+	}
+	return enc.WriteLongInteger(i)
+}
+
+// WriteUintVar encodes v according to section 8.1.2 and the Basic Rules
+// described in section 8.4.2.2 of WAP-230-WSP-20010705-a.
+//
+// To encode a large unsigned integer, split it into 7-bit (0x7f) fragments
+// and place them in the payloads of multiple octets. The most significant
+// bits are placed in the first octets with the least significant bits ending
+// up in the last octet. All octets MUST set the Continue bit to 1 (|0x80)
+// except the last octet, which MUST set the Continue bit to 0.
+//
+// The unsigned integer MUST be encoded in the smallest encoding possible.
+// In other words, the encoded value MUST NOT start with an octet with the
+// value 0x80.
+func (enc *Encoder) WriteUintVar(v uint64) error {
+	uintVar := []byte{byte(v & 0x7f)}
+	v = v >> 7
+	for v > 0 {
+		uintVar = append([]byte{byte(0x80 | (v & 0x7f))}, uintVar...)
+		v = v >> 7
+	}
+	return enc.WriteBytes(uintVar, len(uintVar))
+}
+
+// WriteLength encodes length as a Value-length as described in section
+// 8.4.2.2 of WAP-230-WSP-20010705-a.
+func (enc *Encoder) WriteLength(length uint64) error {
+	if length <= SHORT_LENGTH_MAX {
+		return enc.WriteByte(byte(length))
+	}
+	if err := enc.WriteByte(LENGTH_QUOTE); err != nil {
+		return err
+	}
+	return enc.WriteUintVar(length)
+}
+
+//EncodeContentType returns the well known code for media if there is one.
+func EncodeContentType(media string) (uint64, error) {
+	for mt := range CONTENT_TYPES {
+		if CONTENT_TYPES[mt] == media {
+			return uint64(mt), nil
+		}
+	}
+	return 0, errors.New("cannot binary encode media")
+}
+
+//WriteMediaType writes media as a Constrained-media, using the well known
+//code when available.
+func (enc *Encoder) WriteMediaType(media string) error {
+	if mt, err := EncodeContentType(media); err == nil {
+		return enc.WriteInteger(mt)
+	}
+
+	// +1 is the byte{0}
+	if err := enc.WriteByte(byte(len(media) + 1)); err != nil {
+		return err
+	}
+	return enc.WriteString(media)
+}
+
+//EncodeCharset returns the well known code for charset or ANY_CHARSET if
+//it has no assignment.
+func EncodeCharset(charset string) uint64 {
+	charsetCode := uint64(ANY_CHARSET)
+	for k, v := range CHARSETS {
+		if v == charset {
+			charsetCode = k
+		}
+	}
+	return charsetCode
+}
+
+//WriteContentType writes media as a Content-type-value, the inverse of
+//ReadContentType. Without params the constrained form is used, otherwise
+//the general form with the sec, mac and charset parameters typed and the
+//rest as untyped text parameters.
+func (enc *Encoder) WriteContentType(media string, params map[string]string) error {
+	if len(params) == 0 {
+		return enc.WriteMediaType(media)
+	}
+
+	var buf bytes.Buffer
+	general := NewEncoder(&buf)
+	if mt, err := EncodeContentType(media); err == nil {
+		if err := general.WriteInteger(mt); err != nil {
+			return err
+		}
+	} else if err := general.WriteString(media); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := general.writeParameter(name, params[name]); err != nil {
+			return err
+		}
+	}
+
+	if err := enc.WriteLength(uint64(buf.Len())); err != nil {
+		return err
+	}
+	return enc.WriteBytes(buf.Bytes(), buf.Len())
+}
+
+func (enc *Encoder) writeParameter(name, value string) error {
+	switch name {
+	case "sec":
+		for code, method := range SEC_METHODS {
+			if method == value {
+				if err := enc.SetParam(PARAMETER_TYPE_SEC); err != nil {
+					return err
+				}
+				return enc.WriteShortInteger(uint64(code))
+			}
+		}
+	case "mac":
+		if err := enc.SetParam(PARAMETER_TYPE_MAC); err != nil {
+			return err
+		}
+		return enc.WriteString(value)
+	case "charset":
+		if err := enc.SetParam(PARAMETER_TYPE_CHARSET); err != nil {
+			return err
+		}
+		return enc.WriteInteger(EncodeCharset(value))
+	}
+	if err := enc.WriteString(name); err != nil {
+		return err
+	}
+	return enc.WriteString(value)
+}
@@ -0,0 +1,281 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of wsp.
+ *
+ * wsp is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * wsp is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package wsp
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+//Codec decodes and encodes the value of a header field. Decode is called
+//with the decoder positioned right after the field name and returns the
+//value as one of string, uint64 or []byte; Encode writes the value only,
+//the field name is written by the caller.
+type Codec struct {
+	Decode func(dec *Decoder) (interface{}, error)
+	Encode func(enc *Encoder, v interface{}) error
+}
+
+//Header describes a well known header field.
+type Header struct {
+	//Code is the field name assignment without the short integer bit set
+	Code byte
+	//Name is the textual field name, used to match application headers
+	Name string
+	//Field is the name of the structure member the value is stored in
+	Field string
+	Codec Codec
+	//Version is the encoding version the header was assigned in
+	Version byte
+}
+
+//Registry maps header codes and names to their description, it is safe for
+//concurrent use so vendor headers can be registered at any time.
+type Registry struct {
+	lock    sync.RWMutex
+	byCode  map[byte]*Header
+	byName  map[string]*Header
+	byField map[string]*Header
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		byCode:  make(map[byte]*Header),
+		byName:  make(map[string]*Header),
+		byField: make(map[string]*Header),
+	}
+}
+
+//Register adds hdr to the registry, registering the same code or name
+//twice is an error.
+func (r *Registry) Register(hdr Header) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if h, ok := r.byCode[hdr.Code]; ok {
+		return fmt.Errorf("header code %#x already registered as %s", hdr.Code, h.Name)
+	}
+	name := strings.ToLower(hdr.Name)
+	if _, ok := r.byName[name]; ok {
+		return fmt.Errorf("header %s already registered", hdr.Name)
+	}
+	h := &hdr
+	r.byCode[hdr.Code] = h
+	r.byName[name] = h
+	if hdr.Field != "" {
+		r.byField[hdr.Field] = h
+	}
+	return nil
+}
+
+//MustRegister is like Register but panics on error, it is meant for
+//initializing package level registries.
+func (r *Registry) MustRegister(hdrs ...Header) *Registry {
+	for _, hdr := range hdrs {
+		if err := r.Register(hdr); err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
+
+func (r *Registry) Lookup(code byte) (*Header, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	h, ok := r.byCode[code]
+	return h, ok
+}
+
+//LookupName finds a header by its textual name, case insensitively.
+func (r *Registry) LookupName(name string) (*Header, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	h, ok := r.byName[strings.ToLower(name)]
+	return h, ok
+}
+
+func (r *Registry) LookupField(field string) (*Header, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	h, ok := r.byField[field]
+	return h, ok
+}
+
+//SetField stores a decoded value in the field member of the structure
+//held by rv. Strings and byte slices are appended when the member is a
+//slice of them. It returns false if rv has no such member.
+func SetField(rv reflect.Value, field string, v interface{}) (bool, error) {
+	f := rv.FieldByName(field)
+	if !f.IsValid() {
+		return false, nil
+	}
+	value := reflect.ValueOf(v)
+	switch {
+	case f.Kind() == reflect.Slice && value.Type().AssignableTo(f.Type().Elem()):
+		f.Set(reflect.Append(f, value))
+	case value.Type().AssignableTo(f.Type()):
+		f.Set(value)
+	case value.Kind() == reflect.Uint64 && f.Kind() >= reflect.Uint && f.Kind() <= reflect.Uint64:
+		f.SetUint(value.Uint())
+	default:
+		return true, fmt.Errorf("cannot store %v of type %s in %s of type %s", v, value.Type(), field, f.Type())
+	}
+	return true, nil
+}
+
+var (
+	//TextString is a null terminated string, optionally quoted
+	TextString = Codec{
+		Decode: func(dec *Decoder) (interface{}, error) { return dec.ReadString() },
+		Encode: func(enc *Encoder, v interface{}) error { return enc.WriteString(v.(string)) },
+	}
+	//QuotedString is a TextString that is always written with a leading quote
+	QuotedString = Codec{
+		Decode: func(dec *Decoder) (interface{}, error) { return dec.ReadString() },
+		Encode: func(enc *Encoder, v interface{}) error { return enc.WriteQuotedString(v.(string)) },
+	}
+	//Octet is a single raw byte
+	Octet = Codec{
+		Decode: func(dec *Decoder) (interface{}, error) {
+			b, err := dec.ReadByte()
+			return uint64(b), err
+		},
+		Encode: func(enc *Encoder, v interface{}) error { return enc.WriteByte(byte(v.(uint64))) },
+	}
+	ShortInteger = Codec{
+		Decode: func(dec *Decoder) (interface{}, error) {
+			b, err := dec.ReadShortInteger()
+			return uint64(b), err
+		},
+		Encode: func(enc *Encoder, v interface{}) error { return enc.WriteShortInteger(v.(uint64)) },
+	}
+	LongInteger = Codec{
+		Decode: func(dec *Decoder) (interface{}, error) { return dec.ReadLongInteger() },
+		Encode: func(enc *Encoder, v interface{}) error { return enc.WriteLongInteger(v.(uint64)) },
+	}
+	Integer = Codec{
+		Decode: func(dec *Decoder) (interface{}, error) { return dec.ReadInteger() },
+		Encode: func(enc *Encoder, v interface{}) error { return enc.WriteInteger(v.(uint64)) },
+	}
+	UintVar = Codec{
+		Decode: func(dec *Decoder) (interface{}, error) { return dec.ReadUintVar() },
+		Encode: func(enc *Encoder, v interface{}) error { return enc.WriteUintVar(v.(uint64)) },
+	}
+	//EncodedString decodes an Encoded-string-value, the charset is dropped
+	//and values are always encoded as plain text strings
+	EncodedString = Codec{
+		Decode: func(dec *Decoder) (interface{}, error) { return dec.ReadEncodedString() },
+		Encode: func(enc *Encoder, v interface{}) error { return enc.WriteString(v.(string)) },
+	}
+	MediaType = Codec{
+		Decode: func(dec *Decoder) (interface{}, error) { return dec.ReadMediaType() },
+		Encode: func(enc *Encoder, v interface{}) error { return enc.WriteMediaType(v.(string)) },
+	}
+	//Version is a Version-value where the short integer form holds the
+	//major version in the high nibble and the minor one in the low nibble
+	Version = Codec{
+		Decode: func(dec *Decoder) (interface{}, error) {
+			next, err := dec.Peek()
+			if err != nil {
+				return nil, err
+			}
+			if next&SHORT_FILTER != 0 {
+				b, err := dec.ReadShortInteger()
+				return uint64(b), err
+			}
+			return dec.ReadString()
+		},
+		Encode: func(enc *Encoder, v interface{}) error {
+			if s, ok := v.(string); ok {
+				return enc.WriteString(s)
+			}
+			return enc.WriteShortInteger(v.(uint64))
+		},
+	}
+	//IntegerOrText decodes values that are either an Integer-value or a
+	//Text-string, such as the application id
+	IntegerOrText = Codec{
+		Decode: func(dec *Decoder) (interface{}, error) {
+			next, err := dec.Peek()
+			if err != nil {
+				return nil, err
+			}
+			if next >= TEXT_MIN && next <= TEXT_MAX {
+				return dec.ReadString()
+			}
+			return dec.ReadInteger()
+		},
+		Encode: func(enc *Encoder, v interface{}) error {
+			if s, ok := v.(string); ok {
+				return enc.WriteString(s)
+			}
+			return enc.WriteInteger(v.(uint64))
+		},
+	}
+	//Generic skips over any field value, returning the raw bytes
+	Generic = Codec{
+		Decode: func(dec *Decoder) (interface{}, error) {
+			begin := dec.Offset + 1
+			if err := dec.SkipFieldValue(); err != nil {
+				return nil, err
+			}
+			return dec.Data[begin : dec.Offset+1], nil
+		},
+		Encode: func(enc *Encoder, v interface{}) error {
+			b := v.([]byte)
+			return enc.WriteBytes(b, len(b))
+		},
+	}
+)
+
+//Headers holds the well known header field names from Table 39. Header
+//Field Name Assignments - Appendix A Assigned Numbers in WAP-230-WSP that
+//the push and MMS codecs know how to handle.
+var Headers = NewRegistry().MustRegister(
+	Header{Code: ACCEPT, Name: "Accept", Codec: MediaType, Version: VERSION_1_1},
+	Header{Code: AGE, Name: "Age", Codec: Integer, Version: VERSION_1_1},
+	Header{Code: CONTENT_BASE, Name: "Content-Base", Codec: TextString, Version: VERSION_1_1},
+	Header{Code: CONTENT_LANGUAGE, Name: "Content-Language", Codec: IntegerOrText, Version: VERSION_1_1},
+	Header{Code: CONTENT_LENGTH, Name: "Content-Length", Field: "ContentLength", Codec: Integer, Version: VERSION_1_1},
+	Header{Code: CONTENT_LOCATION, Name: "Content-Location", Field: "ContentLocation", Codec: TextString, Version: VERSION_1_1},
+	Header{Code: CONTENT_MD5, Name: "Content-MD5", Codec: Generic, Version: VERSION_1_1},
+	Header{Code: CONTENT_TYPE, Name: "Content-Type", Field: "ContentType", Codec: MediaType, Version: VERSION_1_1},
+	Header{Code: DATE, Name: "Date", Field: "Date", Codec: LongInteger, Version: VERSION_1_1},
+	Header{Code: ETAG, Name: "Etag", Codec: TextString, Version: VERSION_1_1},
+	Header{Code: EXPIRES, Name: "Expires", Codec: LongInteger, Version: VERSION_1_1},
+	Header{Code: LOCATION, Name: "Location", Codec: TextString, Version: VERSION_1_1},
+	Header{Code: LAST_MODIFIED, Name: "Last-Modified", Codec: LongInteger, Version: VERSION_1_1},
+	Header{Code: USER_AGENT, Name: "User-Agent", Codec: TextString, Version: VERSION_1_1},
+	Header{Code: X_WAP_APPLICATION_ID, Name: "X-Wap-Application-Id", Field: "ApplicationId", Codec: IntegerOrText, Version: VERSION_1_2},
+	Header{Code: X_WAP_CONTENT_URI, Name: "X-Wap-Content-URI", Field: "ContentURI", Codec: TextString, Version: VERSION_1_2},
+	Header{Code: X_WAP_INITIATOR_URI, Name: "X-Wap-Initiator-URI", Field: "InitiatorURI", Codec: TextString, Version: VERSION_1_2},
+	Header{Code: BEARER_INDICATION, Name: "Bearer-Indication", Codec: Integer, Version: VERSION_1_2},
+	Header{Code: PUSH_FLAG, Name: "Push-Flag", Field: "PushFlag", Codec: ShortInteger, Version: VERSION_1_2},
+	Header{Code: PROFILE, Name: "Profile", Codec: TextString, Version: VERSION_1_2},
+	Header{Code: CONTENT_RANGE, Name: "Content-Range", Codec: Generic, Version: VERSION_1_3},
+	Header{Code: X_WAP_TOD, Name: "X-Wap-Tod", Codec: LongInteger, Version: VERSION_1_3},
+	Header{Code: CONTENT_ID, Name: "Content-ID", Field: "ContentId", Codec: QuotedString, Version: VERSION_1_4},
+	Header{Code: ENCODING_VERSION, Name: "Encoding-Version", Field: "EncodingVersion", Codec: Version, Version: VERSION_1_4},
+	Header{Code: X_WAP_SECURITY, Name: "X-Wap-Security", Codec: Generic, Version: VERSION_1_4},
+	Header{Code: CACHE_CONTROL, Name: "Cache-Control", Codec: Generic, Version: VERSION_1_4},
+)
@@ -0,0 +1,145 @@
+/*
+ * Copyright 2014 Canonical Ltd.
+ *
+ * Authors:
+ * Sergio Schvezov: sergio.schvezov@cannical.com
+ *
+ * This file is part of wsp.
+ *
+ * wsp is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; version 3.
+ *
+ * wsp is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package wsp
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	. "launchpad.net/gocheck"
+)
+
+type HeadersTestSuite struct{}
+
+var _ = Suite(&HeadersTestSuite{})
+
+func Test(t *testing.T) { TestingT(t) }
+
+func (s *HeadersTestSuite) TestLookup(c *C) {
+	hdr, ok := Headers.Lookup(X_WAP_APPLICATION_ID)
+	c.Assert(ok, Equals, true)
+	c.Check(hdr.Name, Equals, "X-Wap-Application-Id")
+	c.Check(hdr.Field, Equals, "ApplicationId")
+
+	hdr, ok = Headers.LookupName("content-type")
+	c.Assert(ok, Equals, true)
+	c.Check(hdr.Code, Equals, byte(CONTENT_TYPE))
+
+	hdr, ok = Headers.LookupField("PushFlag")
+	c.Assert(ok, Equals, true)
+	c.Check(hdr.Code, Equals, byte(PUSH_FLAG))
+
+	_, ok = Headers.Lookup(WARNING)
+	c.Check(ok, Equals, false)
+}
+
+func (s *HeadersTestSuite) TestRegisterDuplicate(c *C) {
+	r := NewRegistry()
+	c.Assert(r.Register(Header{Code: 0x50, Name: "X-Vendor", Codec: TextString}), IsNil)
+	c.Check(r.Register(Header{Code: 0x50, Name: "X-Other", Codec: TextString}), NotNil)
+	c.Check(r.Register(Header{Code: 0x51, Name: "x-vendor", Codec: TextString}), NotNil)
+}
+
+func (s *HeadersTestSuite) TestVendorHeaderRoundTrip(c *C) {
+	type vendorPdu struct {
+		Vendor string
+		Codes  []uint64
+	}
+	r := NewRegistry().MustRegister(
+		Header{Code: 0x50, Name: "X-Vendor", Field: "Vendor", Codec: TextString},
+		Header{Code: 0x51, Name: "X-Vendor-Code", Field: "Codes", Codec: Integer},
+	)
+
+	var b bytes.Buffer
+	enc := NewEncoder(&b)
+	c.Assert(enc.SetParam(0x50), IsNil)
+	c.Assert(TextString.Encode(enc, "value"), IsNil)
+	c.Assert(enc.SetParam(0x51), IsNil)
+	c.Assert(Integer.Encode(enc, uint64(3)), IsNil)
+	c.Assert(enc.SetParam(0x51), IsNil)
+	c.Assert(Integer.Encode(enc, uint64(300)), IsNil)
+
+	var pdu vendorPdu
+	dec := NewDecoder(b.Bytes())
+	dec.Offset = -1
+	for dec.Offset+1 < len(dec.Data) {
+		code, err := dec.ReadShortInteger()
+		c.Assert(err, IsNil)
+		hdr, ok := r.Lookup(code)
+		c.Assert(ok, Equals, true)
+		v, err := hdr.Codec.Decode(dec)
+		c.Assert(err, IsNil)
+		stored, err := SetField(reflect.ValueOf(&pdu).Elem(), hdr.Field, v)
+		c.Assert(err, IsNil)
+		c.Assert(stored, Equals, true)
+	}
+	c.Check(pdu.Vendor, Equals, "value")
+	c.Check(pdu.Codes, DeepEquals, []uint64{3, 300})
+}
+
+func (s *HeadersTestSuite) TestSetFieldMissing(c *C) {
+	var pdu struct{ Flag byte }
+	stored, err := SetField(reflect.ValueOf(&pdu).Elem(), "Other", uint64(1))
+	c.Check(stored, Equals, false)
+	c.Check(err, IsNil)
+
+	stored, err = SetField(reflect.ValueOf(&pdu).Elem(), "Flag", uint64(4))
+	c.Check(stored, Equals, true)
+	c.Check(err, IsNil)
+	c.Check(pdu.Flag, Equals, byte(4))
+
+	_, err = SetField(reflect.ValueOf(&pdu).Elem(), "Flag", "text")
+	c.Check(err, NotNil)
+}
+
+func (s *HeadersTestSuite) TestMediaTypeRoundTrip(c *C) {
+	for _, media := range []string{"application/vnd.wap.mms-message", "application/x-vendor"} {
+		var b bytes.Buffer
+		c.Assert(MediaType.Encode(NewEncoder(&b), media), IsNil)
+		dec := NewDecoder(b.Bytes())
+		dec.Offset = -1
+		v, err := MediaType.Decode(dec)
+		c.Assert(err, IsNil)
+		c.Check(v, Equals, media)
+	}
+}
+
+func (s *HeadersTestSuite) TestReadPastEnd(c *C) {
+	dec := NewDecoder([]byte{0x8d})
+	_, err := dec.ReadLongInteger()
+	c.Check(err, NotNil)
+	_, err = dec.ReadString()
+	c.Check(err, NotNil)
+}
+
+func (s *HeadersTestSuite) TestReadContentTypeParams(c *C) {
+	// application/vnd.wap.connectivity-wbxml; SEC=NETWPIN; MAC=ABCD
+	data := []byte{0x09, 0xB6, 0x91, 0x80, 0x92, 'A', 'B', 'C', 'D', 0x00, 0xFF}
+	dec := NewDecoder(data)
+	dec.Offset = -1
+	mediaType, params, err := dec.ReadContentType()
+	c.Assert(err, IsNil)
+	c.Check(mediaType, Equals, "application/vnd.wap.connectivity-wbxml")
+	c.Check(params, DeepEquals, map[string]string{"sec": "NETWPIN", "mac": "ABCD"})
+	c.Check(dec.Offset, Equals, 9)
+}